@@ -0,0 +1,380 @@
+package calculator
+
+import (
+	"bytes"
+	"errors"
+	"fetch-assessment/model"
+	"fetch-assessment/utils"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// calendar matches the time of a purchase against time windows, weekdays, days of the month and holidays. A purchase
+// matches if it meets every condition that is set.
+type calendar struct {
+	windows []timeWindow
+	// weekdays and monthDays hold the accepted days, and parity is odd or even, if set.
+	weekdays  []time.Weekday
+	monthDays []int
+	parity    string
+	holidays  *holidayCalendar
+	// zone is the time zone the purchase is converted to. Without it, the date and time are read as printed.
+	zone *time.Location
+}
+
+// timeWindow is the time of day from minute from, inclusive, to minute to, exclusive. A window with to before from
+// extends past midnight.
+type timeWindow struct {
+	from, to int
+}
+
+func (w timeWindow) contains(minutes int) bool {
+	if w.from < w.to {
+		return minutes >= w.from && minutes < w.to
+	}
+	return minutes >= w.from || minutes < w.to
+}
+
+func (w timeWindow) String() string {
+	return fmt.Sprintf("%s and %s", formatMinutes(w.from), formatMinutes(w.to))
+}
+
+// calendarRule awards the points if the purchase matches the calendar given by the windows, weekdays, monthDays,
+// holidays and timeZone parameters. At least one of the first four is required.
+func calendarRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	var c calendar
+	var err error
+	if c.windows, err = windowsParam(p, "windows"); err != nil {
+		return nil, err
+	}
+	if c.weekdays, err = weekdaysParam(p, "weekdays"); err != nil {
+		return nil, err
+	}
+	if c.monthDays, c.parity, err = monthDaysParam(p, "monthDays"); err != nil {
+		return nil, err
+	}
+	if c.holidays, err = holidaysParam(p, "holidays"); err != nil {
+		return nil, err
+	}
+	if c.zone, err = p.timeZone("timeZone"); err != nil {
+		return nil, err
+	}
+	if len(c.windows) == 0 && len(c.weekdays) == 0 && len(c.monthDays) == 0 && c.parity == "" && c.holidays == nil {
+		return nil, errors.New("at least one of the parameters windows, weekdays, monthDays and holidays is required")
+	}
+	return c.rule(config.Points), nil
+}
+
+// rule returns the evaluate function of a rule awarding points if the purchase matches the calendar.
+func (c calendar) rule(points int) func(model.Receipt) (int, string) {
+	return func(receipt model.Receipt) (int, string) {
+		ok, reason := c.match(receipt)
+		if ok {
+			return points, reason
+		}
+		return 0, reason
+	}
+}
+
+// match reports whether the purchase matches the calendar, with the reason for every condition.
+func (c calendar) match(receipt model.Receipt) (bool, string) {
+	// parsing errors can be ignored due to preceding validation rules
+	date := receipt.PurchaseDate.Time
+	hour, minute, _ := utils.ParsePurchaseTime(receipt.PurchaseTime)
+	minutes := hour*60 + minute
+	purchaseTime := receipt.PurchaseTime
+	in := ""
+	if c.zone != nil {
+		instant, _ := utils.PurchaseInstant(receipt, c.zone)
+		date = instant.In(c.zone)
+		minutes = date.Hour()*60 + date.Minute()
+		purchaseTime = date.Format("15:04")
+		in = " in " + c.zone.String()
+	}
+
+	holds := true
+	reasons := make([]string, 0, 4)
+	check := func(ok bool, reason string, args ...any) {
+		holds = holds && ok
+		reasons = append(reasons, fmt.Sprintf(reason, args...))
+	}
+	if len(c.windows) > 0 {
+		windows := make([]string, 0, len(c.windows))
+		within := false
+		for _, window := range c.windows {
+			within = within || window.contains(minutes)
+			windows = append(windows, window.String())
+		}
+		if within {
+			check(true, "purchase time %s%s is between %s", purchaseTime, in, strings.Join(windows, " or "))
+		} else {
+			check(false, "purchase time %s%s is not between %s", purchaseTime, in, strings.Join(windows, " or "))
+		}
+	}
+	day := date.Day()
+	if c.parity != "" {
+		parity := "even"
+		if day%2 != 0 {
+			parity = "odd"
+		}
+		check(parity == c.parity, "purchase day %d%s is %s", day, in, parity)
+	}
+	if len(c.monthDays) > 0 {
+		days := make([]string, 0, len(c.monthDays))
+		for _, monthDay := range c.monthDays {
+			days = append(days, strconv.Itoa(monthDay))
+		}
+		if contains(c.monthDays, day) {
+			check(true, "purchase day %d%s is one of %s", day, in, strings.Join(days, ", "))
+		} else {
+			check(false, "purchase day %d%s is not one of %s", day, in, strings.Join(days, ", "))
+		}
+	}
+	if len(c.weekdays) > 0 {
+		weekdays := make([]string, 0, len(c.weekdays))
+		for _, weekday := range c.weekdays {
+			weekdays = append(weekdays, weekday.String())
+		}
+		if contains(c.weekdays, date.Weekday()) {
+			check(true, "purchase weekday %s%s is one of %s", date.Weekday(), in, strings.Join(weekdays, ", "))
+		} else {
+			check(false, "purchase weekday %s%s is not one of %s", date.Weekday(), in, strings.Join(weekdays, ", "))
+		}
+	}
+	if c.holidays != nil {
+		key := date.Format("2006-01-02")
+		if holiday, ok := c.holidays.days[key]; ok {
+			check(true, "purchase date %s%s is %s in the %s holiday calendar", key, in, holiday, c.holidays.name)
+		} else {
+			check(false, "purchase date %s%s is not a holiday in the %s holiday calendar", key, in, c.holidays.name)
+		}
+	}
+	return holds, strings.Join(reasons, ", ")
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// windowPattern matches a time window in the format HH:MM-HH:MM.
+var windowPattern = regexp.MustCompile(`^(\d{2}:\d{2})\s*-\s*(\d{2}:\d{2})$`)
+
+// windowsParam returns the time windows of an optional list parameter.
+func windowsParam(p *params, name string) ([]timeWindow, error) {
+	values, err := p.list(name)
+	if err != nil {
+		return nil, err
+	}
+	windows := make([]timeWindow, 0, len(values))
+	for i, value := range values {
+		text, _ := value.(string)
+		match := windowPattern.FindStringSubmatch(text)
+		var from, to int
+		if match != nil {
+			from, err = parseMinutes(match[1])
+			if err == nil {
+				to, err = parseMinutes(match[2])
+			}
+		}
+		if match == nil || err != nil {
+			return nil, fmt.Errorf("parameter %s: window %d must be in the format HH:MM-HH:MM, got %v", name, i+1, value)
+		}
+		if from == to {
+			return nil, fmt.Errorf("parameter %s: window %d must not be empty", name, i+1)
+		}
+		windows = append(windows, timeWindow{from: from, to: to})
+	}
+	return windows, nil
+}
+
+// weekdaysParam returns the weekdays of an optional list parameter, given by their English names or the first three
+// letters of them, ignoring case.
+func weekdaysParam(p *params, name string) ([]time.Weekday, error) {
+	values, err := p.list(name)
+	if err != nil {
+		return nil, err
+	}
+	weekdays := make([]time.Weekday, 0, len(values))
+	for _, value := range values {
+		text, _ := value.(string)
+		weekday, ok := parseWeekday(text)
+		if !ok {
+			return nil, fmt.Errorf("parameter %s: unknown weekday %v", name, value)
+		}
+		weekdays = append(weekdays, weekday)
+	}
+	return weekdays, nil
+}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		full := strings.ToLower(weekday.String())
+		if name == full || name == full[:3] {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
+
+// monthDaysParam returns the days of an optional parameter that is either odd, even or a list of days of the month.
+func monthDaysParam(p *params, name string) ([]int, string, error) {
+	if !p.has(name) {
+		return nil, "", nil
+	}
+	value, _ := p.get(name)
+	if parity, ok := value.(string); ok && (parity == "odd" || parity == "even") {
+		return nil, parity, nil
+	}
+	values, _ := value.([]any)
+	days := make([]int, 0, len(values))
+	for _, v := range values {
+		day, ok := v.(int)
+		if !ok || day < 1 || day > 31 {
+			values = nil
+			break
+		}
+		days = append(days, day)
+	}
+	if len(values) == 0 {
+		return nil, "", fmt.Errorf("parameter %s must be odd, even or a list of days from 1 to 31, got %v", name, value)
+	}
+	sort.Ints(days)
+	return days, "", nil
+}
+
+// holidaysParam returns the active holiday calendar named by an optional parameter.
+func holidaysParam(p *params, name string) (*holidayCalendar, error) {
+	if !p.has(name) {
+		return nil, nil
+	}
+	calendarName, err := p.text(name)
+	if err != nil {
+		return nil, err
+	}
+	holidays, ok := ActiveHolidayCalendars().calendars[calendarName]
+	if !ok {
+		return nil, fmt.Errorf("parameter %s: unknown holiday calendar %q", name, calendarName)
+	}
+	return holidays, nil
+}
+
+// HolidayCalendarsConfig is the declarative definition of holiday calendars by name. It is read from YAML, or from
+// JSON, which is a subset of YAML.
+type HolidayCalendarsConfig struct {
+	Calendars map[string][]HolidayConfig `yaml:"calendars"`
+}
+
+// HolidayConfig is a holiday on the Date (YYYY-MM-DD) with the given Name.
+type HolidayConfig struct {
+	Date string `yaml:"date"`
+	Name string `yaml:"name"`
+}
+
+// HolidayCalendars holds the holiday calendars available to calendar rules.
+type HolidayCalendars struct {
+	calendars map[string]*holidayCalendar
+}
+
+type holidayCalendar struct {
+	name string
+	// days maps the dates of the holidays (YYYY-MM-DD) to their names.
+	days map[string]string
+}
+
+// activeHolidayCalendars holds the holiday calendars that rules are created with. There are none by default.
+var activeHolidayCalendars atomic.Pointer[HolidayCalendars]
+
+func init() {
+	activeHolidayCalendars.Store(&HolidayCalendars{calendars: map[string]*holidayCalendar{}})
+}
+
+// ActiveHolidayCalendars returns the holiday calendars that calendar rules are created with.
+func ActiveHolidayCalendars() *HolidayCalendars {
+	return activeHolidayCalendars.Load()
+}
+
+// ActivateHolidayCalendars makes calendars available to the calendar rules created afterwards. Rules already created
+// keep the calendars they were created with.
+func ActivateHolidayCalendars(calendars *HolidayCalendars) {
+	activeHolidayCalendars.Store(calendars)
+}
+
+// Names returns the names of the calendars in alphabetical order.
+func (h *HolidayCalendars) Names() []string {
+	names := make([]string, 0, len(h.calendars))
+	for name := range h.calendars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadHolidayCalendars reads the holiday calendars from the YAML or JSON file at path. See ParseHolidayCalendars.
+func LoadHolidayCalendars(path string) (*HolidayCalendars, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	calendars, err := ParseHolidayCalendars(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return calendars, nil
+}
+
+// ParseHolidayCalendars reads YAML or JSON holiday calendar definitions. Unknown fields are rejected, and all invalid
+// holidays are reported at once.
+func ParseHolidayCalendars(data []byte) (*HolidayCalendars, error) {
+	var config HolidayCalendarsConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("reading holiday calendars: %w", err)
+	}
+
+	var errs []error
+	calendars := &HolidayCalendars{calendars: make(map[string]*holidayCalendar, len(config.Calendars))}
+	names := make([]string, 0, len(config.Calendars))
+	for name := range config.Calendars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		holidays := config.Calendars[name]
+		days := make(map[string]string, len(holidays))
+		for i, holiday := range holidays {
+			date, err := time.Parse("2006-01-02", holiday.Date)
+			switch {
+			case err != nil:
+				err = fmt.Errorf("date must be in the format YYYY-MM-DD, got %q", holiday.Date)
+			case holiday.Name == "":
+				err = errors.New("name is required")
+			case days[date.Format("2006-01-02")] != "":
+				err = errors.New("date is already defined")
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("holiday calendar %s: holiday %d (%s): %w", name, i+1, holiday.Date, err))
+				continue
+			}
+			days[date.Format("2006-01-02")] = holiday.Name
+		}
+		calendars.calendars[name] = &holidayCalendar{name: name, days: days}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return calendars, nil
+}
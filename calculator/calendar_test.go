@@ -0,0 +1,269 @@
+package calculator
+
+import (
+	"fetch-assessment/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const holidayCalendars = `
+calendars:
+  us:
+    - {date: 2025-12-25, name: Christmas Day}
+    - {date: 2026-01-01, name: New Year's Day}
+  de:
+    - {date: 2025-12-26, name: Zweiter Weihnachtsfeiertag}
+`
+
+func TestCalendarRule(t *testing.T) {
+	holidays, err := ParseHolidayCalendars([]byte(holidayCalendars))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"de", "us"}, holidays.Names())
+	builtIn := ActiveHolidayCalendars()
+	t.Cleanup(func() { ActivateHolidayCalendars(builtIn) })
+	ActivateHolidayCalendars(holidays)
+
+	tests := []struct {
+		name       string
+		params     string
+		date       string
+		time       string
+		timeZone   string
+		wantPoints int
+		wantReason string
+	}{
+		{
+			name:       "time in one of several windows",
+			params:     `{windows: ["07:30-09:15", "17:45-19:00"]}`,
+			date:       "2025-12-15",
+			time:       "09:14",
+			wantPoints: 5,
+			wantReason: "purchase time 09:14 is between 07:30 and 09:15 or 17:45 and 19:00",
+		},
+		{
+			name:       "window excludes its end",
+			params:     `{windows: ["07:30-09:15", "17:45-19:00"]}`,
+			date:       "2025-12-15",
+			time:       "09:15",
+			wantReason: "purchase time 09:15 is not between 07:30 and 09:15 or 17:45 and 19:00",
+		},
+		{
+			name:       "time not zero-padded",
+			params:     `{windows: ["07:30-09:15"]}`,
+			date:       "2025-12-15",
+			time:       "8:5",
+			wantPoints: 5,
+			wantReason: "purchase time 8:5 is between 07:30 and 09:15",
+		},
+		{
+			name:       "window past midnight",
+			params:     `{windows: ["22:00-02:00"]}`,
+			date:       "2025-12-15",
+			time:       "01:59",
+			wantPoints: 5,
+			wantReason: "purchase time 01:59 is between 22:00 and 02:00",
+		},
+		{
+			name:       "weekday",
+			params:     `{weekdays: [sat, Sunday]}`,
+			date:       "2025-12-27",
+			time:       "12:00",
+			wantPoints: 5,
+			wantReason: "purchase weekday Saturday is one of Saturday, Sunday",
+		},
+		{
+			name:       "other weekday",
+			params:     `{weekdays: [sat, Sunday]}`,
+			date:       "2025-12-15",
+			time:       "12:00",
+			wantReason: "purchase weekday Monday is not one of Saturday, Sunday",
+		},
+		{
+			name:       "month days",
+			params:     `{monthDays: [15, 1]}`,
+			date:       "2025-12-15",
+			time:       "12:00",
+			wantPoints: 5,
+			wantReason: "purchase day 15 is one of 1, 15",
+		},
+		{
+			name:       "even month day",
+			params:     `{monthDays: even}`,
+			date:       "2025-12-15",
+			time:       "12:00",
+			wantReason: "purchase day 15 is odd",
+		},
+		{
+			name:       "holiday",
+			params:     `{holidays: us}`,
+			date:       "2025-12-25",
+			time:       "12:00",
+			wantPoints: 5,
+			wantReason: "purchase date 2025-12-25 is Christmas Day in the us holiday calendar",
+		},
+		{
+			name:       "holiday of another calendar",
+			params:     `{holidays: us}`,
+			date:       "2025-12-26",
+			time:       "12:00",
+			wantReason: "purchase date 2025-12-26 is not a holiday in the us holiday calendar",
+		},
+		{
+			name:       "all conditions hold",
+			params:     `{weekdays: [thu], windows: ["10:00-14:00"], holidays: us}`,
+			date:       "2025-12-25",
+			time:       "10:00",
+			wantPoints: 5,
+			wantReason: "purchase time 10:00 is between 10:00 and 14:00, purchase weekday Thursday is one of Thursday, " +
+				"purchase date 2025-12-25 is Christmas Day in the us holiday calendar",
+		},
+		{
+			name:   "one condition fails",
+			params: `{weekdays: [thu], windows: ["10:00-14:00"]}`,
+			date:   "2025-12-25",
+			time:   "14:00",
+			wantReason: "purchase time 14:00 is not between 10:00 and 14:00, " +
+				"purchase weekday Thursday is one of Thursday",
+		},
+		{
+			name:       "time zone",
+			params:     `{holidays: us, timeZone: America/New_York}`,
+			date:       "2026-01-01",
+			time:       "03:00",
+			timeZone:   "UTC",
+			wantReason: "purchase date 2025-12-31 in America/New_York is not a holiday in the us holiday calendar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := parseRuleSet([]byte(`{version: "1", rules: [{id: a, type: calendar, points: 5, params: ` +
+				tt.params + `}]}`))
+			require.NoError(t, err)
+			receipt := model.Receipt{PurchaseDate: mustParseDate(tt.date), PurchaseTime: tt.time}
+			if tt.timeZone != "" {
+				receipt.TimeZone = &tt.timeZone
+			}
+			points, reason := registry.Rules()[0].Evaluate(receipt)
+			assert.Equal(t, tt.wantPoints, points)
+			assert.Equal(t, tt.wantReason, reason)
+		})
+	}
+}
+
+func TestCalendarRuleRejectsInvalidParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		wantErr string
+	}{
+		{
+			name:    "no conditions",
+			params:  `{timeZone: UTC}`,
+			wantErr: "at least one of the parameters windows, weekdays, monthDays and holidays is required",
+		},
+		{
+			name:    "windows not a list",
+			params:  `{windows: "14:00-16:00"}`,
+			wantErr: "parameter windows must be a list, got 14:00-16:00",
+		},
+		{
+			name:    "invalid window",
+			params:  `{windows: ["14:00-16:00", "2pm-4pm"]}`,
+			wantErr: "parameter windows: window 2 must be in the format HH:MM-HH:MM, got 2pm-4pm",
+		},
+		{
+			name:    "invalid minute",
+			params:  `{windows: ["14:00-16:60"]}`,
+			wantErr: "parameter windows: window 1 must be in the format HH:MM-HH:MM, got 14:00-16:60",
+		},
+		{
+			name:    "empty window",
+			params:  `{windows: ["14:00-14:00"]}`,
+			wantErr: "parameter windows: window 1 must not be empty",
+		},
+		{
+			name:    "unknown weekday",
+			params:  `{weekdays: [mo]}`,
+			wantErr: "parameter weekdays: unknown weekday mo",
+		},
+		{
+			name:    "invalid month day",
+			params:  `{monthDays: [1, 32]}`,
+			wantErr: "parameter monthDays must be odd, even or a list of days from 1 to 31, got [1 32]",
+		},
+		{
+			name:    "invalid parity",
+			params:  `{monthDays: prime}`,
+			wantErr: "parameter monthDays must be odd, even or a list of days from 1 to 31, got prime",
+		},
+		{
+			name:    "unknown holiday calendar",
+			params:  `{holidays: us}`,
+			wantErr: `parameter holidays: unknown holiday calendar "us"`,
+		},
+		{
+			name:    "unknown parameter",
+			params:  `{weekdays: [mon], days: [1]}`,
+			wantErr: "unknown parameter days",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRuleSet([]byte(`{version: "1", rules: [{id: a, type: calendar, points: 5, params: ` +
+				tt.params + `}]}`))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestParseHolidayCalendarsRejectsInvalidHolidays(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		wantErr    string
+	}{
+		{
+			name:       "unknown field",
+			definition: `calendars: {us: [{date: 2025-12-25, title: Christmas Day}]}`,
+			wantErr:    "field title not found",
+		},
+		{
+			name:       "invalid date",
+			definition: `calendars: {us: [{date: 12/25/2025, name: Christmas Day}]}`,
+			wantErr:    `holiday calendar us: holiday 1 (12/25/2025): date must be in the format YYYY-MM-DD, got "12/25/2025"`,
+		},
+		{
+			name:       "missing name",
+			definition: `calendars: {us: [{date: 2025-12-25}]}`,
+			wantErr:    "holiday calendar us: holiday 1 (2025-12-25): name is required",
+		},
+		{
+			name:       "duplicate date",
+			definition: `calendars: {us: [{date: 2025-12-25, name: Christmas Day}, {date: 2025-12-25, name: Xmas}]}`,
+			wantErr:    "holiday calendar us: holiday 2 (2025-12-25): date is already defined",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseHolidayCalendars([]byte(tt.definition))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoadHolidayCalendars(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(holidayCalendars), 0o644))
+	holidays, err := LoadHolidayCalendars(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"de", "us"}, holidays.Names())
+
+	require.NoError(t, os.WriteFile(path, []byte(`calendars: {us: [{date: 2025-12-25}]}`), 0o644))
+	_, err = LoadHolidayCalendars(path)
+	assert.ErrorContains(t, err, path+": holiday calendar us")
+}
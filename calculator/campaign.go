@@ -0,0 +1,126 @@
+package calculator
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"fetch-assessment/money"
+	"fetch-assessment/store"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CampaignConfig is the declarative definition of a campaign, such as double points at a retailer in March. A campaign
+// awards either a fixed Bonus or the points of the rules times Multiplier.
+type CampaignConfig struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	// Retailer selects the receipts the campaign applies to.
+	Retailer RetailerMatcherConfig `yaml:"retailer"`
+	// From and To are the first and last day of the campaign (YYYY-MM-DD, UTC), compared with the purchase date or
+	// the submission time, as configured by scoreBy.
+	From       string  `yaml:"from"`
+	To         string  `yaml:"to"`
+	Bonus      int     `yaml:"bonus"`
+	Multiplier float64 `yaml:"multiplier"`
+}
+
+// RetailerMatcherConfig matches retailer names ignoring case, whitespace and punctuation. Exactly one of Equals and
+// Contains must be set.
+type RetailerMatcherConfig struct {
+	Equals   string `yaml:"equals"`
+	Contains string `yaml:"contains"`
+}
+
+// Campaign awards extra points to receipts of a retailer within a date window, after the rules are applied.
+type Campaign struct {
+	ID          string
+	Description string
+	// retailer reports whether the campaign applies to the normalized retailer name.
+	retailer func(string) bool
+	from     time.Time
+	// until is the end of the last day of the campaign, exclusive.
+	until      time.Time
+	bonus      int
+	multiplier money.Factor
+	config     CampaignConfig
+}
+
+func newCampaign(config CampaignConfig) (*Campaign, error) {
+	var errs []error
+	if config.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+
+	equals := store.NormalizeRetailer(config.Retailer.Equals)
+	contains := store.NormalizeRetailer(config.Retailer.Contains)
+	var retailer func(string) bool
+	switch {
+	case equals != "" && contains == "":
+		retailer = func(name string) bool { return name == equals }
+	case contains != "" && equals == "":
+		retailer = func(name string) bool { return strings.Contains(name, contains) }
+	default:
+		errs = append(errs, errors.New("retailer must have either equals or contains"))
+	}
+
+	from, err := time.Parse("2006-01-02", config.From)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("from must be a date in the format YYYY-MM-DD, got %q", config.From))
+	}
+	to, err := time.Parse("2006-01-02", config.To)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("to must be a date in the format YYYY-MM-DD, got %q", config.To))
+	} else if to.Before(from) {
+		errs = append(errs, errors.New("to must not be before from"))
+	}
+
+	var multiplier money.Factor
+	switch {
+	case config.Bonus != 0 && config.Multiplier != 0:
+		errs = append(errs, errors.New("either bonus or multiplier must be set, not both"))
+	case config.Bonus < 0:
+		errs = append(errs, fmt.Errorf("bonus must be positive, got %d", config.Bonus))
+	case config.Multiplier != 0:
+		multiplier, err = money.FactorOf(config.Multiplier)
+		if err != nil || multiplier.Cmp(1) <= 0 {
+			errs = append(errs, fmt.Errorf("multiplier must be greater than 1, got %v", config.Multiplier))
+		}
+	case config.Bonus == 0:
+		errs = append(errs, errors.New("either bonus or multiplier is required"))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &Campaign{
+		ID:          config.ID,
+		Description: config.Description,
+		retailer:    retailer,
+		from:        from,
+		until:       to.AddDate(0, 0, 1),
+		bonus:       config.Bonus,
+		multiplier:  multiplier,
+		config:      config,
+	}, nil
+}
+
+// From returns the start of the first day of the campaign.
+func (c *Campaign) From() time.Time {
+	return c.from
+}
+
+// apply returns the points the campaign awards to a receipt at the time it is scored by, given the points awarded by
+// the rules. It reports false if the campaign does not apply.
+func (c *Campaign) apply(receipt model.Receipt, at time.Time, points int) (RuleResult, bool) {
+	if at.Before(c.from) || !at.Before(c.until) || !c.retailer(store.NormalizeRetailer(receipt.Retailer)) {
+		return RuleResult{}, false
+	}
+	if c.bonus != 0 {
+		return RuleResult{Rule: c.ID, Points: c.bonus, Reason: fmt.Sprintf("campaign bonus of %d points", c.bonus),
+			Campaign: true}, true
+	}
+	extra := int(c.multiplier.MulFloor(int64(points))) - points
+	return RuleResult{Rule: c.ID, Points: extra, Campaign: true,
+		Reason: fmt.Sprintf("campaign multiplies the %d points of the rules by %s", points, c.multiplier)}, true
+}
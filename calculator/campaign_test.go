@@ -0,0 +1,256 @@
+package calculator
+
+import (
+	"fetch-assessment/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const campaignRuleSets = `
+version: "1"
+rules: [{id: name, type: retailer-name-length, points: 1}]
+campaigns:
+  - id: walgreens-march
+    description: Double points at Walgreens in March.
+    retailer: {equals: Walgreens}
+    from: 2025-03-01
+    to: 2025-03-31
+    multiplier: 2
+  - id: pharmacy-bonus
+    retailer: {contains: pharmacy}
+    from: 2025-03-15
+    to: 2025-04-15
+    bonus: 100
+  - id: green-day
+    retailer: {contains: green}
+    from: 2025-03-20
+    to: 2025-03-20
+    multiplier: 1.5
+`
+
+func TestCampaigns(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(campaignRuleSets))
+	require.NoError(t, err)
+	require.Len(t, ruleSets.Campaigns(), 3)
+	assert.Equal(t, "Double points at Walgreens in March.", ruleSets.Campaigns()[0].Description)
+
+	doubled := RuleResult{Rule: "walgreens-march", Points: 9, Campaign: true,
+		Reason: "campaign multiplies the 9 points of the rules by 2"}
+	tests := []struct {
+		name     string
+		retailer string
+		date     string
+		want     []RuleResult
+	}{
+		{
+			name:     "first day",
+			retailer: "Walgreens",
+			date:     "2025-03-01",
+			want:     []RuleResult{{Rule: "name", Points: 9, Reason: "retailer name has 9 alphanumeric characters"}, doubled},
+		},
+		{
+			name:     "last day, retailer compared ignoring case and punctuation",
+			retailer: "WAL-GREENS",
+			date:     "2025-03-31",
+			want:     []RuleResult{{Rule: "name", Points: 9, Reason: "retailer name has 9 alphanumeric characters"}, doubled},
+		},
+		{
+			name:     "before the first day",
+			retailer: "Walgreens",
+			date:     "2025-02-28",
+			want:     []RuleResult{{Rule: "name", Points: 9, Reason: "retailer name has 9 alphanumeric characters"}},
+		},
+		{
+			name:     "after the last day",
+			retailer: "Walgreens",
+			date:     "2025-04-01",
+			want:     []RuleResult{{Rule: "name", Points: 9, Reason: "retailer name has 9 alphanumeric characters"}},
+		},
+		{
+			name:     "other retailer",
+			retailer: "Walgreens Express",
+			date:     "2025-03-10",
+			want:     []RuleResult{{Rule: "name", Points: 16, Reason: "retailer name has 16 alphanumeric characters"}},
+		},
+		{
+			name:     "bonus",
+			retailer: "Walgreens Pharmacy",
+			date:     "2025-04-15",
+			want: []RuleResult{
+				{Rule: "name", Points: 17, Reason: "retailer name has 17 alphanumeric characters"},
+				{Rule: "pharmacy-bonus", Points: 100, Reason: "campaign bonus of 100 points", Campaign: true},
+			},
+		},
+		{
+			name:     "campaigns do not compound",
+			retailer: "Walgreens",
+			date:     "2025-03-20",
+			want: []RuleResult{
+				{Rule: "name", Points: 9, Reason: "retailer name has 9 alphanumeric characters"},
+				doubled,
+				{Rule: "green-day", Points: 4, Reason: "campaign multiplies the 9 points of the rules by 1.5",
+					Campaign: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := model.Receipt{Retailer: tt.retailer, PurchaseDate: mustParseDate(tt.date)}
+			score := ruleSets.Score(receipt, time.Now())
+			assert.Equal(t, tt.want, score.Rules)
+			points := 0
+			for _, result := range tt.want {
+				points += result.Points
+			}
+			assert.Equal(t, points, score.Points)
+		})
+	}
+}
+
+func TestCampaignsScoredBySubmissionTime(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte("scoreBy: submissionTime\n" + campaignRuleSets))
+	require.NoError(t, err)
+
+	receipt := model.Receipt{Retailer: "Walgreens", PurchaseDate: mustParseDate("2025-03-10")}
+	assert.Equal(t, 18, ruleSets.Score(receipt, time.Date(2025, 3, 31, 23, 59, 59, 0, time.UTC)).Points)
+	assert.Equal(t, 9, ruleSets.Score(receipt, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)).Points)
+}
+
+func TestParseRuleSetsRejectsInvalidCampaigns(t *testing.T) {
+	tests := []struct {
+		name     string
+		campaign string
+		wantErr  string
+	}{
+		{
+			name:     "missing id",
+			campaign: `{retailer: {equals: a}, from: 2025-03-01, to: 2025-03-31, bonus: 5}`,
+			wantErr:  "campaign 1 (): id is required",
+		},
+		{
+			name:     "missing retailer",
+			campaign: `{id: c, from: 2025-03-01, to: 2025-03-31, bonus: 5}`,
+			wantErr:  "campaign 1 (c): retailer must have either equals or contains",
+		},
+		{
+			name:     "both retailer matchers",
+			campaign: `{id: c, retailer: {equals: a, contains: b}, from: 2025-03-01, to: 2025-03-31, bonus: 5}`,
+			wantErr:  "retailer must have either equals or contains",
+		},
+		{
+			name:     "invalid from",
+			campaign: `{id: c, retailer: {equals: a}, from: March, to: 2025-03-31, bonus: 5}`,
+			wantErr:  `from must be a date in the format YYYY-MM-DD, got "March"`,
+		},
+		{
+			name:     "missing to",
+			campaign: `{id: c, retailer: {equals: a}, from: 2025-03-01, bonus: 5}`,
+			wantErr:  `to must be a date in the format YYYY-MM-DD, got ""`,
+		},
+		{
+			name:     "to before from",
+			campaign: `{id: c, retailer: {equals: a}, from: 2025-03-01, to: 2025-02-28, bonus: 5}`,
+			wantErr:  "to must not be before from",
+		},
+		{
+			name:     "bonus and multiplier",
+			campaign: `{id: c, retailer: {equals: a}, from: 2025-03-01, to: 2025-03-31, bonus: 5, multiplier: 2}`,
+			wantErr:  "either bonus or multiplier must be set, not both",
+		},
+		{
+			name:     "neither bonus nor multiplier",
+			campaign: `{id: c, retailer: {equals: a}, from: 2025-03-01, to: 2025-03-31}`,
+			wantErr:  "either bonus or multiplier is required",
+		},
+		{
+			name:     "negative bonus",
+			campaign: `{id: c, retailer: {equals: a}, from: 2025-03-01, to: 2025-03-31, bonus: -5}`,
+			wantErr:  "bonus must be positive, got -5",
+		},
+		{
+			name:     "multiplier reducing points",
+			campaign: `{id: c, retailer: {equals: a}, from: 2025-03-01, to: 2025-03-31, multiplier: 0.5}`,
+			wantErr:  "multiplier must be greater than 1, got 0.5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleSets([]byte(`{version: "1", rules: [{id: a, type: retailer-name-length}],
+				campaigns: [` + tt.campaign + `]}`))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	_, err := ParseRuleSets([]byte(`{version: "1", rules: [{id: a, type: retailer-name-length}], campaigns: [
+		{id: c, retailer: {equals: a}, from: 2025-03-01, to: 2025-03-31, bonus: 5},
+		{id: c, retailer: {equals: b}, from: 2025-04-01, to: 2025-04-30, bonus: 5}]}`))
+	assert.ErrorContains(t, err, "campaign 2 (c): id is already defined")
+}
+
+func TestReloadCampaigns(t *testing.T) {
+	builtIn := ActiveRuleSets()
+	t.Cleanup(func() { Activate(builtIn) })
+	dir := t.TempDir()
+	write := func(name, campaigns string) string {
+		path := filepath.Join(dir, name)
+		definition := `{version: "1", rules: [{id: a, type: retailer-name-length, points: 1}], campaigns: [` +
+			campaigns + `]}`
+		require.NoError(t, os.WriteFile(path, []byte(definition), 0o644))
+		return path
+	}
+	day := func(days int) string {
+		return time.Now().UTC().AddDate(0, 0, days).Format("2006-01-02")
+	}
+	started := `{id: started, retailer: {equals: Target}, from: ` + day(-1) + `, to: ` + day(1) + `, bonus: 5}`
+	scheduled := `{id: scheduled, retailer: {equals: Target}, from: ` + day(2) + `, to: ` + day(3) + `, bonus: 5}`
+	Activate(mustLoadRuleSets(t, write("initial.yaml", started+", "+scheduled)))
+	ruleSets := ActiveRuleSets()
+	// the started campaign applies to receipts bought today
+	assert.Equal(t, 11, CalculateTotals(model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate(day(0))}))
+
+	tests := []struct {
+		name      string
+		campaigns string
+		wantErr   string
+	}{
+		{
+			name:      "started campaign changed",
+			campaigns: `{id: started, retailer: {equals: Target}, from: ` + day(-1) + `, to: ` + day(1) + `, bonus: 50}`,
+			wantErr:   "campaign started has already started and must be kept unchanged",
+		},
+		{
+			name:      "started campaign dropped",
+			campaigns: scheduled,
+			wantErr:   "campaign started has already started and must be kept unchanged",
+		},
+		{
+			name:      "campaign starting in the past",
+			campaigns: started + `, {id: new, retailer: {equals: Target}, from: ` + day(-2) + `, to: ` + day(1) + `, bonus: 5}`,
+			wantErr:   "campaign new must start in the future",
+		},
+		{
+			name:      "scheduled campaign moved into the past",
+			campaigns: started + `, {id: scheduled, retailer: {equals: Target}, from: ` + day(0) + `, to: ` + day(3) + `, bonus: 5}`,
+			wantErr:   "campaign scheduled must start in the future",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReloadRuleSets(write("rejected.yaml", tt.campaigns))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Same(t, ruleSets, ActiveRuleSets())
+		})
+	}
+
+	// scheduled campaigns may still be changed, added or withdrawn
+	later := `{id: later, retailer: {contains: get}, from: ` + day(5) + `, to: ` + day(5) + `, multiplier: 3}`
+	_, err := ReloadRuleSets(write("changed.yaml", started+", "+later))
+	require.NoError(t, err)
+	assert.Len(t, ActiveRuleSets().Campaigns(), 2)
+}
@@ -0,0 +1,431 @@
+package calculator
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fetch-assessment/money"
+	"fetch-assessment/utils"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultRuleSet defines the rules used unless a rule set is loaded from a file.
+//
+//go:embed default_rules.yaml
+var defaultRuleSet []byte
+
+const (
+	// ScoreByPurchaseDate scores every receipt by the rule set in force at the start of its purchase date.
+	ScoreByPurchaseDate = "purchaseDate"
+	// ScoreBySubmissionTime scores every receipt by the rule set in force when it was first submitted.
+	ScoreBySubmissionTime = "submissionTime"
+)
+
+// RuleSetsConfig is the declarative definition of all versions of the rule set. It is read from YAML, or from JSON,
+// which is a subset of YAML. A single rule set in force at all times may be defined at the top level instead of in
+// RuleSets.
+type RuleSetsConfig struct {
+	// ScoreBy selects the time that determines the rule set a receipt is scored by: ScoreByPurchaseDate, the
+	// default, or ScoreBySubmissionTime.
+	ScoreBy  string          `yaml:"scoreBy"`
+	RuleSets []RuleSetConfig `yaml:"ruleSets"`
+	// Campaigns apply after the rules of whichever rule set is in force.
+	Campaigns []CampaignConfig `yaml:"campaigns"`
+	// Experiments score a share of the receipts by variants of a rule set.
+	Experiments   []ExperimentConfig `yaml:"experiments"`
+	RuleSetConfig `yaml:",inline"`
+}
+
+// RuleSetConfig is the declarative definition of a version of the rule set.
+type RuleSetConfig struct {
+	// Version identifies the rule set. It must be changed whenever a rule or its points change.
+	Version string `yaml:"version"`
+	// EffectiveFrom is the date (YYYY-MM-DD, starting at midnight UTC) or time (RFC 3339) from which the rule set is
+	// in force. Only the earliest rule set may omit it; it is then in force before all others.
+	EffectiveFrom string `yaml:"effectiveFrom"`
+	// MaxPoints caps the points of a receipt, including those awarded by campaigns. The points are not capped if it
+	// is zero. MinPoints is the minimum points of every receipt.
+	MaxPoints int          `yaml:"maxPoints"`
+	MinPoints int          `yaml:"minPoints"`
+	Rules     []RuleConfig `yaml:"rules"`
+	// FraudRules apply last, after the rules, campaigns and limits. Their IDs must differ from those of the rules.
+	FraudRules []FraudRuleConfig `yaml:"fraudRules"`
+}
+
+// RuleConfig defines a rule of one of the rule types. The meaning of Points and Params depends on the type.
+type RuleConfig struct {
+	ID          string `yaml:"id"`
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	// Version defaults to 1.
+	Version int `yaml:"version"`
+	// Enabled defaults to true.
+	Enabled *bool `yaml:"enabled"`
+	Points  int   `yaml:"points"`
+	// MaxPoints caps the points the rule awards to a receipt. The points are not capped if it is zero.
+	MaxPoints int            `yaml:"maxPoints"`
+	Params    map[string]any `yaml:"params"`
+}
+
+// LoadRuleSets reads the rule set definitions from the YAML or JSON file at path. See ParseRuleSets.
+func LoadRuleSets(path string) (*RuleSets, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ruleSets, err := ParseRuleSets(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ruleSets, nil
+}
+
+// ParseRuleSets reads YAML or JSON rule set definitions. Unknown fields are rejected. See NewRuleSets.
+func ParseRuleSets(data []byte) (*RuleSets, error) {
+	var config RuleSetsConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("reading rule set: %w", err)
+	}
+	return NewRuleSets(config)
+}
+
+// NewRuleSets validates the definitions and returns the rule sets ordered by effective date. All invalid rule sets and
+// rules are reported at once.
+func NewRuleSets(config RuleSetsConfig) (*RuleSets, error) {
+	definitions := config.RuleSets
+	if config.Version != "" || config.EffectiveFrom != "" || len(config.Rules) > 0 {
+		if len(definitions) > 0 {
+			return nil, errors.New("rule sets must be defined either in ruleSets or at the top level")
+		}
+		definitions = []RuleSetConfig{config.RuleSetConfig}
+	}
+	if len(definitions) == 0 {
+		return nil, errors.New("rule set has no rules")
+	}
+
+	var errs []error
+	ruleSets := &RuleSets{scoreBy: config.ScoreBy}
+	switch config.ScoreBy {
+	case "":
+		ruleSets.scoreBy = ScoreByPurchaseDate
+	case ScoreByPurchaseDate, ScoreBySubmissionTime:
+	default:
+		errs = append(errs, fmt.Errorf("scoreBy must be %s or %s, got %q", ScoreByPurchaseDate,
+			ScoreBySubmissionTime, config.ScoreBy))
+	}
+
+	versions := make(map[string]bool)
+	for i, definition := range definitions {
+		registry, err := NewRuleSet(definition)
+		if err == nil && versions[registry.Version()] {
+			err = errors.New("version is already defined")
+		}
+		if err != nil {
+			if len(definitions) > 1 {
+				err = fmt.Errorf("rule set %d (%s): %w", i+1, definition.Version, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		versions[registry.Version()] = true
+		ruleSets.registries = append(ruleSets.registries, registry)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(ruleSets.registries, func(i, j int) bool {
+		return ruleSets.registries[i].effectiveFrom.Before(ruleSets.registries[j].effectiveFrom)
+	})
+	for i := 1; i < len(ruleSets.registries); i++ {
+		previous, registry := ruleSets.registries[i-1], ruleSets.registries[i]
+		if registry.effectiveFrom.IsZero() {
+			errs = append(errs, fmt.Errorf("rule set %s: only the earliest rule set may omit effectiveFrom", registry.Version()))
+		} else if registry.effectiveFrom.Equal(previous.effectiveFrom) {
+			errs = append(errs, fmt.Errorf("rule sets %s and %s have the same effectiveFrom", previous.Version(), registry.Version()))
+		}
+	}
+
+	campaigns := make(map[string]bool)
+	for i, campaignConfig := range config.Campaigns {
+		campaign, err := newCampaign(campaignConfig)
+		if err == nil && campaigns[campaign.ID] {
+			err = errors.New("id is already defined")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("campaign %d (%s): %w", i+1, campaignConfig.ID, err))
+			continue
+		}
+		campaigns[campaign.ID] = true
+		ruleSets.campaigns = append(ruleSets.campaigns, campaign)
+	}
+
+	experiments := make(map[string]bool)
+	for i, experimentConfig := range config.Experiments {
+		experiment, err := newExperiment(experimentConfig, ruleSets)
+		if err == nil && experiments[experiment.ID] {
+			err = errors.New("id is already defined")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("experiment %d (%s): %w", i+1, experimentConfig.ID, err))
+			continue
+		}
+		experiments[experiment.ID] = true
+		ruleSets.experiments = append(ruleSets.experiments, experiment)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return ruleSets, nil
+}
+
+// NewRuleSet validates the definition of a single rule set and returns a registry holding its rules, in the order they
+// are defined. All invalid rules are reported at once.
+func NewRuleSet(config RuleSetConfig) (*Registry, error) {
+	var errs []error
+	if config.Version == "" {
+		errs = append(errs, errors.New("rule set version is required"))
+	}
+	if len(config.Rules) == 0 {
+		errs = append(errs, errors.New("rule set has no rules"))
+	}
+	effectiveFrom, err := parseEffectiveFrom(config.EffectiveFrom)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	switch {
+	case config.MaxPoints < 0:
+		errs = append(errs, fmt.Errorf("maxPoints must not be negative, got %d", config.MaxPoints))
+	case config.MinPoints < 0:
+		errs = append(errs, fmt.Errorf("minPoints must not be negative, got %d", config.MinPoints))
+	case config.MaxPoints > 0 && config.MinPoints > config.MaxPoints:
+		errs = append(errs, fmt.Errorf("minPoints %d must not exceed maxPoints %d", config.MinPoints, config.MaxPoints))
+	}
+
+	registry := NewRegistry()
+	registry.version = config.Version
+	registry.effectiveFrom = effectiveFrom
+	registry.config = config
+	registry.maxPoints = config.MaxPoints
+	registry.minPoints = config.MinPoints
+	for i, ruleConfig := range config.Rules {
+		rule, err := newRule(ruleConfig)
+		if err == nil {
+			err = registry.Register(rule)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d (%s): %w", i+1, ruleConfig.ID, err))
+			continue
+		}
+		if ruleConfig.Enabled != nil && !*ruleConfig.Enabled {
+			registry.Disable(rule.ID)
+		}
+	}
+	ids := make(map[string]bool)
+	for _, rule := range registry.rules {
+		ids[rule.rule.ID] = true
+	}
+	for i, fraudRuleConfig := range config.FraudRules {
+		fraudRule, err := newFraudRule(fraudRuleConfig)
+		if err == nil && ids[fraudRule.ID] {
+			err = errors.New("id is already defined")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("fraud rule %d (%s): %w", i+1, fraudRuleConfig.ID, err))
+			continue
+		}
+		ids[fraudRule.ID] = true
+		registry.fraudRules = append(registry.fraudRules, fraudRule)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func parseEffectiveFrom(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("effectiveFrom must be a date (YYYY-MM-DD) or time (RFC 3339), got %q", value)
+	}
+	return t.UTC(), nil
+}
+
+func newRule(config RuleConfig) (Rule, error) {
+	if config.ID == "" {
+		return Rule{}, errors.New("id is required")
+	}
+	ruleType, ok := ruleTypes[config.Type]
+	if !ok {
+		return Rule{}, fmt.Errorf("unknown rule type %q", config.Type)
+	}
+	if config.Version < 0 {
+		return Rule{}, fmt.Errorf("version must not be negative, got %d", config.Version)
+	}
+	if config.Version == 0 {
+		config.Version = 1
+	}
+	if config.MaxPoints < 0 {
+		return Rule{}, fmt.Errorf("maxPoints must not be negative, got %d", config.MaxPoints)
+	}
+
+	p := &params{values: config.Params, read: make(map[string]bool)}
+	evaluate, err := ruleType(config, p)
+	if err != nil {
+		return Rule{}, err
+	}
+	if err := p.unknown(); err != nil {
+		return Rule{}, err
+	}
+	return Rule{ID: config.ID, Description: config.Description, Version: config.Version, MaxPoints: config.MaxPoints,
+		Evaluate: evaluate}, nil
+}
+
+func mustParseRuleSets(data []byte) *RuleSets {
+	ruleSets, err := ParseRuleSets(data)
+	if err != nil {
+		panic(err)
+	}
+	return ruleSets
+}
+
+// params gives typed access to the parameters of a rule and records which were read, so that unknown parameters can
+// be rejected.
+type params struct {
+	values map[string]any
+	read   map[string]bool
+}
+
+// has reports whether an optional parameter is set.
+func (p *params) has(name string) bool {
+	p.read[name] = true
+	_, ok := p.values[name]
+	return ok
+}
+
+func (p *params) get(name string) (any, error) {
+	p.read[name] = true
+	value, ok := p.values[name]
+	if !ok {
+		return nil, fmt.Errorf("parameter %s is required", name)
+	}
+	return value, nil
+}
+
+// number returns a positive number.
+func (p *params) number(name string) (float64, error) {
+	value, err := p.get(name)
+	if err != nil {
+		return 0, err
+	}
+	var number float64
+	switch v := value.(type) {
+	case int:
+		number = float64(v)
+	case float64:
+		number = v
+	default:
+		return 0, fmt.Errorf("parameter %s must be a number, got %v", name, value)
+	}
+	if number <= 0 {
+		return 0, fmt.Errorf("parameter %s must be positive, got %v", name, value)
+	}
+	return number, nil
+}
+
+// amount returns a positive amount of money, given in dollars.
+func (p *params) amount(name string) (money.Amount, error) {
+	number, err := p.number(name)
+	if err != nil {
+		return 0, err
+	}
+	amount, err := money.FromDecimal(number)
+	if err != nil || amount == 0 {
+		return 0, fmt.Errorf("parameter %s must be a whole number of cents, got %v", name, number)
+	}
+	return amount, nil
+}
+
+// factor returns a positive number as an exact decimal.
+func (p *params) factor(name string) (money.Factor, error) {
+	number, err := p.number(name)
+	if err != nil {
+		return money.Factor{}, err
+	}
+	return money.FactorOf(number)
+}
+
+// integer returns a positive integer.
+func (p *params) integer(name string) (int, error) {
+	value, err := p.get(name)
+	if err != nil {
+		return 0, err
+	}
+	integer, ok := value.(int)
+	if !ok || integer <= 0 {
+		return 0, fmt.Errorf("parameter %s must be a positive integer, got %v", name, value)
+	}
+	return integer, nil
+}
+
+func (p *params) text(name string) (string, error) {
+	value, err := p.get(name)
+	if err != nil {
+		return "", err
+	}
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter %s must be a string, got %v", name, value)
+	}
+	return text, nil
+}
+
+// timeZone returns the location of an optional time zone parameter, or nil if it is not set.
+func (p *params) timeZone(name string) (*time.Location, error) {
+	if !p.has(name) {
+		return nil, nil
+	}
+	zone, err := p.text(name)
+	if err != nil {
+		return nil, err
+	}
+	location, err := utils.ParseTimeZone(zone)
+	if err != nil {
+		return nil, fmt.Errorf("parameter %s: %w", name, err)
+	}
+	return location, nil
+}
+
+// list returns the elements of an optional list parameter, or none if it is not set.
+func (p *params) list(name string) ([]any, error) {
+	if !p.has(name) {
+		return nil, nil
+	}
+	value, _ := p.get(name)
+	list, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("parameter %s must be a list, got %v", name, value)
+	}
+	return list, nil
+}
+
+func (p *params) unknown() error {
+	for name := range p.values {
+		if !p.read[name] {
+			return fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	return nil
+}
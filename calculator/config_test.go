@@ -0,0 +1,620 @@
+package calculator
+
+import (
+	"fetch-assessment/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRuleSet(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		registry, err := parseRuleSet([]byte(`
+version: "2"
+rules:
+  - id: retailer
+    type: retailer-name-length
+    points: 2
+  - id: weekend-items
+    type: item-groups
+    version: 3
+    points: 1
+    params:
+      size: 1
+  - id: disabled
+    type: purchase-day-parity
+    enabled: false
+    points: 100
+    params:
+      parity: odd
+`))
+		require.NoError(t, err)
+		assert.Equal(t, "2", registry.Version())
+		rules := registry.Rules()
+		require.Len(t, rules, 2)
+		assert.Equal(t, 1, rules[0].Version)
+		assert.Equal(t, 3, rules[1].Version)
+
+		points, _ := registry.Explain(model.Receipt{
+			Retailer:     "Target",
+			Items:        make([]model.Item, 3),
+			PurchaseDate: mustParseDate("2022-01-01"),
+		})
+		assert.Equal(t, 15, points)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		registry, err := parseRuleSet([]byte(`{"version": "2", "rules": [
+			{"id": "afternoon", "type": "purchase-time-window", "points": 10, "params": {"from": "14:00", "to": "16:00"}}
+		]}`))
+		require.NoError(t, err)
+
+		points, _ := registry.Explain(model.Receipt{PurchaseTime: "15:59"})
+		assert.Equal(t, 10, points)
+		points, _ = registry.Explain(model.Receipt{PurchaseTime: "16:00"})
+		assert.Equal(t, 0, points)
+	})
+
+	t.Run("default rule set", func(t *testing.T) {
+		registry, err := parseRuleSet(defaultRuleSet)
+		require.NoError(t, err)
+		assert.Len(t, registry.Rules(), 7)
+	})
+}
+
+func TestParseRuleSetRejectsInvalidDefinitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		wantErr    string
+	}{
+		{
+			name:       "missing version",
+			definition: `rules: [{id: a, type: retailer-name-length, points: 1}]`,
+			wantErr:    "rule set version is required",
+		},
+		{
+			name:       "no rules",
+			definition: `version: "1"`,
+			wantErr:    "rule set has no rules",
+		},
+		{
+			name:       "unknown field",
+			definition: `{version: "1", rules: [{id: a, type: retailer-name-length, point: 1}]}`,
+			wantErr:    "field point not found",
+		},
+		{
+			name:       "missing id",
+			definition: `{version: "1", rules: [{type: retailer-name-length, points: 1}]}`,
+			wantErr:    "id is required",
+		},
+		{
+			name:       "duplicate id",
+			definition: `{version: "1", rules: [{id: a, type: retailer-name-length}, {id: a, type: retailer-name-length}]}`,
+			wantErr:    `rule "a" is already registered`,
+		},
+		{
+			name:       "unknown type",
+			definition: `{version: "1", rules: [{id: a, type: lucky-number, points: 7}]}`,
+			wantErr:    `unknown rule type "lucky-number"`,
+		},
+		{
+			name:       "negative version",
+			definition: `{version: "1", rules: [{id: a, type: retailer-name-length, version: -1}]}`,
+			wantErr:    "version must not be negative",
+		},
+		{
+			name:       "missing parameter",
+			definition: `{version: "1", rules: [{id: a, type: total-multiple, points: 1}]}`,
+			wantErr:    "parameter multiple is required",
+		},
+		{
+			name:       "unknown parameter",
+			definition: `{version: "1", rules: [{id: a, type: item-groups, points: 1, params: {size: 2, count: 3}}]}`,
+			wantErr:    "unknown parameter count",
+		},
+		{
+			name:       "parameter of wrong type",
+			definition: `{version: "1", rules: [{id: a, type: item-groups, points: 1, params: {size: two}}]}`,
+			wantErr:    "parameter size must be a positive integer",
+		},
+		{
+			name:       "parameter not positive",
+			definition: `{version: "1", rules: [{id: a, type: total-multiple, points: 1, params: {multiple: 0}}]}`,
+			wantErr:    "parameter multiple must be positive",
+		},
+		{
+			name:       "fraction of a cent",
+			definition: `{version: "1", rules: [{id: a, type: total-multiple, points: 1, params: {multiple: 0.125}}]}`,
+			wantErr:    "parameter multiple must be a whole number of cents, got 0.125",
+		},
+		{
+			name:       "invalid parity",
+			definition: `{version: "1", rules: [{id: a, type: purchase-day-parity, points: 1, params: {parity: prime}}]}`,
+			wantErr:    "parameter parity must be odd or even",
+		},
+		{
+			name:       "invalid time",
+			definition: `{version: "1", rules: [{id: a, type: purchase-time-window, points: 1, params: {from: "2pm", to: "16:00"}}]}`,
+			wantErr:    "parameter from must be a time in the format HH:MM",
+		},
+		{
+			name:       "empty time window",
+			definition: `{version: "1", rules: [{id: a, type: purchase-time-window, points: 1, params: {from: "16:00", to: "14:00"}}]}`,
+			wantErr:    "parameter from must be before to",
+		},
+		{
+			name:       "invalid expression",
+			definition: `{version: "1", rules: [{id: a, type: expression, points: 15, params: {when: "total > '50'"}}]}`,
+			wantErr:    "rule 1 (a): parameter when: 1:7: cannot compare number with string",
+		},
+		{
+			name: "points of derived rule",
+			definition: `{version: "1", rules: [{id: a, type: item-description-length, points: 1,
+				params: {multiple: 3, priceMultiplier: 0.2}}]}`,
+			wantErr: "points are derived from the item prices",
+		},
+		{
+			name:       "negative rule maximum",
+			definition: `{version: "1", rules: [{id: a, type: retailer-name-length, points: 1, maxPoints: -1}]}`,
+			wantErr:    "rule 1 (a): maxPoints must not be negative, got -1",
+		},
+		{
+			name:       "negative receipt minimum",
+			definition: `{version: "1", minPoints: -1, rules: [{id: a, type: retailer-name-length, points: 1}]}`,
+			wantErr:    "minPoints must not be negative, got -1",
+		},
+		{
+			name:       "receipt minimum above maximum",
+			definition: `{version: "1", minPoints: 20, maxPoints: 10, rules: [{id: a, type: retailer-name-length, points: 1}]}`,
+			wantErr:    "minPoints 20 must not exceed maxPoints 10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleSets([]byte(tt.definition))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestExpressionRule(t *testing.T) {
+	registry, err := parseRuleSet([]byte(`
+version: "1"
+rules:
+  - id: target-big-spender
+    type: expression
+    points: 15
+    params:
+      when: retailer contains "Target" and total > 50
+`))
+	require.NoError(t, err)
+
+	points, results := registry.Explain(model.Receipt{Retailer: "Target", Total: "50.01"})
+	assert.Equal(t, 15, points)
+	assert.Equal(t, `retailer contains "Target" and total > 50 holds`, results[0].Reason)
+
+	points, results = registry.Explain(model.Receipt{Retailer: "Target", Total: "50.00"})
+	assert.Equal(t, 0, points)
+	assert.Equal(t, `retailer contains "Target" and total > 50 does not hold`, results[0].Reason)
+}
+
+func TestItemCategoryRule(t *testing.T) {
+	registry, err := parseRuleSet([]byte(`
+version: "1"
+rules:
+  - id: dairy
+    type: item-category
+    points: 10
+    params: {category: dairy}
+  - id: two-beverages
+    type: expression
+    points: 25
+    params:
+      when: count(items, category = "beverages") >= 2
+`))
+	require.NoError(t, err)
+
+	dairy, beverages := "dairy", "beverages"
+	points, results := registry.Explain(model.Receipt{Items: []model.Item{
+		{ShortDescription: "Milk", Category: &dairy},
+		{ShortDescription: "Cheese", Category: &dairy},
+		{ShortDescription: "Soda", Category: &beverages},
+		{ShortDescription: "Gift Card"},
+	}})
+	assert.Equal(t, 20, points)
+	assert.Equal(t, "2 items are in category dairy", results[0].Reason)
+
+	points, _ = registry.Explain(model.Receipt{Items: []model.Item{
+		{ShortDescription: "Soda", Category: &beverages},
+		{ShortDescription: "Water", Category: &beverages},
+	}})
+	assert.Equal(t, 25, points)
+
+	_, err = parseRuleSet([]byte(`{version: "1", rules: [{id: a, type: item-category, points: 1, params: {category: ""}}]}`))
+	assert.ErrorContains(t, err, "parameter category must not be empty")
+}
+
+func TestTimeZoneRules(t *testing.T) {
+	registry, err := parseRuleSet([]byte(`
+version: "1"
+rules:
+  - id: afternoon
+    type: purchase-time-window
+    points: 10
+    params: {from: "14:00", to: "16:00", timeZone: America/New_York}
+  - id: odd-day
+    type: purchase-day-parity
+    points: 6
+    params: {parity: odd, timeZone: America/New_York}
+`))
+	require.NoError(t, err)
+	zone := func(zone string) *string { return &zone }
+
+	tests := []struct {
+		name     string
+		date     string
+		time     string
+		timeZone *string
+		want     []string
+	}{
+		{
+			name: "receipt without time zone is taken to be in the zone of the rules",
+			date: "2022-01-01",
+			time: "14:30",
+			want: []string{"purchase time 14:30 in America/New_York is between 14:00 and 16:00",
+				"purchase day 1 in America/New_York is odd"},
+		},
+		{
+			name:     "receipt in another zone",
+			date:     "2022-01-01",
+			time:     "20:30",
+			timeZone: zone("Europe/Berlin"),
+			want: []string{"purchase time 14:30 in America/New_York is between 14:00 and 16:00",
+				"purchase day 1 in America/New_York is odd"},
+		},
+		{
+			name:     "receipt in UTC on the next day",
+			date:     "2022-01-02",
+			time:     "03:00",
+			timeZone: zone("+00:00"),
+			want: []string{"purchase time 22:00 in America/New_York is not between 14:00 and 16:00",
+				"purchase day 1 in America/New_York is odd"},
+		},
+		{
+			name:     "daylight saving time",
+			date:     "2022-07-01",
+			time:     "19:30",
+			timeZone: zone("UTC"),
+			want: []string{"purchase time 15:30 in America/New_York is between 14:00 and 16:00",
+				"purchase day 1 in America/New_York is odd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := model.Receipt{PurchaseDate: mustParseDate(tt.date), PurchaseTime: tt.time, TimeZone: tt.timeZone}
+			_, results := registry.Explain(receipt)
+			reasons := make([]string, 0, len(results))
+			for _, result := range results {
+				reasons = append(reasons, result.Reason)
+			}
+			assert.Equal(t, tt.want, reasons)
+		})
+	}
+
+	_, err = parseRuleSet([]byte(`{version: "1", rules: [{id: a, type: purchase-time-window, points: 1,
+		params: {from: "14:00", to: "16:00", timeZone: Mars}}]}`))
+	assert.ErrorContains(t, err, `rule 1 (a): parameter timeZone: unknown time zone "Mars"`)
+}
+
+func TestPointsLimits(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(`
+version: "1"
+maxPoints: 100
+minPoints: 5
+rules:
+  - {id: name, type: retailer-name-length, points: 1}
+  - {id: description, type: item-description-length, maxPoints: 20, params: {multiple: 3, priceMultiplier: 0.2}}
+  - {id: round, type: total-multiple, points: 90, params: {multiple: 1.00}}
+`))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		receipt model.Receipt
+		want    []RuleResult
+	}{
+		{
+			name:    "rule capped",
+			receipt: model.Receipt{Retailer: "Target", Total: "1.01", Items: []model.Item{{ShortDescription: "TV!", Price: "999.99"}}},
+			want: []RuleResult{
+				{Rule: "name", Points: 6, Reason: "retailer name has 6 alphanumeric characters"},
+				{Rule: "description", Points: 20, Capped: true,
+					Reason: "1 items have a description length that is a multiple of 3, capped from 200 to 20 points"},
+				{Rule: "round", Points: 0, Reason: "total 1.01 is not a multiple of 1.00"},
+			},
+		},
+		{
+			name:    "receipt capped",
+			receipt: model.Receipt{Retailer: "Target", Total: "1.00", Items: []model.Item{{ShortDescription: "TV!", Price: "50.00"}}},
+			want: []RuleResult{
+				{Rule: "name", Points: 6, Reason: "retailer name has 6 alphanumeric characters"},
+				{Rule: "description", Points: 10, Reason: "1 items have a description length that is a multiple of 3"},
+				{Rule: "round", Points: 90, Reason: "total 1.00 is a multiple of 1.00"},
+				{Rule: MaxPointsResult, Points: -6, Capped: true, Reason: "receipt points capped from 106 to 100"},
+			},
+		},
+		{
+			name:    "receipt raised to the minimum",
+			receipt: model.Receipt{Retailer: "Ab", Total: "1.01"},
+			want: []RuleResult{
+				{Rule: "name", Points: 2, Reason: "retailer name has 2 alphanumeric characters"},
+				{Rule: "description", Points: 0, Reason: "0 items have a description length that is a multiple of 3"},
+				{Rule: "round", Points: 0, Reason: "total 1.01 is not a multiple of 1.00"},
+				{Rule: MinPointsResult, Points: 3, Reason: "receipt points raised from 2 to the minimum of 5"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := ruleSets.Score(tt.receipt, time.Now())
+			assert.Equal(t, tt.want, score.Rules)
+			points := 0
+			for _, result := range tt.want {
+				points += result.Points
+			}
+			assert.Equal(t, points, score.Points)
+		})
+	}
+}
+
+func TestParseRuleSetReportsAllInvalidRules(t *testing.T) {
+	_, err := ParseRuleSets([]byte(`{version: "1", rules: [{id: a, type: unknown}, {id: b, type: total-multiple}]}`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "rule 1 (a)")
+	assert.Contains(t, err.Error(), "rule 2 (b)")
+}
+
+func TestParseRuleSetsWithEffectiveDates(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(`
+ruleSets:
+  - version: "2"
+    effectiveFrom: 2023-01-01
+    rules: [{id: name, type: retailer-name-length, points: 2}]
+  - version: "1"
+    rules: [{id: name, type: retailer-name-length, points: 1}]
+  - version: "3"
+    effectiveFrom: 2024-06-01T12:00:00+02:00
+    rules: [{id: name, type: retailer-name-length, points: 3}]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, ScoreByPurchaseDate, ruleSets.ScoreBy())
+	versions := make([]string, 0)
+	for _, registry := range ruleSets.Versions() {
+		versions = append(versions, registry.Version())
+	}
+	assert.Equal(t, []string{"1", "2", "3"}, versions)
+	assert.Equal(t, "3", ruleSets.Latest().Version())
+
+	tests := []struct {
+		at   string
+		want string
+	}{
+		{at: "2000-01-01T00:00:00Z", want: "1"},
+		{at: "2022-12-31T23:59:59Z", want: "1"},
+		{at: "2023-01-01T00:00:00Z", want: "2"},
+		{at: "2024-06-01T09:59:59Z", want: "2"},
+		{at: "2024-06-01T10:00:00Z", want: "3"},
+	}
+	for _, tt := range tests {
+		at, err := time.Parse(time.RFC3339, tt.at)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, ruleSets.At(at).Version(), tt.at)
+	}
+
+	receipt := model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate("2023-01-01")}
+	submitted := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "2", ruleSets.For(receipt, submitted).Version())
+}
+
+func TestRuleSetsScore(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(`
+ruleSets:
+  - version: "1"
+    rules: [{id: name, type: retailer-name-length, points: 1}]
+  - version: "2"
+    effectiveFrom: 2023-01-01
+    rules: [{id: name, type: retailer-name-length, points: 2}, {id: pairs, type: item-groups, points: 5, params: {size: 2}}]
+`))
+	require.NoError(t, err)
+
+	receipt := model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate("2023-06-01"), Items: make([]model.Item, 2)}
+	score := ruleSets.Score(receipt, time.Now())
+	assert.Equal(t, "2", score.RuleSetVersion)
+	assert.Equal(t, 17, score.Points)
+	assert.Equal(t, []RuleResult{
+		{Rule: "name", Points: 12, Reason: "retailer name has 6 alphanumeric characters"},
+		{Rule: "pairs", Points: 5, Reason: "2 items make 1 groups of 2"},
+	}, score.Rules)
+}
+
+func TestParseRuleSetsScoredBySubmissionTime(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(`
+scoreBy: submissionTime
+ruleSets:
+  - version: "1"
+    rules: [{id: name, type: retailer-name-length, points: 1}]
+  - version: "2"
+    effectiveFrom: 2023-01-01
+    rules: [{id: name, type: retailer-name-length, points: 2}]
+`))
+	require.NoError(t, err)
+
+	receipt := model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate("2022-01-01")}
+	assert.Equal(t, "2", ruleSets.For(receipt, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)).Version())
+	assert.Equal(t, "1", ruleSets.For(receipt, time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC)).Version())
+}
+
+func TestParseRuleSetsRejectsInvalidSchedules(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		wantErr    string
+	}{
+		{
+			name:       "unknown scoreBy",
+			definition: `{scoreBy: receiptDate, version: "1", rules: [{id: a, type: retailer-name-length}]}`,
+			wantErr:    `scoreBy must be purchaseDate or submissionTime, got "receiptDate"`,
+		},
+		{
+			name: "both forms",
+			definition: `{version: "1", rules: [{id: a, type: retailer-name-length}],
+				ruleSets: [{version: "2", rules: [{id: a, type: retailer-name-length}]}]}`,
+			wantErr: "either in ruleSets or at the top level",
+		},
+		{
+			name: "duplicate version",
+			definition: `{ruleSets: [{version: "1", rules: [{id: a, type: retailer-name-length}]},
+				{version: "1", effectiveFrom: 2023-01-01, rules: [{id: a, type: retailer-name-length}]}]}`,
+			wantErr: "rule set 2 (1): version is already defined",
+		},
+		{
+			name: "two rule sets without effective date",
+			definition: `{ruleSets: [{version: "1", rules: [{id: a, type: retailer-name-length}]},
+				{version: "2", rules: [{id: a, type: retailer-name-length}]}]}`,
+			wantErr: "only the earliest rule set may omit effectiveFrom",
+		},
+		{
+			name: "same effective date",
+			definition: `{ruleSets: [{version: "1", effectiveFrom: 2023-01-01, rules: [{id: a, type: retailer-name-length}]},
+				{version: "2", effectiveFrom: 2023-01-01, rules: [{id: a, type: retailer-name-length}]}]}`,
+			wantErr: "rule sets 1 and 2 have the same effectiveFrom",
+		},
+		{
+			name:       "invalid effective date",
+			definition: `{version: "1", effectiveFrom: January, rules: [{id: a, type: retailer-name-length}]}`,
+			wantErr:    `effectiveFrom must be a date (YYYY-MM-DD) or time (RFC 3339), got "January"`,
+		},
+		{
+			name: "invalid rule in a later rule set",
+			definition: `{ruleSets: [{version: "1", rules: [{id: a, type: retailer-name-length}]},
+				{version: "2", effectiveFrom: 2023-01-01, rules: [{id: a, type: unknown}]}]}`,
+			wantErr: `rule set 2 (2): rule 1 (a): unknown rule type "unknown"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleSets([]byte(tt.definition))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestLoadRuleSets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, defaultRuleSet, 0o644))
+
+	ruleSets, err := LoadRuleSets(path)
+	require.NoError(t, err)
+	assert.Equal(t, "1", ruleSets.Latest().Version())
+
+	_, err = LoadRuleSets(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestReloadRuleSets(t *testing.T) {
+	builtIn := ActiveRuleSets()
+	t.Cleanup(func() { Activate(builtIn) })
+	dir := t.TempDir()
+	write := func(name, definition string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(definition), 0o644))
+		return path
+	}
+	initial := write("initial.yaml", `{version: "1", rules: [{id: a, type: retailer-name-length, points: 1}]}`)
+	Activate(mustLoadRuleSets(t, initial))
+
+	tomorrow := time.Now().AddDate(0, 0, 1).UTC().Format("2006-01-02")
+	scheduled := write("scheduled.yaml", `{ruleSets: [{version: "1", rules: [{id: a, type: retailer-name-length, points: 1}]},
+		{version: "2", effectiveFrom: `+tomorrow+`, rules: [{id: a, type: retailer-name-length, points: 2}]}]}`)
+	ruleSets, err := ReloadRuleSets(scheduled)
+	require.NoError(t, err)
+	assert.Same(t, ruleSets, ActiveRuleSets())
+	assert.Equal(t, "2", RuleSetVersion())
+	// the new rule set is not in force yet
+	assert.Equal(t, 6, CalculateTotals(model.Receipt{Retailer: "Target"}))
+
+	tests := []struct {
+		name       string
+		definition string
+		wantErr    string
+	}{
+		{
+			name:       "invalid",
+			definition: `{version: "3", rules: [{id: a, type: unknown}]}`,
+			wantErr:    "unknown rule type",
+		},
+		{
+			name:       "rule set in force changed",
+			definition: `{version: "1", rules: [{id: a, type: retailer-name-length, points: 5}]}`,
+			wantErr:    "rule set 1 is already in force and must be kept unchanged",
+		},
+		{
+			name:       "rule set in force dropped",
+			definition: `{version: "3", rules: [{id: a, type: retailer-name-length, points: 1}]}`,
+			wantErr:    "rule set 1 is already in force and must be kept unchanged",
+		},
+		{
+			name: "rule set taking effect in the past",
+			definition: `{ruleSets: [{version: "1", rules: [{id: a, type: retailer-name-length, points: 1}]},
+				{version: "3", effectiveFrom: 2020-01-01, rules: [{id: a, type: retailer-name-length, points: 3}]}]}`,
+			wantErr: "rule set 3 must take effect in the future",
+		},
+		{
+			name: "scheduled rule set moved into the past",
+			definition: `{ruleSets: [{version: "1", rules: [{id: a, type: retailer-name-length, points: 1}]},
+				{version: "2", effectiveFrom: 2020-01-01, rules: [{id: a, type: retailer-name-length, points: 2}]}]}`,
+			wantErr: "rule set 2 must take effect in the future",
+		},
+		{
+			name:       "scoreBy changed",
+			definition: `{scoreBy: submissionTime, version: "1", rules: [{id: a, type: retailer-name-length, points: 1}]}`,
+			wantErr:    "scoreBy must not change",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReloadRuleSets(write("rejected.yaml", tt.definition))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Same(t, ruleSets, ActiveRuleSets())
+		})
+	}
+
+	// the scheduled rule set may still be changed or withdrawn
+	_, err = ReloadRuleSets(initial)
+	require.NoError(t, err)
+	assert.Equal(t, "1", RuleSetVersion())
+}
+
+// parseRuleSet parses the definition of a single rule set.
+func parseRuleSet(data []byte) (*Registry, error) {
+	ruleSets, err := ParseRuleSets(data)
+	if err != nil {
+		return nil, err
+	}
+	return ruleSets.Latest(), nil
+}
+
+func mustLoadRuleSets(t *testing.T, path string) *RuleSets {
+	ruleSets, err := LoadRuleSets(path)
+	require.NoError(t, err)
+	return ruleSets
+}
@@ -0,0 +1,270 @@
+package calculator
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"fmt"
+	"hash/fnv"
+	"time"
+)
+
+const (
+	// BucketByReceipt assigns variants by the content of the receipt.
+	BucketByReceipt = "receipt"
+	// BucketByUser assigns variants by the user submitting the receipt, so that all receipts of a user get the same
+	// variant.
+	BucketByUser = "user"
+)
+
+// ExperimentConfig is the declarative definition of an experiment, such as testing alternative points of a rule on a
+// fraction of the receipts. Every receipt taking part is assigned one of the Variants, each of which overrides rules
+// of the rule set.
+type ExperimentConfig struct {
+	ID          string `yaml:"id"`
+	Description string `yaml:"description"`
+	// RuleSet is the version of the rule set the variants override. Only receipts scored by it take part.
+	RuleSet string `yaml:"ruleSet"`
+	// From and To are the first and last day (YYYY-MM-DD, UTC) on which submitted receipts are assigned a variant.
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+	// BucketBy is BucketByReceipt, the default, or BucketByUser.
+	BucketBy string          `yaml:"bucketBy"`
+	Variants []VariantConfig `yaml:"variants"`
+}
+
+// VariantConfig defines a variant of an experiment. A variant without rules scores receipts like the rule set itself,
+// which makes it the control group.
+type VariantConfig struct {
+	Name string `yaml:"name"`
+	// Weight is the share of the receipts assigned the variant, relative to the other variants. It defaults to 1.
+	Weight int                  `yaml:"weight"`
+	Rules  []RuleOverrideConfig `yaml:"rules"`
+}
+
+// RuleOverrideConfig overrides the rule with the same ID. Only the fields that are set are overridden, and Params are
+// merged into the parameters of the rule.
+type RuleOverrideConfig struct {
+	ID        string         `yaml:"id"`
+	Points    *int           `yaml:"points"`
+	MaxPoints *int           `yaml:"maxPoints"`
+	Enabled   *bool          `yaml:"enabled"`
+	Params    map[string]any `yaml:"params"`
+}
+
+// Experiment assigns receipts submitted within a date window to variants, each scoring them by a different version of
+// a rule set. Receipts are assigned by a hash of the receipt or user, so the same input always gets the same variant.
+type Experiment struct {
+	ID          string
+	Description string
+	ruleSet     string
+	from        time.Time
+	// until is the end of the last day of the experiment, exclusive.
+	until       time.Time
+	bucketBy    string
+	variants    []variant
+	totalWeight int
+	config      ExperimentConfig
+}
+
+type variant struct {
+	name     string
+	weight   int
+	registry *Registry
+}
+
+func newExperiment(config ExperimentConfig, ruleSets *RuleSets) (*Experiment, error) {
+	var errs []error
+	if config.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	registry, ok := ruleSets.Version(config.RuleSet)
+	if !ok {
+		errs = append(errs, fmt.Errorf("ruleSet must be the version of a rule set, got %q", config.RuleSet))
+	}
+
+	from, err := time.Parse("2006-01-02", config.From)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("from must be a date in the format YYYY-MM-DD, got %q", config.From))
+	}
+	to, err := time.Parse("2006-01-02", config.To)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("to must be a date in the format YYYY-MM-DD, got %q", config.To))
+	} else if to.Before(from) {
+		errs = append(errs, errors.New("to must not be before from"))
+	}
+
+	bucketBy := config.BucketBy
+	switch bucketBy {
+	case "":
+		bucketBy = BucketByReceipt
+	case BucketByReceipt, BucketByUser:
+	default:
+		errs = append(errs, fmt.Errorf("bucketBy must be %s or %s, got %q", BucketByReceipt, BucketByUser, bucketBy))
+	}
+
+	if len(config.Variants) < 2 {
+		errs = append(errs, errors.New("at least two variants are required"))
+	}
+	experiment := &Experiment{ID: config.ID, Description: config.Description, ruleSet: config.RuleSet, from: from,
+		until: to.AddDate(0, 0, 1), bucketBy: bucketBy, config: config}
+	names := make(map[string]bool)
+	for i, variantConfig := range config.Variants {
+		variant, err := newVariant(variantConfig, registry)
+		if err == nil && names[variant.name] {
+			err = errors.New("name is already defined")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("variant %d (%s): %w", i+1, variantConfig.Name, err))
+			continue
+		}
+		names[variant.name] = true
+		experiment.variants = append(experiment.variants, variant)
+		experiment.totalWeight += variant.weight
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return experiment, nil
+}
+
+func newVariant(config VariantConfig, registry *Registry) (variant, error) {
+	if config.Name == "" {
+		return variant{}, errors.New("name is required")
+	}
+	if config.Weight < 0 {
+		return variant{}, fmt.Errorf("weight must not be negative, got %d", config.Weight)
+	}
+	weight := config.Weight
+	if weight == 0 {
+		weight = 1
+	}
+	if registry == nil {
+		return variant{name: config.Name, weight: weight}, nil
+	}
+	overridden, err := registry.override(config.Rules)
+	if err != nil {
+		return variant{}, err
+	}
+	return variant{name: config.Name, weight: weight, registry: overridden}, nil
+}
+
+// override returns a registry holding the rule set of r with the rules overridden. It fails if r was not created from
+// a rule set definition.
+func (r *Registry) override(overrides []RuleOverrideConfig) (*Registry, error) {
+	if len(overrides) == 0 {
+		return r, nil
+	}
+	if r.config.Version == "" {
+		return nil, errors.New("rule set must be created from a definition")
+	}
+	config := r.config
+	config.Rules = append([]RuleConfig(nil), r.config.Rules...)
+	var errs []error
+	for i, override := range overrides {
+		index := -1
+		for j, rule := range config.Rules {
+			if rule.ID == override.ID {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			errs = append(errs, fmt.Errorf("rule %d (%s): not a rule of rule set %s", i+1, override.ID, r.version))
+			continue
+		}
+		rule := config.Rules[index]
+		if override.Points != nil {
+			rule.Points = *override.Points
+		}
+		if override.MaxPoints != nil {
+			rule.MaxPoints = *override.MaxPoints
+		}
+		if override.Enabled != nil {
+			rule.Enabled = override.Enabled
+		}
+		if len(override.Params) > 0 {
+			params := make(map[string]any, len(rule.Params)+len(override.Params))
+			for name, value := range rule.Params {
+				params[name] = value
+			}
+			for name, value := range override.Params {
+				params[name] = value
+			}
+			rule.Params = params
+		}
+		config.Rules[index] = rule
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return NewRuleSet(config)
+}
+
+// From returns the start of the first day of the experiment.
+func (e *Experiment) From() time.Time {
+	return e.from
+}
+
+// Variants returns the names of the variants.
+func (e *Experiment) Variants() []string {
+	names := make([]string, 0, len(e.variants))
+	for _, variant := range e.variants {
+		names = append(names, variant.name)
+	}
+	return names
+}
+
+// bucket returns the variant assigned to the key. Every variant is assigned to its share of all keys.
+func (e *Experiment) bucket(key string) variant {
+	h := fnv.New64a()
+	h.Write([]byte(e.ID))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	n := h.Sum64() % uint64(e.totalWeight)
+	for _, variant := range e.variants {
+		if n < uint64(variant.weight) {
+			return variant
+		}
+		n -= uint64(variant.weight)
+	}
+	panic("weights do not add up")
+}
+
+// Assign returns the variant a receipt submitted at submittedAt is assigned by the first experiment running at the
+// time for the rule set the receipt is scored by, or nil if there is none. receiptKey identifies the content of the
+// receipt and userID the submitting user; experiments bucketing by user do not assign receipts without one.
+func (s *RuleSets) Assign(receipt model.Receipt, receiptKey, userID string, submittedAt time.Time) *model.ExperimentAssignment {
+	version := s.For(receipt, submittedAt).Version()
+	for _, experiment := range s.experiments {
+		if experiment.ruleSet != version || submittedAt.Before(experiment.from) || !submittedAt.Before(experiment.until) {
+			continue
+		}
+		key := receiptKey
+		if experiment.bucketBy == BucketByUser {
+			key = userID
+		}
+		if key == "" {
+			continue
+		}
+		return &model.ExperimentAssignment{Id: experiment.ID, Variant: experiment.bucket(key).name}
+	}
+	return nil
+}
+
+// variant returns the rule set of the variant the receipt was assigned, if it overrides registry.
+func (s *RuleSets) variant(receipt model.Receipt, registry *Registry) (*Registry, bool) {
+	if receipt.Experiment == nil {
+		return nil, false
+	}
+	for _, experiment := range s.experiments {
+		if experiment.ID != receipt.Experiment.Id || experiment.ruleSet != registry.version {
+			continue
+		}
+		for _, variant := range experiment.variants {
+			if variant.name == receipt.Experiment.Variant {
+				return variant.registry, true
+			}
+		}
+	}
+	return nil, false
+}
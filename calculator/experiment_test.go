@@ -0,0 +1,295 @@
+package calculator
+
+import (
+	"fetch-assessment/model"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const experimentRuleSets = `
+ruleSets:
+  - version: "1"
+    rules:
+      - {id: name, type: retailer-name-length, points: 1}
+      - {id: pairs, type: item-groups, points: 5, params: {size: 2}}
+  - version: "2"
+    effectiveFrom: 2025-06-01
+    rules: [{id: name, type: retailer-name-length, points: 1}]
+experiments:
+  - id: pairs-points
+    ruleSet: "1"
+    from: 2025-03-01
+    to: 2025-03-31
+    variants:
+      - {name: control, weight: 3}
+      - name: double
+        rules: [{id: pairs, points: 10}]
+      - name: triples
+        rules: [{id: pairs, params: {size: 3}}, {id: name, enabled: false}]
+  - id: by-user
+    ruleSet: "1"
+    from: 2025-04-01
+    to: 2025-04-30
+    bucketBy: user
+    variants: [{name: control}, {name: no-name, rules: [{id: name, points: 0}]}]
+`
+
+func TestAssignExperiment(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(experimentRuleSets))
+	require.NoError(t, err)
+	require.Len(t, ruleSets.Experiments(), 2)
+	assert.Equal(t, []string{"control", "double", "triples"}, ruleSets.Experiments()[0].Variants())
+	receipt := model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate("2025-03-01")}
+	march := time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	counts := make(map[string]int)
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("receipt-%d", i)
+		assignment := ruleSets.Assign(receipt, key, "", march)
+		require.NotNil(t, assignment)
+		assert.Equal(t, "pairs-points", assignment.Id)
+		// the same key is always assigned the same variant
+		assert.Equal(t, assignment, ruleSets.Assign(receipt, key, "user", march.Add(time.Hour)))
+		counts[assignment.Variant]++
+	}
+	assert.InDelta(t, 3000, counts["control"], 150)
+	assert.InDelta(t, 1000, counts["double"], 100)
+	assert.InDelta(t, 1000, counts["triples"], 100)
+
+	tests := []struct {
+		name         string
+		purchaseDate string
+		userID       string
+		submittedAt  time.Time
+		wantID       string
+	}{
+		{
+			name:         "submitted before the experiment",
+			purchaseDate: "2025-02-28",
+			submittedAt:  time.Date(2025, 2, 28, 23, 59, 0, 0, time.UTC),
+		},
+		{
+			name:         "submitted on the last day",
+			purchaseDate: "2025-03-31",
+			submittedAt:  time.Date(2025, 3, 31, 23, 59, 0, 0, time.UTC),
+			wantID:       "pairs-points",
+		},
+		{
+			name:         "scored by another rule set",
+			purchaseDate: "2025-06-01",
+			submittedAt:  march,
+		},
+		{
+			name:         "bucketed by user without a user",
+			purchaseDate: "2025-04-01",
+			submittedAt:  time.Date(2025, 4, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:         "bucketed by user",
+			purchaseDate: "2025-04-01",
+			userID:       "user-1",
+			submittedAt:  time.Date(2025, 4, 10, 0, 0, 0, 0, time.UTC),
+			wantID:       "by-user",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate(tt.purchaseDate)}
+			assignment := ruleSets.Assign(receipt, "receipt", tt.userID, tt.submittedAt)
+			if tt.wantID == "" {
+				assert.Nil(t, assignment)
+				return
+			}
+			require.NotNil(t, assignment)
+			assert.Equal(t, tt.wantID, assignment.Id)
+		})
+	}
+}
+
+func TestScoreExperimentVariants(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(experimentRuleSets))
+	require.NoError(t, err)
+	items := []model.Item{{ShortDescription: "a", Price: "1.00"}, {ShortDescription: "b", Price: "1.00"},
+		{ShortDescription: "c", Price: "1.00"}, {ShortDescription: "d", Price: "1.00"}}
+
+	tests := []struct {
+		name           string
+		experiment     *model.ExperimentAssignment
+		wantPoints     int
+		wantExperiment bool
+	}{
+		{
+			name:       "not assigned",
+			wantPoints: 16,
+		},
+		{
+			name:           "control",
+			experiment:     &model.ExperimentAssignment{Id: "pairs-points", Variant: "control"},
+			wantPoints:     16,
+			wantExperiment: true,
+		},
+		{
+			name:           "points overridden",
+			experiment:     &model.ExperimentAssignment{Id: "pairs-points", Variant: "double"},
+			wantPoints:     26,
+			wantExperiment: true,
+		},
+		{
+			name:           "params overridden and rule disabled",
+			experiment:     &model.ExperimentAssignment{Id: "pairs-points", Variant: "triples"},
+			wantPoints:     5,
+			wantExperiment: true,
+		},
+		{
+			name:       "unknown variant",
+			experiment: &model.ExperimentAssignment{Id: "pairs-points", Variant: "withdrawn"},
+			wantPoints: 16,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate("2025-03-01"), Items: items,
+				Experiment: tt.experiment}
+			score := ruleSets.Score(receipt, time.Now())
+			assert.Equal(t, "1", score.RuleSetVersion)
+			assert.Equal(t, tt.wantPoints, score.Points)
+			if tt.wantExperiment {
+				assert.Equal(t, tt.experiment, score.Experiment)
+			} else {
+				assert.Nil(t, score.Experiment)
+			}
+		})
+	}
+
+	// variants only override the rule set of the experiment
+	receipt := model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate("2025-06-01"), Items: items,
+		Experiment: &model.ExperimentAssignment{Id: "pairs-points", Variant: "double"}}
+	score := ruleSets.Score(receipt, time.Now())
+	assert.Equal(t, 6, score.Points)
+	assert.Nil(t, score.Experiment)
+}
+
+func TestParseRuleSetsRejectsInvalidExperiments(t *testing.T) {
+	tests := []struct {
+		name       string
+		experiment string
+		wantErr    string
+	}{
+		{
+			name:       "missing id",
+			experiment: `{ruleSet: "1", from: 2025-03-01, to: 2025-03-31, variants: [{name: a}, {name: b}]}`,
+			wantErr:    "experiment 1 (): id is required",
+		},
+		{
+			name:       "unknown rule set",
+			experiment: `{id: e, ruleSet: "2", from: 2025-03-01, to: 2025-03-31, variants: [{name: a}, {name: b}]}`,
+			wantErr:    `experiment 1 (e): ruleSet must be the version of a rule set, got "2"`,
+		},
+		{
+			name:       "to before from",
+			experiment: `{id: e, ruleSet: "1", from: 2025-03-01, to: 2025-02-28, variants: [{name: a}, {name: b}]}`,
+			wantErr:    "to must not be before from",
+		},
+		{
+			name:       "unknown bucketBy",
+			experiment: `{id: e, ruleSet: "1", from: 2025-03-01, to: 2025-03-31, bucketBy: day, variants: [{name: a}, {name: b}]}`,
+			wantErr:    `bucketBy must be receipt or user, got "day"`,
+		},
+		{
+			name:       "single variant",
+			experiment: `{id: e, ruleSet: "1", from: 2025-03-01, to: 2025-03-31, variants: [{name: a}]}`,
+			wantErr:    "at least two variants are required",
+		},
+		{
+			name:       "duplicate variant",
+			experiment: `{id: e, ruleSet: "1", from: 2025-03-01, to: 2025-03-31, variants: [{name: a}, {name: a}]}`,
+			wantErr:    "variant 2 (a): name is already defined",
+		},
+		{
+			name:       "negative weight",
+			experiment: `{id: e, ruleSet: "1", from: 2025-03-01, to: 2025-03-31, variants: [{name: a, weight: -1}, {name: b}]}`,
+			wantErr:    "variant 1 (a): weight must not be negative, got -1",
+		},
+		{
+			name: "unknown rule",
+			experiment: `{id: e, ruleSet: "1", from: 2025-03-01, to: 2025-03-31,
+				variants: [{name: a}, {name: b, rules: [{id: pairs, points: 10}]}]}`,
+			wantErr: "variant 2 (b): rule 1 (pairs): not a rule of rule set 1",
+		},
+		{
+			name: "invalid override",
+			experiment: `{id: e, ruleSet: "1", from: 2025-03-01, to: 2025-03-31,
+				variants: [{name: a}, {name: b, rules: [{id: a, params: {size: 2}}]}]}`,
+			wantErr: "variant 2 (b): rule 1 (a): unknown parameter size",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleSets([]byte(`{version: "1", rules: [{id: a, type: retailer-name-length}],
+				experiments: [` + tt.experiment + `]}`))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestReloadExperiments(t *testing.T) {
+	builtIn := ActiveRuleSets()
+	t.Cleanup(func() { Activate(builtIn) })
+	dir := t.TempDir()
+	write := func(name, experiments string) string {
+		path := filepath.Join(dir, name)
+		definition := `{version: "1", rules: [{id: a, type: retailer-name-length, points: 1}], experiments: [` +
+			experiments + `]}`
+		require.NoError(t, os.WriteFile(path, []byte(definition), 0o644))
+		return path
+	}
+	day := func(days int) string {
+		return time.Now().UTC().AddDate(0, 0, days).Format("2006-01-02")
+	}
+	experiment := func(id string, from, points int) string {
+		return fmt.Sprintf(`{id: %s, ruleSet: "1", from: %s, to: %s, variants: [{name: control},
+			{name: more, rules: [{id: a, points: %d}]}]}`, id, day(from), day(from+7), points)
+	}
+	Activate(mustLoadRuleSets(t, write("initial.yaml", experiment("started", -1, 2))))
+	ruleSets := ActiveRuleSets()
+
+	tests := []struct {
+		name        string
+		experiments string
+		wantErr     string
+	}{
+		{
+			name:        "started experiment changed",
+			experiments: experiment("started", -1, 3),
+			wantErr:     "experiment started has already started and must be kept unchanged",
+		},
+		{
+			name:        "started experiment dropped",
+			experiments: experiment("scheduled", 1, 2),
+			wantErr:     "experiment started has already started and must be kept unchanged",
+		},
+		{
+			name:        "experiment starting in the past",
+			experiments: experiment("started", -1, 2) + ", " + experiment("new", 0, 2),
+			wantErr:     "experiment new must start in the future",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReloadRuleSets(write("rejected.yaml", tt.experiments))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Same(t, ruleSets, ActiveRuleSets())
+		})
+	}
+
+	_, err := ReloadRuleSets(write("scheduled.yaml", experiment("started", -1, 2)+", "+experiment("scheduled", 1, 2)))
+	require.NoError(t, err)
+	assert.Len(t, ActiveRuleSets().Experiments(), 2)
+}
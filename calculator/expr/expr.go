@@ -0,0 +1,135 @@
+// Package expr implements a small expression language over the fields of a receipt, used to define custom points
+// rules such as
+//
+//	retailer contains "Target" and total > 50
+//
+// Expressions are type checked and compiled once. They have no side effects and cannot access anything but the
+// receipt, and their evaluation always terminates: the only iteration is over the items of the receipt.
+//
+// The receipt fields are retailer (string), date (date), time (time of day), total (number) and items. Inside the
+// item aggregates any, all, count, sum, min and max, the fields description (string), price (number) and category
+// (string, empty if the item has none) refer to the current item. Numbers are exact decimals. Strings are quoted with
+// " or '. A string literal compared with a date ("2024-01-31") or a time ("14:00") is read as one.
+//
+// Operators, from lowest to highest precedence: or; and; not; the comparisons =, ==, !=, <, <=, >, >=, contains and
+// startsWith; + and -; *; unary -. Functions: len, lower and trim on strings; year, month, day and weekday (1 is
+// Monday) on dates; hour and minute on times; count(items), count(items, condition), any(items, condition),
+// all(items, condition), and sum, min and max(items, number), where min and max of no items are 0.
+package expr
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"fetch-assessment/money"
+	"fetch-assessment/utils"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxLength is the maximum length of the source of an expression.
+const MaxLength = 4096
+
+// maxDepth limits the nesting of expressions.
+const maxDepth = 64
+
+// Type is the type of an expression.
+type Type int
+
+const (
+	Bool Type = iota
+	Number
+	String
+	Date
+	Time
+	Items
+)
+
+func (t Type) String() string {
+	return [...]string{"boolean", "number", "string", "date", "time", "items"}[t]
+}
+
+// Error is an error in the source of an expression, at the given line and column, both starting at 1.
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+func errorAt(source string, pos int, format string, args ...any) error {
+	line := 1 + strings.Count(source[:pos], "\n")
+	column := 1 + utf8.RuneCountInString(source[strings.LastIndex(source[:pos], "\n")+1:pos])
+	return &Error{Line: line, Column: column, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Condition is a compiled boolean expression. It is safe for concurrent use.
+type Condition struct {
+	source string
+	eval   func(*scope) any
+}
+
+// CompileCondition parses and type checks a boolean expression. Errors in the expression are reported as *Error.
+func CompileCondition(source string) (*Condition, error) {
+	if len(source) > MaxLength {
+		return nil, fmt.Errorf("expression is longer than %d bytes", MaxLength)
+	}
+	if strings.TrimSpace(source) == "" {
+		return nil, errors.New("expression is empty")
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{source: source, tokens: tokens}
+	e, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if e.typ != Bool {
+		return nil, errorAt(source, e.pos, "expression must be a condition, got %s", e.typ)
+	}
+	return &Condition{source: source, eval: e.eval}, nil
+}
+
+// String returns the source of the condition.
+func (c *Condition) String() string {
+	return c.source
+}
+
+// Holds evaluates the condition for the receipt.
+func (c *Condition) Holds(receipt model.Receipt) bool {
+	return c.eval(&scope{receipt: &receipt}).(bool)
+}
+
+// scope holds the values the fields of an expression refer to.
+type scope struct {
+	receipt *model.Receipt
+	// item is the current item in an item aggregate.
+	item *model.Item
+}
+
+// amount converts an amount validated before scoring into a number.
+func amount(value string) *big.Rat {
+	// parsing errors can be ignored due to preceding validation rules
+	cents, _ := money.Parse(value)
+	return big.NewRat(cents.Cents(), 100)
+}
+
+// category returns the category of the item, or an empty string if it has none.
+func category(item *model.Item) string {
+	if item.Category == nil {
+		return ""
+	}
+	return *item.Category
+}
+
+func minutes(value string) int64 {
+	// parsing errors can be ignored due to preceding validation rules
+	hour, minute, _ := utils.ParsePurchaseTime(value)
+	return int64(hour*60 + minute)
+}
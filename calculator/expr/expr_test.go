@@ -0,0 +1,192 @@
+package expr
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCondition(t *testing.T) {
+	receipt := model.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: openapi_types.Date{Time: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		PurchaseTime: "13:01",
+		Total:        "35.35",
+		Items: []model.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+			{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
+			{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
+			{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
+		},
+	}
+
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{`retailer contains "Target" and total > 30`, true},
+		{`retailer contains 'Target' and total > 50`, false},
+		{`retailer = "Target"`, true},
+		{`retailer == "target"`, false},
+		{`lower(retailer) = "target"`, true},
+		{`retailer startsWith "Tar"`, true},
+		{`not retailer startsWith "get"`, true},
+		{`len(retailer) = 6`, true},
+		{`total = 35.35`, true},
+		{`total = 35.350`, true},
+		{`total != 35.35`, false},
+		{`total >= 35.35 and total <= 35.35`, true},
+		{`total * 2 = 70.70`, true},
+		{`total - 0.35 = 35`, true},
+		{`total + -35.35 = 0`, true},
+		{`-total < 0`, true},
+		{`0.1 + 0.2 = 0.3`, true},
+		{`date = "2022-01-01"`, true},
+		{`"2021-12-31" < date`, true},
+		{`date >= "2022-01-02"`, false},
+		{`year(date) = 2022 and month(date) = 1 and day(date) = 1`, true},
+		{`weekday(date) = 6`, true},
+		{`time >= "13:00" and time < "14:00"`, true},
+		{`hour(time) = 13 and minute(time) = 1`, true},
+		{`count(items) = 5`, true},
+		{`count(items, price > 5) = 3`, true},
+		{`any(items, description contains "Pizza")`, true},
+		{`any(items, description contains "Pepsi")`, false},
+		{`all(items, price < 20)`, true},
+		{`all(items, price < 10)`, false},
+		{`sum(items, price) = total`, true},
+		{`min(items, price) = 1.26 and max(items, price) = 12.25`, true},
+		{`count(items, len(trim(description)) = 24) = 1`, true},
+		{`any(items, price * 2 > total)`, false},
+		{`true or false and false`, true},
+		{`(true or false) and false`, false},
+		{`not not true`, true},
+		{`true != false`, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.source, func(t *testing.T) {
+			condition, err := CompileCondition(tc.source)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, condition.Holds(receipt))
+			assert.Equal(t, tc.source, condition.String())
+		})
+	}
+}
+
+func TestConditionWithoutItems(t *testing.T) {
+	receipt := model.Receipt{Retailer: "Target", Total: "0.00"}
+	for source, want := range map[string]bool{
+		`count(items) = 0`:       true,
+		`any(items, price > 0)`:  false,
+		`all(items, price > 0)`:  true,
+		`sum(items, price) = 0`:  true,
+		`min(items, price) = 0`:  true,
+		`max(items, price) = 0`:  true,
+		`count(items, true) = 0`: true,
+	} {
+		condition, err := CompileCondition(source)
+		require.NoError(t, err, source)
+		assert.Equal(t, want, condition.Holds(receipt), source)
+	}
+}
+
+func TestConditionWithCategories(t *testing.T) {
+	beverages := "beverages"
+	receipt := model.Receipt{Items: []model.Item{
+		{ShortDescription: "Soda", Price: "1.00", Category: &beverages},
+		{ShortDescription: "Gift Card", Price: "25.00"},
+	}}
+	for source, want := range map[string]bool{
+		`count(items, category = "beverages") = 1`: true,
+		`any(items, category = "")`:                true,
+		`all(items, category startsWith "bev")`:    false,
+	} {
+		condition, err := CompileCondition(source)
+		require.NoError(t, err, source)
+		assert.Equal(t, want, condition.Holds(receipt), source)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		{`total > "50"`, `1:7: cannot compare number with string`},
+		{`retailer contains 5`, `1:19: operator contains expects string operands, got number`},
+		{`total and true`, `1:1: operator and expects boolean operands, got number`},
+		{`not total`, `1:5: operator not expects boolean operands, got number`},
+		{`retailer + 1 > 2`, `1:1: operator + expects number operands, got string`},
+		{`-retailer = 1`, `1:2: operator - expects number operands, got string`},
+		{`true < false`, `1:6: operator < cannot be applied to booleans`},
+		{`items = items`, `1:7: cannot compare items with items`},
+		{`total`, `1:1: expression must be a condition, got number`},
+		{`price > 5`, `1:1: price is only available in item aggregates`},
+		{`count(items) > 0 and description = "x"`, `1:22: description is only available in item aggregates`},
+		{`category = "dairy"`, `1:1: category is only available in item aggregates`},
+		{`subtotal > 5`, `1:1: unknown field subtotal`},
+		{`round(total) > 5`, `1:1: unknown function round`},
+		{`len(total) > 5`, `1:5: function len expects string as argument 1, got number`},
+		{`any(items) `, `1:1: function any expects 2 arguments, got 1`},
+		{`count(items, true, true) > 0`, `1:1: function count expects 1 or 2 arguments, got 3`},
+		{`any(items, price)`, `1:12: function any expects boolean as argument 2, got number`},
+		{`sum(retailer, 1) > 0`, `1:5: function sum expects items as argument 1, got string`},
+		{`date > "January"`, `1:8: invalid date "January", expected YYYY-MM-DD`},
+		{`time < "25:00"`, `1:8: invalid time "25:00", expected HH:MM`},
+		{`date > ("2022-01-01")`, `1:6: cannot compare date with string`},
+		{`total > 1 > 0`, `1:11: comparisons cannot be chained, use and`},
+		{`(total > 1`, `1:11: expected ")", got end of expression`},
+		{`total > 1)`, `1:10: unexpected ")"`},
+		{`total >`, `1:8: unexpected end of expression`},
+		{`retailer contains "Target`, `1:19: string is not terminated`},
+		{`total > 5 & true`, `1:11: unexpected character '&'`},
+		{`total > 5 and`, `1:14: unexpected end of expression`},
+		{"retailer = \"Target\"\n  and total >\n  \"50\"", `2:13: cannot compare number with string`},
+		{`contains = 1`, `1:1: unexpected "contains"`},
+		{`retailer = "Täget" and x`, `1:24: unknown field x`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.source, func(t *testing.T) {
+			_, err := CompileCondition(tc.source)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+			var exprErr *Error
+			assert.True(t, errors.As(err, &exprErr))
+		})
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	_, err := CompileCondition("  ")
+	assert.EqualError(t, err, "expression is empty")
+
+	_, err = CompileCondition(strings.Repeat(" ", MaxLength) + "true")
+	assert.EqualError(t, err, "expression is longer than 4096 bytes")
+
+	_, err = CompileCondition(strings.Repeat("(", 100) + "true" + strings.Repeat(")", 100))
+	assert.ErrorContains(t, err, "expression is nested more than 64 levels deep")
+
+	_, err = CompileCondition(strings.Repeat("not ", 100) + "true")
+	assert.ErrorContains(t, err, "expression is nested more than 64 levels deep")
+}
+
+func TestLex(t *testing.T) {
+	tokens, err := lex(`total>=12.5 and "a\"b" contains 'c'`)
+	require.NoError(t, err)
+	assert.Equal(t, []token{
+		{kind: tokenIdent, text: "total", pos: 0},
+		{kind: tokenOperator, text: ">=", pos: 5},
+		{kind: tokenNumber, text: "12.5", pos: 7},
+		{kind: tokenIdent, text: "and", pos: 12},
+		{kind: tokenString, text: `a"b`, pos: 16},
+		{kind: tokenIdent, text: "contains", pos: 23},
+		{kind: tokenString, text: "c", pos: 32},
+		{kind: tokenEOF, pos: 35},
+	}, tokens)
+}
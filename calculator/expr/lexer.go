@@ -0,0 +1,109 @@
+package expr
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	// text is the source of the token, or the unquoted value of a string.
+	text string
+	// pos is the byte offset of the token in the source.
+	pos int
+}
+
+// operators are ordered so that longer operators are matched first.
+var operators = []string{"==", "!=", "<=", ">=", "=", "<", ">", "+", "-", "*", "(", ")", ","}
+
+// lex splits the source into tokens, ending with a tokenEOF.
+func lex(source string) ([]token, error) {
+	var tokens []token
+	pos := 0
+	for pos < len(source) {
+		r, size := utf8.DecodeRuneInString(source[pos:])
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+		case isLetter(r):
+			end := pos + scan(source[pos:], func(r rune) bool { return isLetter(r) || isDigit(r) })
+			tokens = append(tokens, token{kind: tokenIdent, text: source[pos:end], pos: pos})
+			pos = end
+		case isDigit(r):
+			end := pos + scan(source[pos:], isDigit)
+			if end+1 < len(source) && source[end] == '.' && isDigit(rune(source[end+1])) {
+				end += 1 + scan(source[end+1:], isDigit)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[pos:end], pos: pos})
+			pos = end
+		case r == '"' || r == '\'':
+			text, end, err := unquote(source, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: pos})
+			pos = end
+		default:
+			operator := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(source[pos:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, errorAt(source, pos, "unexpected character %q", r)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, pos: pos})
+			pos += len(operator)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// unquote reads the string starting with the quote at pos. A backslash escapes the following character.
+func unquote(source string, pos int) (string, int, error) {
+	quote := source[pos]
+	var text strings.Builder
+	for i := pos + 1; i < len(source); i++ {
+		switch source[i] {
+		case quote:
+			return text.String(), i + 1, nil
+		case '\\':
+			if i+1 < len(source) {
+				i++
+			}
+		}
+		text.WriteByte(source[i])
+	}
+	return "", 0, errorAt(source, pos, "string is not terminated")
+}
+
+// scan returns the length of the prefix of s whose runes all match.
+func scan(s string, match func(rune) bool) int {
+	for i, r := range s {
+		if !match(r) {
+			return i
+		}
+	}
+	return len(s)
+}
+
+func isLetter(r rune) bool {
+	return r == '_' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
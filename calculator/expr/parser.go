@@ -0,0 +1,604 @@
+package expr
+
+import (
+	"cmp"
+	"fetch-assessment/model"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// compiled is a type checked expression.
+type compiled struct {
+	typ Type
+	// pos is the byte offset of the expression in the source.
+	pos  int
+	eval func(*scope) any
+	// literal holds the value of a string literal, so that it can be read as a date or time.
+	literal *string
+}
+
+// parser compiles the tokens of an expression by recursive descent, checking types as it goes.
+type parser struct {
+	source string
+	tokens []token
+	next   int
+	depth  int
+	// inItem is set while parsing the arguments of an item aggregate that are evaluated for each item.
+	inItem bool
+}
+
+func (p *parser) parse() (*compiled, error) {
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.errorAt(t, "unexpected %s", describe(t))
+	}
+	return e, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) isKeyword(t token, keyword string) bool {
+	return t.kind == tokenIdent && t.text == keyword
+}
+
+func (p *parser) isOperator(t token, operator string) bool {
+	return t.kind == tokenOperator && t.text == operator
+}
+
+func (p *parser) expect(operator string) error {
+	t := p.advance()
+	if !p.isOperator(t, operator) {
+		return p.errorAt(t, "expected %q, got %s", operator, describe(t))
+	}
+	return nil
+}
+
+func (p *parser) errorAt(t token, format string, args ...any) error {
+	return errorAt(p.source, t.pos, format, args...)
+}
+
+// enter guards against expressions nested deeply enough to exhaust the stack.
+func (p *parser) enter(t token) error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorAt(t, "expression is nested more than %d levels deep", maxDepth)
+	}
+	return nil
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// operands checks that all operands of the operator have the wanted type.
+func (p *parser) operands(operator token, want Type, operands ...*compiled) error {
+	for _, operand := range operands {
+		if operand.typ != want {
+			return errorAt(p.source, operand.pos, "operator %s expects %s operands, got %s", operator.text, want,
+				operand.typ)
+		}
+	}
+	return nil
+}
+
+func (p *parser) or() (*compiled, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), "or") {
+		operator := p.advance()
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.operands(operator, Bool, left, right); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &compiled{typ: Bool, pos: left.pos, eval: func(s *scope) any { return l(s).(bool) || r(s).(bool) }}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (*compiled, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword(p.peek(), "and") {
+		operator := p.advance()
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.operands(operator, Bool, left, right); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &compiled{typ: Bool, pos: left.pos, eval: func(s *scope) any { return l(s).(bool) && r(s).(bool) }}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (*compiled, error) {
+	if !p.isKeyword(p.peek(), "not") {
+		return p.comparison()
+	}
+	operator := p.advance()
+	if err := p.enter(operator); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	operand, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.operands(operator, Bool, operand); err != nil {
+		return nil, err
+	}
+	eval := operand.eval
+	return &compiled{typ: Bool, pos: operator.pos, eval: func(s *scope) any { return !eval(s).(bool) }}, nil
+}
+
+func (p *parser) isComparison(t token) bool {
+	switch {
+	case t.kind == tokenOperator:
+		switch t.text {
+		case "=", "==", "!=", "<", "<=", ">", ">=":
+			return true
+		}
+	case p.isKeyword(t, "contains"), p.isKeyword(t, "startsWith"):
+		return true
+	}
+	return false
+}
+
+func (p *parser) comparison() (*compiled, error) {
+	left, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isComparison(p.peek()) {
+		return left, nil
+	}
+	operator := p.advance()
+	right, err := p.additive()
+	if err != nil {
+		return nil, err
+	}
+	if p.isComparison(p.peek()) {
+		return nil, p.errorAt(p.peek(), "comparisons cannot be chained, use and")
+	}
+	return p.compare(operator, left, right)
+}
+
+func (p *parser) compare(operator token, left, right *compiled) (*compiled, error) {
+	if operator.kind == tokenIdent {
+		if err := p.operands(operator, String, left, right); err != nil {
+			return nil, err
+		}
+		match := strings.Contains
+		if operator.text == "startsWith" {
+			match = strings.HasPrefix
+		}
+		l, r := left.eval, right.eval
+		return &compiled{typ: Bool, pos: left.pos, eval: func(s *scope) any {
+			return match(l(s).(string), r(s).(string))
+		}}, nil
+	}
+
+	var err error
+	if left, err = p.coerce(left, right.typ); err != nil {
+		return nil, err
+	}
+	if right, err = p.coerce(right, left.typ); err != nil {
+		return nil, err
+	}
+	if left.typ != right.typ || left.typ == Items {
+		return nil, p.errorAt(operator, "cannot compare %s with %s", left.typ, right.typ)
+	}
+	ordered := operator.text != "=" && operator.text != "==" && operator.text != "!="
+	if ordered && left.typ == Bool {
+		return nil, p.errorAt(operator, "operator %s cannot be applied to booleans", operator.text)
+	}
+
+	var holds func(int) bool
+	switch operator.text {
+	case "=", "==":
+		holds = func(c int) bool { return c == 0 }
+	case "!=":
+		holds = func(c int) bool { return c != 0 }
+	case "<":
+		holds = func(c int) bool { return c < 0 }
+	case "<=":
+		holds = func(c int) bool { return c <= 0 }
+	case ">":
+		holds = func(c int) bool { return c > 0 }
+	case ">=":
+		holds = func(c int) bool { return c >= 0 }
+	}
+	typ, l, r := left.typ, left.eval, right.eval
+	return &compiled{typ: Bool, pos: left.pos, eval: func(s *scope) any {
+		return holds(compareValues(typ, l(s), r(s)))
+	}}, nil
+}
+
+// coerce reads a string literal as a date or time if it is compared with one.
+func (p *parser) coerce(e *compiled, other Type) (*compiled, error) {
+	if e.literal == nil || (other != Date && other != Time) {
+		return e, nil
+	}
+	if other == Date {
+		date, err := time.Parse("2006-01-02", *e.literal)
+		if err != nil {
+			return nil, errorAt(p.source, e.pos, "invalid date %q, expected YYYY-MM-DD", *e.literal)
+		}
+		return constant(Date, e.pos, date), nil
+	}
+	t, err := time.Parse("15:04", *e.literal)
+	if err != nil {
+		return nil, errorAt(p.source, e.pos, "invalid time %q, expected HH:MM", *e.literal)
+	}
+	return constant(Time, e.pos, int64(t.Hour()*60+t.Minute())), nil
+}
+
+func compareValues(typ Type, a, b any) int {
+	switch typ {
+	case Bool:
+		if a.(bool) == b.(bool) {
+			return 0
+		}
+		return 1
+	case Number:
+		return a.(*big.Rat).Cmp(b.(*big.Rat))
+	case String:
+		return strings.Compare(a.(string), b.(string))
+	case Date:
+		return a.(time.Time).Compare(b.(time.Time))
+	default:
+		return cmp.Compare(a.(int64), b.(int64))
+	}
+}
+
+func (p *parser) additive() (*compiled, error) {
+	left, err := p.multiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator(p.peek(), "+") || p.isOperator(p.peek(), "-") {
+		operator := p.advance()
+		right, err := p.multiplicative()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.operands(operator, Number, left, right); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		if operator.text == "+" {
+			left = &compiled{typ: Number, pos: left.pos, eval: func(s *scope) any {
+				return new(big.Rat).Add(l(s).(*big.Rat), r(s).(*big.Rat))
+			}}
+		} else {
+			left = &compiled{typ: Number, pos: left.pos, eval: func(s *scope) any {
+				return new(big.Rat).Sub(l(s).(*big.Rat), r(s).(*big.Rat))
+			}}
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) multiplicative() (*compiled, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator(p.peek(), "*") {
+		operator := p.advance()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.operands(operator, Number, left, right); err != nil {
+			return nil, err
+		}
+		l, r := left.eval, right.eval
+		left = &compiled{typ: Number, pos: left.pos, eval: func(s *scope) any {
+			return new(big.Rat).Mul(l(s).(*big.Rat), r(s).(*big.Rat))
+		}}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (*compiled, error) {
+	if !p.isOperator(p.peek(), "-") {
+		return p.primary()
+	}
+	operator := p.advance()
+	if err := p.enter(operator); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	operand, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.operands(operator, Number, operand); err != nil {
+		return nil, err
+	}
+	eval := operand.eval
+	return &compiled{typ: Number, pos: operator.pos, eval: func(s *scope) any {
+		return new(big.Rat).Neg(eval(s).(*big.Rat))
+	}}, nil
+}
+
+func (p *parser) primary() (*compiled, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenNumber:
+		number, _ := new(big.Rat).SetString(t.text)
+		return constant(Number, t.pos, number), nil
+	case tokenString:
+		e := constant(String, t.pos, t.text)
+		e.literal = &t.text
+		return e, nil
+	case tokenIdent:
+		if p.isOperator(p.peek(), "(") {
+			return p.call(t)
+		}
+		return p.field(t)
+	}
+	if !p.isOperator(t, "(") {
+		return nil, p.errorAt(t, "unexpected %s", describe(t))
+	}
+	if err := p.enter(t); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	// the literal is no longer read as a date or time once parenthesized
+	return &compiled{typ: e.typ, pos: t.pos, eval: e.eval}, nil
+}
+
+func (p *parser) field(t token) (*compiled, error) {
+	var typ Type
+	var eval func(*scope) any
+	switch t.text {
+	case "true", "false":
+		return constant(Bool, t.pos, t.text == "true"), nil
+	case "retailer":
+		typ, eval = String, func(s *scope) any { return s.receipt.Retailer }
+	case "date":
+		typ, eval = Date, func(s *scope) any { return s.receipt.PurchaseDate.Time }
+	case "time":
+		typ, eval = Time, func(s *scope) any { return minutes(s.receipt.PurchaseTime) }
+	case "total":
+		typ, eval = Number, func(s *scope) any { return amount(s.receipt.Total) }
+	case "items":
+		typ, eval = Items, func(s *scope) any { return s.receipt.Items }
+	case "description", "price", "category":
+		if !p.inItem {
+			return nil, p.errorAt(t, "%s is only available in item aggregates, such as any(items, %s ...)", t.text,
+				t.text)
+		}
+		switch t.text {
+		case "description":
+			typ, eval = String, func(s *scope) any { return s.item.ShortDescription }
+		case "price":
+			typ, eval = Number, func(s *scope) any { return amount(s.item.Price) }
+		default:
+			typ, eval = String, func(s *scope) any { return category(s.item) }
+		}
+	case "and", "or", "not", "contains", "startsWith":
+		return nil, p.errorAt(t, "unexpected %s", describe(t))
+	default:
+		return nil, p.errorAt(t, "unknown field %s", t.text)
+	}
+	return &compiled{typ: typ, pos: t.pos, eval: eval}, nil
+}
+
+func constant(typ Type, pos int, value any) *compiled {
+	return &compiled{typ: typ, pos: pos, eval: func(*scope) any { return value }}
+}
+
+func (p *parser) call(name token) (*compiled, error) {
+	f, ok := functions[name.text]
+	if !ok {
+		return nil, p.errorAt(name, "unknown function %s", name.text)
+	}
+	if err := p.enter(name); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	p.advance()
+
+	var args []*compiled
+	inItem := p.inItem
+	for !p.isOperator(p.peek(), ")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		p.inItem = inItem || (f.aggregate && len(args) > 0)
+		arg, err := p.or()
+		p.inItem = inItem
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.advance()
+
+	if len(args) < f.required || len(args) > len(f.params) {
+		want := fmt.Sprint(len(f.params))
+		if f.required < len(f.params) {
+			want = fmt.Sprintf("%d or %d", f.required, len(f.params))
+		}
+		return nil, p.errorAt(name, "function %s expects %s arguments, got %d", name.text, want, len(args))
+	}
+	evals := make([]func(*scope) any, len(args))
+	for i, arg := range args {
+		if arg.typ != f.params[i] {
+			return nil, errorAt(p.source, arg.pos, "function %s expects %s as argument %d, got %s", name.text,
+				f.params[i], i+1, arg.typ)
+		}
+		evals[i] = arg.eval
+	}
+	return &compiled{typ: f.result, pos: name.pos, eval: f.compile(evals)}, nil
+}
+
+// function is a built-in function. Its arguments are type checked before it is compiled.
+type function struct {
+	params []Type
+	// required is the number of required arguments; the others are optional.
+	required int
+	result   Type
+	// aggregate functions take the items as their first argument, and evaluate the others for each item.
+	aggregate bool
+	compile   func(args []func(*scope) any) func(*scope) any
+}
+
+var functions = map[string]function{
+	"len": {params: []Type{String}, required: 1, result: Number, compile: func(args []func(*scope) any) func(*scope) any {
+		return func(s *scope) any { return big.NewRat(int64(utf8.RuneCountInString(args[0](s).(string))), 1) }
+	}},
+	"lower": {params: []Type{String}, required: 1, result: String, compile: func(args []func(*scope) any) func(*scope) any {
+		return func(s *scope) any { return strings.ToLower(args[0](s).(string)) }
+	}},
+	"trim": {params: []Type{String}, required: 1, result: String, compile: func(args []func(*scope) any) func(*scope) any {
+		return func(s *scope) any { return strings.TrimSpace(args[0](s).(string)) }
+	}},
+	"year":    dateFunction(func(date time.Time) int { return date.Year() }),
+	"month":   dateFunction(func(date time.Time) int { return int(date.Month()) }),
+	"day":     dateFunction(func(date time.Time) int { return date.Day() }),
+	"weekday": dateFunction(func(date time.Time) int { return (int(date.Weekday())+6)%7 + 1 }),
+	"hour":    timeFunction(func(minutes int64) int64 { return minutes / 60 }),
+	"minute":  timeFunction(func(minutes int64) int64 { return minutes % 60 }),
+	"count": {params: []Type{Items, Bool}, required: 1, result: Number, aggregate: true,
+		compile: func(args []func(*scope) any) func(*scope) any {
+			return func(s *scope) any {
+				count := int64(0)
+				forEachItem(s, args[0], func(item *scope) bool {
+					if len(args) == 1 || args[1](item).(bool) {
+						count++
+					}
+					return true
+				})
+				return big.NewRat(count, 1)
+			}
+		}},
+	"any": {params: []Type{Items, Bool}, required: 2, result: Bool, aggregate: true,
+		compile: func(args []func(*scope) any) func(*scope) any {
+			return func(s *scope) any {
+				found := false
+				forEachItem(s, args[0], func(item *scope) bool {
+					found = args[1](item).(bool)
+					return !found
+				})
+				return found
+			}
+		}},
+	"all": {params: []Type{Items, Bool}, required: 2, result: Bool, aggregate: true,
+		compile: func(args []func(*scope) any) func(*scope) any {
+			return func(s *scope) any {
+				all := true
+				forEachItem(s, args[0], func(item *scope) bool {
+					all = args[1](item).(bool)
+					return all
+				})
+				return all
+			}
+		}},
+	"sum": numberAggregate(func(result, value *big.Rat) *big.Rat { return result.Add(result, value) }),
+	"min": numberAggregate(func(result, value *big.Rat) *big.Rat {
+		if value.Cmp(result) < 0 {
+			return value
+		}
+		return result
+	}),
+	"max": numberAggregate(func(result, value *big.Rat) *big.Rat {
+		if value.Cmp(result) > 0 {
+			return value
+		}
+		return result
+	}),
+}
+
+func dateFunction(part func(time.Time) int) function {
+	return function{params: []Type{Date}, required: 1, result: Number,
+		compile: func(args []func(*scope) any) func(*scope) any {
+			return func(s *scope) any { return big.NewRat(int64(part(args[0](s).(time.Time))), 1) }
+		}}
+}
+
+func timeFunction(part func(int64) int64) function {
+	return function{params: []Type{Time}, required: 1, result: Number,
+		compile: func(args []func(*scope) any) func(*scope) any {
+			return func(s *scope) any { return big.NewRat(part(args[0](s).(int64)), 1) }
+		}}
+}
+
+// numberAggregate combines a number evaluated for each item, starting with the first one. Its result is 0 if there
+// are no items.
+func numberAggregate(combine func(result, value *big.Rat) *big.Rat) function {
+	return function{params: []Type{Items, Number}, required: 2, result: Number, aggregate: true,
+		compile: func(args []func(*scope) any) func(*scope) any {
+			return func(s *scope) any {
+				var result *big.Rat
+				forEachItem(s, args[0], func(item *scope) bool {
+					value := new(big.Rat).Set(args[1](item).(*big.Rat))
+					if result == nil {
+						result = value
+					} else {
+						result = combine(result, value)
+					}
+					return true
+				})
+				if result == nil {
+					return new(big.Rat)
+				}
+				return result
+			}
+		}}
+}
+
+// forEachItem calls f with a scope for every item until it returns false.
+func forEachItem(s *scope, items func(*scope) any, f func(*scope) bool) {
+	list := items(s).([]model.Item)
+	for i := range list {
+		if !f(&scope{receipt: s.receipt, item: &list[i]}) {
+			return
+		}
+	}
+}
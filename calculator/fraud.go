@@ -0,0 +1,224 @@
+package calculator
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"fetch-assessment/money"
+	"fetch-assessment/utils"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FraudRuleConfig defines a fraud rule of one of the fraud rule types. A receipt the rule finds suspicious loses the
+// Points, or all of its points with Forfeit, and is marked for manual review unless Review is false.
+type FraudRuleConfig struct {
+	ID          string `yaml:"id"`
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	Points      int    `yaml:"points"`
+	Forfeit     bool   `yaml:"forfeit"`
+	// Review defaults to true.
+	Review *bool          `yaml:"review"`
+	Params map[string]any `yaml:"params"`
+}
+
+// FraudRule detects suspicious receipts. It is applied after the rules and campaigns, once their points are limited to
+// the minimum and maximum of the rule set, and subtracts points from the receipts it finds suspicious, or forfeits all
+// of their points, and marks them for manual review.
+type FraudRule struct {
+	// ID identifies the rule in the points breakdown. It must not change.
+	ID          string
+	Description string
+	points      int
+	forfeit     bool
+	review      bool
+	// suspect reports whether a receipt first submitted at receivedAt is suspicious, and the reason.
+	suspect func(receipt model.Receipt, receivedAt time.Time) (bool, string)
+}
+
+// fraudRuleType creates the function detecting suspicious receipts of a fraud rule from its parameters.
+type fraudRuleType func(p *params) (func(model.Receipt, time.Time) (bool, string), error)
+
+// fraudRuleTypes are the types available to fraud rules in a rule set definition, by name.
+var fraudRuleTypes = map[string]fraudRuleType{
+	"total-mismatch":   totalMismatchRule,
+	"item-price-range": itemPriceRangeRule,
+	"future-purchase":  futurePurchaseRule,
+	"identical-items":  identicalItemsRule,
+}
+
+func newFraudRule(config FraudRuleConfig) (*FraudRule, error) {
+	if config.ID == "" {
+		return nil, errors.New("id is required")
+	}
+	fraudRuleType, ok := fraudRuleTypes[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown fraud rule type %q", config.Type)
+	}
+	review := config.Review == nil || *config.Review
+	switch {
+	case config.Points < 0:
+		return nil, fmt.Errorf("points are subtracted and must not be negative, got %d", config.Points)
+	case config.Points > 0 && config.Forfeit:
+		return nil, errors.New("either points or forfeit must be set, not both")
+	case config.Points == 0 && !config.Forfeit && !review:
+		return nil, errors.New("points, forfeit or review is required")
+	}
+
+	p := &params{values: config.Params, read: make(map[string]bool)}
+	suspect, err := fraudRuleType(p)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.unknown(); err != nil {
+		return nil, err
+	}
+	return &FraudRule{ID: config.ID, Description: config.Description, points: config.Points, forfeit: config.Forfeit,
+		review: review, suspect: suspect}, nil
+}
+
+// Screening is the result of applying the fraud rules of a rule set to a receipt.
+type Screening struct {
+	// Points are the points of the receipt after the fraud rules are applied.
+	Points int
+	// Rules holds the points subtracted by each fraud rule, in the order the rules are applied.
+	Rules []RuleResult
+	// Forfeited is set if a fraud rule forfeited all points of the receipt.
+	Forfeited bool
+	// Review is set if a fraud rule marked the receipt for manual review.
+	Review bool
+}
+
+// Screen applies the fraud rules of the rule set to a receipt first submitted at receivedAt, given the points awarded
+// to it so far. The points of the receipt never drop below zero.
+func (r *Registry) Screen(receipt model.Receipt, receivedAt time.Time, points int) Screening {
+	screening := Screening{Points: points, Rules: make([]RuleResult, 0, len(r.fraudRules))}
+	for _, rule := range r.fraudRules {
+		suspicious, reason := rule.suspect(receipt, receivedAt)
+		result := RuleResult{Rule: rule.ID, Reason: reason, Suspicious: suspicious}
+		if suspicious {
+			switch {
+			case rule.forfeit:
+				result.Points = -screening.Points
+				result.Reason = fmt.Sprintf("%s, forfeiting all %d points", reason, screening.Points)
+				screening.Forfeited = true
+			case rule.points > 0:
+				result.Points = -min(rule.points, max(screening.Points, 0))
+				result.Reason = fmt.Sprintf("%s, subtracting %d points", reason, -result.Points)
+			}
+			screening.Review = screening.Review || rule.review
+		}
+		screening.Points += result.Points
+		screening.Rules = append(screening.Rules, result)
+	}
+	return screening
+}
+
+// totalMismatchRule finds a receipt suspicious if its total differs from the sum of the item prices by more than the
+// optional tolerance parameter.
+func totalMismatchRule(p *params) (func(model.Receipt, time.Time) (bool, string), error) {
+	var tolerance money.Amount
+	if p.has("tolerance") {
+		var err error
+		if tolerance, err = p.amount("tolerance"); err != nil {
+			return nil, err
+		}
+	}
+	return func(receipt model.Receipt, _ time.Time) (bool, string) {
+		// parsing errors can be ignored due to preceding validation rules
+		total, _ := utils.ParseTotal(receipt)
+		var sum money.Amount
+		for _, item := range receipt.Items {
+			price, _ := money.Parse(item.Price)
+			sum += price
+		}
+		difference := total - sum
+		if sum > total {
+			difference = sum - total
+		}
+		if difference > tolerance {
+			return true, fmt.Sprintf("total %s does not match the item prices adding up to %s", receipt.Total, sum)
+		}
+		return false, fmt.Sprintf("total %s matches the item prices adding up to %s", receipt.Total, sum)
+	}, nil
+}
+
+// itemPriceRangeRule finds a receipt suspicious if an item is priced above the maxPrice parameter or below the
+// optional minPrice parameter.
+func itemPriceRangeRule(p *params) (func(model.Receipt, time.Time) (bool, string), error) {
+	maxPrice, err := p.amount("maxPrice")
+	if err != nil {
+		return nil, err
+	}
+	var minPrice money.Amount
+	if p.has("minPrice") {
+		if minPrice, err = p.amount("minPrice"); err != nil {
+			return nil, err
+		}
+		if minPrice > maxPrice {
+			return nil, fmt.Errorf("parameter minPrice %s must not exceed maxPrice %s", minPrice, maxPrice)
+		}
+	}
+	return func(receipt model.Receipt, _ time.Time) (bool, string) {
+		for _, item := range receipt.Items {
+			// parsing errors can be ignored due to preceding validation rules
+			price, _ := money.Parse(item.Price)
+			if price > maxPrice {
+				return true, fmt.Sprintf("item %q priced %s is above the maximum of %s",
+					strings.TrimSpace(item.ShortDescription), item.Price, maxPrice)
+			}
+			if price < minPrice {
+				return true, fmt.Sprintf("item %q priced %s is below the minimum of %s",
+					strings.TrimSpace(item.ShortDescription), item.Price, minPrice)
+			}
+		}
+		if minPrice > 0 {
+			return false, fmt.Sprintf("all items are priced between %s and %s", minPrice, maxPrice)
+		}
+		return false, fmt.Sprintf("all items are priced at most %s", maxPrice)
+	}, nil
+}
+
+// earliestZone is the time zone furthest ahead of UTC. A receipt without a time zone is dated in the future only if
+// its purchase is in the future even in this zone.
+var earliestZone = time.FixedZone("+14:00", 14*60*60)
+
+// futurePurchaseRule finds a receipt suspicious if it was purchased after it was first submitted.
+func futurePurchaseRule(_ *params) (func(model.Receipt, time.Time) (bool, string), error) {
+	return func(receipt model.Receipt, receivedAt time.Time) (bool, string) {
+		// parsing errors can be ignored due to preceding validation rules
+		purchasedAt, _ := utils.PurchaseInstant(receipt, earliestZone)
+		if purchasedAt.After(receivedAt) {
+			return true, fmt.Sprintf("purchase at %s is after the submission at %s",
+				purchasedAt.UTC().Format(time.RFC3339), receivedAt.UTC().Format(time.RFC3339))
+		}
+		return false, "purchase is not after the submission"
+	}, nil
+}
+
+// identicalItemsRule finds a receipt suspicious if it holds the number of identical items given by the count
+// parameter, or more. Items are identical if they have the same price and description, ignoring case and surrounding
+// whitespace.
+func identicalItemsRule(p *params) (func(model.Receipt, time.Time) (bool, string), error) {
+	count, err := p.integer("count")
+	if err != nil {
+		return nil, err
+	}
+	if count < 2 {
+		return nil, fmt.Errorf("parameter count must be at least 2, got %d", count)
+	}
+	return func(receipt model.Receipt, _ time.Time) (bool, string) {
+		type key struct{ description, price string }
+		counts := make(map[key]int)
+		for _, item := range receipt.Items {
+			k := key{strings.ToLower(strings.TrimSpace(item.ShortDescription)), item.Price}
+			counts[k]++
+			if counts[k] == count {
+				return true, fmt.Sprintf("item %q priced %s occurs %d times or more",
+					strings.TrimSpace(item.ShortDescription), item.Price, count)
+			}
+		}
+		return false, fmt.Sprintf("no item occurs %d times or more", count)
+	}, nil
+}
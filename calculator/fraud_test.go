@@ -0,0 +1,290 @@
+package calculator
+
+import (
+	"fetch-assessment/model"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFraudRules(t *testing.T) {
+	receivedAt := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	utc := "UTC"
+	tests := []struct {
+		name           string
+		fraudRule      string
+		receipt        model.Receipt
+		wantSuspicious bool
+		wantReason     string
+	}{
+		{
+			name:      "total matching the items",
+			fraudRule: `{id: f, type: total-mismatch, points: 5}`,
+			receipt: model.Receipt{Total: "3.50",
+				Items: []model.Item{{ShortDescription: "a", Price: "1.25"}, {ShortDescription: "b", Price: "2.25"}}},
+			wantReason: "total 3.50 matches the item prices adding up to 3.50",
+		},
+		{
+			name:           "total not matching the items",
+			fraudRule:      `{id: f, type: total-mismatch, points: 5}`,
+			receipt:        model.Receipt{Total: "3.51", Items: []model.Item{{ShortDescription: "a", Price: "3.50"}}},
+			wantSuspicious: true,
+			wantReason:     "total 3.51 does not match the item prices adding up to 3.50",
+		},
+		{
+			name:       "total within the tolerance",
+			fraudRule:  `{id: f, type: total-mismatch, points: 5, params: {tolerance: 0.05}}`,
+			receipt:    model.Receipt{Total: "3.45", Items: []model.Item{{ShortDescription: "a", Price: "3.50"}}},
+			wantReason: "total 3.45 matches the item prices adding up to 3.50",
+		},
+		{
+			name:           "item above the maximum price",
+			fraudRule:      `{id: f, type: item-price-range, points: 5, params: {maxPrice: 500}}`,
+			receipt:        model.Receipt{Items: []model.Item{{ShortDescription: " Gum ", Price: "999.99"}}},
+			wantSuspicious: true,
+			wantReason:     `item "Gum" priced 999.99 is above the maximum of 500.00`,
+		},
+		{
+			name:           "item below the minimum price",
+			fraudRule:      `{id: f, type: item-price-range, points: 5, params: {minPrice: 0.01, maxPrice: 500}}`,
+			receipt:        model.Receipt{Items: []model.Item{{ShortDescription: "TV", Price: "0.00"}}},
+			wantSuspicious: true,
+			wantReason:     `item "TV" priced 0.00 is below the minimum of 0.01`,
+		},
+		{
+			name:       "item prices in range",
+			fraudRule:  `{id: f, type: item-price-range, points: 5, params: {minPrice: 0.01, maxPrice: 500}}`,
+			receipt:    model.Receipt{Items: []model.Item{{ShortDescription: "TV", Price: "500.00"}}},
+			wantReason: "all items are priced between 0.01 and 500.00",
+		},
+		{
+			name:      "purchase after the submission",
+			fraudRule: `{id: f, type: future-purchase, points: 5}`,
+			receipt: model.Receipt{PurchaseDate: mustParseDate("2025-03-10"), PurchaseTime: "12:01",
+				TimeZone: &utc},
+			wantSuspicious: true,
+			wantReason:     "purchase at 2025-03-10T12:01:00Z is after the submission at 2025-03-10T12:00:00Z",
+		},
+		{
+			name:       "purchase without time zone possibly before the submission",
+			fraudRule:  `{id: f, type: future-purchase, points: 5}`,
+			receipt:    model.Receipt{PurchaseDate: mustParseDate("2025-03-11"), PurchaseTime: "01:59"},
+			wantReason: "purchase is not after the submission",
+		},
+		{
+			name:      "identical items",
+			fraudRule: `{id: f, type: identical-items, points: 5, params: {count: 3}}`,
+			receipt: model.Receipt{Items: []model.Item{{ShortDescription: "Gum", Price: "1.00"},
+				{ShortDescription: "gum ", Price: "1.00"}, {ShortDescription: "Gum", Price: "1.25"},
+				{ShortDescription: "GUM", Price: "1.00"}}},
+			wantSuspicious: true,
+			wantReason:     `item "GUM" priced 1.00 occurs 3 times or more`,
+		},
+		{
+			name:      "items of different prices",
+			fraudRule: `{id: f, type: identical-items, points: 5, params: {count: 3}}`,
+			receipt: model.Receipt{Items: []model.Item{{ShortDescription: "Gum", Price: "1.00"},
+				{ShortDescription: "Gum", Price: "1.25"}, {ShortDescription: "Gum", Price: "1.00"}}},
+			wantReason: "no item occurs 3 times or more",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, err := parseRuleSet([]byte(`{version: "1", rules: [{id: a, type: retailer-name-length}],
+				fraudRules: [` + tt.fraudRule + `]}`))
+			require.NoError(t, err)
+			screening := registry.Screen(tt.receipt, receivedAt, 20)
+			require.Len(t, screening.Rules, 1)
+			assert.Equal(t, tt.wantSuspicious, screening.Rules[0].Suspicious)
+			assert.Equal(t, tt.wantSuspicious, screening.Review)
+			if tt.wantSuspicious {
+				assert.Equal(t, tt.wantReason+", subtracting 5 points", screening.Rules[0].Reason)
+				assert.Equal(t, 15, screening.Points)
+			} else {
+				assert.Equal(t, tt.wantReason, screening.Rules[0].Reason)
+				assert.Equal(t, 20, screening.Points)
+			}
+		})
+	}
+}
+
+func TestScoreWithFraudRules(t *testing.T) {
+	ruleSets, err := ParseRuleSets([]byte(`
+version: "1"
+minPoints: 5
+rules: [{id: name, type: retailer-name-length, points: 1}]
+fraudRules:
+  - id: big-item
+    type: item-price-range
+    points: 20
+    review: false
+    params: {maxPrice: 100}
+  - id: mismatch
+    type: total-mismatch
+    forfeit: true
+  - id: duplicates
+    type: identical-items
+    params: {count: 2}
+campaigns:
+  - {id: double, retailer: {equals: Target}, from: 2025-01-01, to: 2025-12-31, multiplier: 2}
+`))
+	require.NoError(t, err)
+	receipt := func(total string, prices ...string) model.Receipt {
+		items := make([]model.Item, 0, len(prices))
+		for _, price := range prices {
+			items = append(items, model.Item{ShortDescription: "Gum", Price: price})
+		}
+		return model.Receipt{Retailer: "Target", PurchaseDate: mustParseDate("2025-03-01"), PurchaseTime: "10:00",
+			Total: total, Items: items}
+	}
+	scored := func(receipt model.Receipt) Score {
+		return ruleSets.Score(receipt, time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC))
+	}
+
+	score := scored(receipt("1.00", "1.00"))
+	assert.Equal(t, 12, score.Points)
+	assert.False(t, score.Review)
+
+	// the points are subtracted from those of the rules and campaigns, without marking the receipt for review, and are
+	// not restored by the minimum
+	score = scored(receipt("150.00", "150.00"))
+	assert.Equal(t, []RuleResult{
+		{Rule: "name", Points: 6, Reason: "retailer name has 6 alphanumeric characters"},
+		{Rule: "double", Points: 6, Reason: "campaign multiplies the 6 points of the rules by 2", Campaign: true},
+		{Rule: "big-item", Points: -12, Suspicious: true,
+			Reason: `item "Gum" priced 150.00 is above the maximum of 100.00, subtracting 12 points`},
+		{Rule: "mismatch", Reason: "total 150.00 matches the item prices adding up to 150.00"},
+		{Rule: "duplicates", Reason: "no item occurs 2 times or more"},
+	}, score.Rules)
+	assert.Equal(t, 0, score.Points)
+	assert.False(t, score.Review)
+
+	// forfeited points are not raised to the minimum either
+	score = scored(receipt("2.00", "1.00"))
+	assert.Equal(t, 0, score.Points)
+	assert.True(t, score.Review)
+	assert.Equal(t, RuleResult{Rule: "mismatch", Points: -12, Suspicious: true,
+		Reason: "total 2.00 does not match the item prices adding up to 1.00, forfeiting all 12 points"}, score.Rules[3])
+
+	// a fraud rule without points only marks the receipt for review
+	score = scored(receipt("2.00", "1.00", "1.00"))
+	assert.Equal(t, 12, score.Points)
+	assert.True(t, score.Review)
+}
+
+func TestScoreLimitsPointsBeforeFraudRules(t *testing.T) {
+	receipt := model.Receipt{Retailer: "Walgreens", PurchaseDate: mustParseDate("2025-03-01"), PurchaseTime: "10:00",
+		Total: "2.00", Items: []model.Item{{ShortDescription: "Gum", Price: "1.00"}, {ShortDescription: "Gum",
+			Price: "1.00"}}}
+	tests := []struct {
+		name       string
+		limits     string
+		points     int
+		wantLimit  RuleResult
+		wantPoints int
+	}{
+		{
+			name:   "capped suspicious receipt",
+			limits: "maxPoints: 5",
+			points: 3,
+			wantLimit: RuleResult{Rule: MaxPointsResult, Points: -4, Capped: true,
+				Reason: "receipt points capped from 9 to 5"},
+			wantPoints: 2,
+		},
+		{
+			name:   "floored suspicious receipt",
+			limits: "minPoints: 20",
+			points: 15,
+			wantLimit: RuleResult{Rule: MinPointsResult, Points: 11,
+				Reason: "receipt points raised from 9 to the minimum of 20"},
+			wantPoints: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ruleSets, err := ParseRuleSets([]byte(fmt.Sprintf(`
+version: "1"
+%s
+rules: [{id: name, type: retailer-name-length, points: 1}]
+fraudRules: [{id: duplicates, type: identical-items, points: %d, params: {count: 2}}]
+`, tt.limits, tt.points)))
+			require.NoError(t, err)
+			score := ruleSets.Score(receipt, time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC))
+			require.Len(t, score.Rules, 3)
+			assert.Equal(t, tt.wantLimit, score.Rules[1])
+			assert.Equal(t, "duplicates", score.Rules[2].Rule)
+			assert.Equal(t, -tt.points, score.Rules[2].Points)
+			assert.Equal(t, tt.wantPoints, score.Points)
+			assert.True(t, score.Review)
+		})
+	}
+}
+
+func TestParseRuleSetsRejectsInvalidFraudRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		fraudRule string
+		wantErr   string
+	}{
+		{
+			name:      "missing id",
+			fraudRule: `{type: total-mismatch, points: 5}`,
+			wantErr:   "fraud rule 1 (): id is required",
+		},
+		{
+			name:      "id of a rule",
+			fraudRule: `{id: a, type: total-mismatch, points: 5}`,
+			wantErr:   "fraud rule 1 (a): id is already defined",
+		},
+		{
+			name:      "unknown type",
+			fraudRule: `{id: f, type: retailer-name-length, points: 5}`,
+			wantErr:   `fraud rule 1 (f): unknown fraud rule type "retailer-name-length"`,
+		},
+		{
+			name:      "negative points",
+			fraudRule: `{id: f, type: total-mismatch, points: -5}`,
+			wantErr:   "points are subtracted and must not be negative, got -5",
+		},
+		{
+			name:      "points and forfeit",
+			fraudRule: `{id: f, type: total-mismatch, points: 5, forfeit: true}`,
+			wantErr:   "either points or forfeit must be set, not both",
+		},
+		{
+			name:      "no effect",
+			fraudRule: `{id: f, type: total-mismatch, review: false}`,
+			wantErr:   "points, forfeit or review is required",
+		},
+		{
+			name:      "missing maxPrice",
+			fraudRule: `{id: f, type: item-price-range, points: 5}`,
+			wantErr:   "parameter maxPrice is required",
+		},
+		{
+			name:      "minPrice above maxPrice",
+			fraudRule: `{id: f, type: item-price-range, points: 5, params: {minPrice: 10, maxPrice: 5}}`,
+			wantErr:   "parameter minPrice 10.00 must not exceed maxPrice 5.00",
+		},
+		{
+			name:      "count of one",
+			fraudRule: `{id: f, type: identical-items, points: 5, params: {count: 1}}`,
+			wantErr:   "parameter count must be at least 2, got 1",
+		},
+		{
+			name:      "unknown parameter",
+			fraudRule: `{id: f, type: future-purchase, points: 5, params: {tolerance: 5}}`,
+			wantErr:   "unknown parameter tolerance",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRuleSets([]byte(`{version: "1", rules: [{id: a, type: retailer-name-length}],
+				fraudRules: [` + tt.fraudRule + `]}`))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package calculator
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrUnknownRule = errors.New("unknown rule")
+
+// Rule is a scoring rule applied to a Receipt.
+type Rule struct {
+	// ID identifies the rule, for example in the points breakdown. It must not change.
+	ID          string
+	Description string
+	// Version must be incremented whenever the points awarded by the rule change.
+	Version int
+	// MaxPoints caps the points awarded by the rule to a receipt. The points are not capped if it is zero.
+	MaxPoints int
+	// Evaluate returns the points awarded to the receipt and the reason for them.
+	Evaluate func(model.Receipt) (int, string)
+}
+
+// Apply evaluates the rule for the receipt and caps the points at MaxPoints.
+func (r Rule) Apply(receipt model.Receipt) RuleResult {
+	points, reason := r.Evaluate(receipt)
+	if r.MaxPoints > 0 && points > r.MaxPoints {
+		return RuleResult{Rule: r.ID, Points: r.MaxPoints, Capped: true,
+			Reason: fmt.Sprintf("%s, capped from %d to %d points", reason, points, r.MaxPoints)}
+	}
+	return RuleResult{Rule: r.ID, Points: points, Reason: reason}
+}
+
+// RuleResult is the contribution of a single rule to the points of a receipt.
+type RuleResult struct {
+	// Rule is the ID of the rule.
+	Rule   string
+	Points int
+	// Reason explains in plain words why the rule awarded the points, or none.
+	Reason string
+	// Campaign is set if the points were awarded by the campaign with the ID Rule rather than a rule.
+	Campaign bool
+	// Capped is set if a maximum clipped the points.
+	Capped bool
+	// Suspicious is set if the fraud rule with the ID Rule found the receipt suspicious.
+	Suspicious bool
+}
+
+const (
+	// MaxPointsResult is the Rule of the result reducing the points of a receipt to the maximum of the rule set.
+	MaxPointsResult = "maxPoints"
+	// MinPointsResult is the Rule of the result raising the points of a receipt to the minimum of the rule set.
+	MinPointsResult = "minPoints"
+)
+
+// Registry holds the rules applied to receipts, in the order they were registered. Every rule can be enabled and
+// disabled; disabled rules are skipped. It is safe for concurrent use.
+type Registry struct {
+	mu            sync.RWMutex
+	version       string
+	effectiveFrom time.Time
+	// config is the definition the registry was created from, if any.
+	config RuleSetConfig
+	// maxPoints and minPoints limit the points of a receipt. There is no maximum if maxPoints is zero.
+	maxPoints  int
+	minPoints  int
+	rules      []registeredRule
+	fraudRules []*FraudRule
+}
+
+type registeredRule struct {
+	rule    Rule
+	enabled bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Version identifies the rule set held by the registry. It is empty unless the registry was created from a rule set
+// definition.
+func (r *Registry) Version() string {
+	return r.version
+}
+
+// EffectiveFrom is the time from which the rule set held by the registry is in force. It is zero if the rule set is in
+// force before all others.
+func (r *Registry) EffectiveFrom() time.Time {
+	return r.effectiveFrom
+}
+
+// Register appends an enabled rule. It fails if the rule is incomplete or its ID is already registered.
+func (r *Registry) Register(rule Rule) error {
+	if rule.ID == "" || rule.Evaluate == nil {
+		return fmt.Errorf("rule %q must have an ID and an evaluate function", rule.ID)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, registered := range r.rules {
+		if registered.rule.ID == rule.ID {
+			return fmt.Errorf("rule %q is already registered", rule.ID)
+		}
+	}
+	r.rules = append(r.rules, registeredRule{rule: rule, enabled: true})
+	return nil
+}
+
+// Enable enables the rule with the given ID, or returns ErrUnknownRule.
+func (r *Registry) Enable(id string) error {
+	return r.setEnabled(id, true)
+}
+
+// Disable disables the rule with the given ID, or returns ErrUnknownRule.
+func (r *Registry) Disable(id string) error {
+	return r.setEnabled(id, false)
+}
+
+func (r *Registry) setEnabled(id string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.rules {
+		if r.rules[i].rule.ID == id {
+			r.rules[i].enabled = enabled
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownRule, id)
+}
+
+// Rules returns the enabled rules in the order they are applied.
+func (r *Registry) Rules() []Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rules := make([]Rule, 0, len(r.rules))
+	for _, registered := range r.rules {
+		if registered.enabled {
+			rules = append(rules, registered.rule)
+		}
+	}
+	return rules
+}
+
+// FraudRules returns the fraud rules in the order they are applied.
+func (r *Registry) FraudRules() []*FraudRule {
+	return append([]*FraudRule(nil), r.fraudRules...)
+}
+
+// Explain applies the enabled rules to the receipt and returns the total points along with the points awarded by
+// each rule and the reason, in the order the rules are applied.
+func (r *Registry) Explain(receipt model.Receipt) (int, []RuleResult) {
+	return r.explain(receipt, true)
+}
+
+// explain is Explain, logging every rule only if log is set.
+func (r *Registry) explain(receipt model.Receipt, log bool) (int, []RuleResult) {
+	rules := r.Rules()
+	points := 0
+	results := make([]RuleResult, 0, len(rules))
+	for _, rule := range rules {
+		result := rule.Apply(receipt)
+		if log {
+			// to be converted into debug log for production system
+			fmt.Printf("%s points added by rule %s\n", strconv.Itoa(result.Points), rule.ID)
+		}
+		points += result.Points
+		results = append(results, result)
+	}
+	return points, results
+}
+
+// Limit returns the result that brings the points of a receipt within the minimum and maximum of the rule set, or
+// false if they already are.
+func (r *Registry) Limit(points int) (RuleResult, bool) {
+	switch {
+	case r.maxPoints > 0 && points > r.maxPoints:
+		return RuleResult{Rule: MaxPointsResult, Points: r.maxPoints - points, Capped: true,
+			Reason: fmt.Sprintf("receipt points capped from %d to %d", points, r.maxPoints)}, true
+	case points < r.minPoints:
+		return RuleResult{Rule: MinPointsResult, Points: r.minPoints - points,
+			Reason: fmt.Sprintf("receipt points raised from %d to the minimum of %d", points, r.minPoints)}, true
+	}
+	return RuleResult{}, false
+}
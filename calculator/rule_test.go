@@ -0,0 +1,74 @@
+package calculator
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	fixed := func(id string, points int) Rule {
+		return Rule{ID: id, Version: 1, Evaluate: func(model.Receipt) (int, string) {
+			return points, id + " applied"
+		}}
+	}
+
+	t.Run("applies rules in registration order", func(t *testing.T) {
+		registry := NewRegistry()
+		require.NoError(t, registry.Register(fixed("first", 1)))
+		require.NoError(t, registry.Register(fixed("second", 2)))
+
+		points, results := registry.Explain(model.Receipt{})
+		assert.Equal(t, 3, points)
+		assert.Equal(t, []RuleResult{
+			{Rule: "first", Points: 1, Reason: "first applied"},
+			{Rule: "second", Points: 2, Reason: "second applied"},
+		}, results)
+	})
+
+	t.Run("skips disabled rules", func(t *testing.T) {
+		registry := NewRegistry()
+		require.NoError(t, registry.Register(fixed("first", 1)))
+		require.NoError(t, registry.Register(fixed("second", 2)))
+
+		require.NoError(t, registry.Disable("first"))
+		points, results := registry.Explain(model.Receipt{})
+		assert.Equal(t, 2, points)
+		assert.Len(t, results, 1)
+
+		require.NoError(t, registry.Enable("first"))
+		points, _ = registry.Explain(model.Receipt{})
+		assert.Equal(t, 3, points)
+		assert.Equal(t, "first", registry.Rules()[0].ID)
+	})
+
+	t.Run("rejects duplicate ids", func(t *testing.T) {
+		registry := NewRegistry()
+		require.NoError(t, registry.Register(fixed("first", 1)))
+		assert.Error(t, registry.Register(fixed("first", 2)))
+	})
+
+	t.Run("rejects incomplete rules", func(t *testing.T) {
+		registry := NewRegistry()
+		assert.Error(t, registry.Register(Rule{ID: "missing evaluate"}))
+		assert.Error(t, registry.Register(Rule{Evaluate: fixed("", 0).Evaluate}))
+	})
+
+	t.Run("unknown rule", func(t *testing.T) {
+		registry := NewRegistry()
+		assert.True(t, errors.Is(registry.Enable("unknown"), ErrUnknownRule))
+		assert.True(t, errors.Is(registry.Disable("unknown"), ErrUnknownRule))
+	})
+}
+
+func TestBuiltInRuleSet(t *testing.T) {
+	assert.Equal(t, "1", RuleSetVersion())
+	rules := ActiveRuleSets().Latest().Rules()
+	require.Len(t, rules, 7)
+	for _, rule := range rules {
+		assert.NotEmpty(t, rule.Description, rule.ID)
+		assert.Positive(t, rule.Version, rule.ID)
+	}
+}
@@ -0,0 +1,191 @@
+package calculator
+
+import (
+	"fetch-assessment/model"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// RuleSets holds all versions of the rule set, each in force from its effective date until the next one takes over.
+// Receipts are scored by the rule set in force at their purchase date or submission time, so that changing the rules
+// does not change the points of earlier receipts.
+type RuleSets struct {
+	scoreBy string
+	// registries are ordered by effective date.
+	registries  []*Registry
+	campaigns   []*Campaign
+	experiments []*Experiment
+}
+
+// ScoreBy returns ScoreByPurchaseDate or ScoreBySubmissionTime.
+func (s *RuleSets) ScoreBy() string {
+	return s.scoreBy
+}
+
+// Versions returns the rule sets ordered by effective date.
+func (s *RuleSets) Versions() []*Registry {
+	return append([]*Registry(nil), s.registries...)
+}
+
+// Version returns the rule set with the given version.
+func (s *RuleSets) Version(version string) (*Registry, bool) {
+	for _, registry := range s.registries {
+		if registry.version == version {
+			return registry, true
+		}
+	}
+	return nil, false
+}
+
+// Latest returns the rule set with the latest effective date.
+func (s *RuleSets) Latest() *Registry {
+	return s.registries[len(s.registries)-1]
+}
+
+// At returns the rule set in force at the given time. Times before the earliest effective date are covered by the
+// earliest rule set.
+func (s *RuleSets) At(t time.Time) *Registry {
+	selected := s.registries[0]
+	for _, registry := range s.registries[1:] {
+		if registry.effectiveFrom.After(t) {
+			break
+		}
+		selected = registry
+	}
+	return selected
+}
+
+// Campaigns returns the campaigns in the order they are applied.
+func (s *RuleSets) Campaigns() []*Campaign {
+	return append([]*Campaign(nil), s.campaigns...)
+}
+
+// Experiments returns the experiments in the order receipts are assigned to them.
+func (s *RuleSets) Experiments() []*Experiment {
+	return append([]*Experiment(nil), s.experiments...)
+}
+
+// For returns the rule set a receipt is scored by, depending on ScoreBy. receivedAt is the time the receipt was first
+// submitted.
+func (s *RuleSets) For(receipt model.Receipt, receivedAt time.Time) *Registry {
+	return s.At(s.scoredAt(receipt, receivedAt))
+}
+
+// scoredAt returns the time that selects the rule set and campaigns a receipt is scored by.
+func (s *RuleSets) scoredAt(receipt model.Receipt, receivedAt time.Time) time.Time {
+	if s.scoreBy == ScoreBySubmissionTime {
+		return receivedAt
+	}
+	return receipt.PurchaseDate.Time
+}
+
+// Score scores a receipt first submitted at receivedAt by the rule set in force for it, followed by the campaigns
+// running at the time. Every campaign is applied to the points of the rules alone, so that campaigns do not compound.
+// The total is then limited to the minimum and maximum points of the rule set, and finally the fraud rules of the rule
+// set subtract points from a suspicious receipt, so that neither limit undoes them. A receipt assigned a variant of an
+// experiment on the rule set is scored by the variant instead.
+func (s *RuleSets) Score(receipt model.Receipt, receivedAt time.Time) Score {
+	return s.score(s.For(receipt, receivedAt), receipt, receivedAt, true)
+}
+
+// ScoreWith scores a receipt like Score, but by the given rule set instead of the one in force for it, and without
+// logging every rule. It allows comparing the points of many receipts under different versions of the rule set.
+func (s *RuleSets) ScoreWith(registry *Registry, receipt model.Receipt, receivedAt time.Time) Score {
+	return s.score(registry, receipt, receivedAt, false)
+}
+
+func (s *RuleSets) score(registry *Registry, receipt model.Receipt, receivedAt time.Time, log bool) Score {
+	at := s.scoredAt(receipt, receivedAt)
+	var experiment *model.ExperimentAssignment
+	if variant, ok := s.variant(receipt, registry); ok {
+		registry = variant
+		experiment = receipt.Experiment
+	}
+	points, results := registry.explain(receipt, log)
+	rulePoints := points
+	for _, campaign := range s.campaigns {
+		if result, ok := campaign.apply(receipt, at, rulePoints); ok {
+			points += result.Points
+			results = append(results, result)
+		}
+	}
+	if result, ok := registry.Limit(points); ok {
+		points += result.Points
+		results = append(results, result)
+	}
+	screening := registry.Screen(receipt, receivedAt, points)
+	points = screening.Points
+	results = append(results, screening.Rules...)
+	return Score{RuleSetVersion: registry.Version(), Points: points, Rules: results, Review: screening.Review,
+		Experiment: experiment}
+}
+
+// retainedBy returns an error if next drops or changes a rule set that is already in force at now, adds a rule set
+// taking effect before now, changes how receipts are assigned to rule sets, or does any of these to a campaign or an
+// experiment, as all of these would change the points of receipts already scored.
+func (s *RuleSets) retainedBy(next *RuleSets, now time.Time) error {
+	if s.scoreBy != next.scoreBy {
+		return fmt.Errorf("scoreBy must not change from %s to %s", s.scoreBy, next.scoreBy)
+	}
+
+	kept := make(map[string]bool)
+	for _, registry := range s.registries {
+		if registry.effectiveFrom.After(now) {
+			continue
+		}
+		candidate, ok := next.Version(registry.version)
+		if !ok || !reflect.DeepEqual(candidate.config, registry.config) {
+			return fmt.Errorf("rule set %s is already in force and must be kept unchanged", registry.version)
+		}
+		kept[registry.version] = true
+	}
+	for _, registry := range next.registries {
+		if !kept[registry.version] && !registry.effectiveFrom.After(now) {
+			return fmt.Errorf("rule set %s must take effect in the future", registry.version)
+		}
+	}
+
+	campaigns := make(map[string]*Campaign)
+	for _, campaign := range next.campaigns {
+		campaigns[campaign.ID] = campaign
+	}
+	kept = make(map[string]bool)
+	for _, campaign := range s.campaigns {
+		if campaign.from.After(now) {
+			continue
+		}
+		candidate, ok := campaigns[campaign.ID]
+		if !ok || !reflect.DeepEqual(candidate.config, campaign.config) {
+			return fmt.Errorf("campaign %s has already started and must be kept unchanged", campaign.ID)
+		}
+		kept[campaign.ID] = true
+	}
+	for _, campaign := range next.campaigns {
+		if !kept[campaign.ID] && !campaign.from.After(now) {
+			return fmt.Errorf("campaign %s must start in the future", campaign.ID)
+		}
+	}
+
+	experiments := make(map[string]*Experiment)
+	for _, experiment := range next.experiments {
+		experiments[experiment.ID] = experiment
+	}
+	kept = make(map[string]bool)
+	for _, experiment := range s.experiments {
+		if experiment.from.After(now) {
+			continue
+		}
+		candidate, ok := experiments[experiment.ID]
+		if !ok || !reflect.DeepEqual(candidate.config, experiment.config) {
+			return fmt.Errorf("experiment %s has already started and must be kept unchanged", experiment.ID)
+		}
+		kept[experiment.ID] = true
+	}
+	for _, experiment := range next.experiments {
+		if !kept[experiment.ID] && !experiment.from.After(now) {
+			return fmt.Errorf("experiment %s must start in the future", experiment.ID)
+		}
+	}
+	return nil
+}
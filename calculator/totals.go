@@ -1,97 +1,288 @@
 package calculator
 
 import (
+	"errors"
+	"fetch-assessment/calculator/expr"
 	"fetch-assessment/model"
+	"fetch-assessment/money"
 	"fetch-assessment/utils"
 	"fmt"
-	"math"
-	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
-const wholeDollarRulePoints = 50
-const quarterDollarsRulePoints = 25
-const oddDayRulePoints = 6
-const afternoonTimeRulePoints = 10
+// Score is the result of scoring a receipt.
+type Score struct {
+	// RuleSetVersion identifies the rule set the receipt was scored by.
+	RuleSetVersion string
+	Points         int
+	// Rules holds the points awarded by each rule, in the order the rules are applied.
+	Rules []RuleResult
+	// Review is set if a fraud rule marked the receipt for manual review.
+	Review bool
+	// Experiment is the variant of an experiment the receipt was scored by, if any.
+	Experiment *model.ExperimentAssignment
+}
+
+// active holds the rule sets used for scoring. They are swapped atomically, so that every calculation uses a single
+// rule set throughout.
+var active atomic.Pointer[RuleSets]
+
+func init() {
+	active.Store(mustParseRuleSets(defaultRuleSet))
+}
+
+// ActiveRuleSets returns the rule sets used for scoring. Unless others were activated, they hold the built-in rule set.
+func ActiveRuleSets() *RuleSets {
+	return active.Load()
+}
+
+// Activate makes the rule sets the ones used for scoring. Calculations in progress complete with the previous ones.
+func Activate(ruleSets *RuleSets) {
+	active.Store(ruleSets)
+}
+
+// LoadRuleSetsOrDefault loads the rule set definitions from the file at path, or the built-in one if path is empty.
+func LoadRuleSetsOrDefault(path string) (*RuleSets, error) {
+	if path == "" {
+		return ParseRuleSets(defaultRuleSet)
+	}
+	return LoadRuleSets(path)
+}
+
+// ReloadRuleSets loads the rule set definitions like LoadRuleSetsOrDefault and activates them. The definitions are
+// rejected, keeping the active rule sets, if they are invalid or would change the points of receipts scored by a rule
+// set already in force.
+func ReloadRuleSets(path string) (*RuleSets, error) {
+	ruleSets, err := LoadRuleSetsOrDefault(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ActiveRuleSets().retainedBy(ruleSets, time.Now()); err != nil {
+		return nil, err
+	}
+	Activate(ruleSets)
+	return ruleSets, nil
+}
+
+// RuleSetVersion identifies the active rule set with the latest effective date.
+func RuleSetVersion() string {
+	return ActiveRuleSets().Latest().Version()
+}
 
-// rules is a slice of functions, each defining a scoring rule applied to a Receipt.
-var rules = []func(model.Receipt) int{
-	retailerNamePointsRule,
-	checkWholeDollarTotalRule,
-	checkQuarterDollarTotalRule,
-	itemPairPointsRule,
-	itemsDescriptionRule,
-	oddDayPointsRule,
-	afternoonTimePointsRule,
+// RuleSetFor returns the active rule set a receipt first submitted at receivedAt is scored by.
+func RuleSetFor(receipt model.Receipt, receivedAt time.Time) *Registry {
+	return ActiveRuleSets().For(receipt, receivedAt)
 }
 
-// CalculateTotals computes the total points for a given receipt by applying a list of scoring rules.
-// It iterates through predefined rules, calculates points for each rule, and returns the total points.
+// ScoreReceipt scores a receipt first submitted at receivedAt by the active rule set in force for it.
+func ScoreReceipt(receipt model.Receipt, receivedAt time.Time) Score {
+	return ActiveRuleSets().Score(receipt, receivedAt)
+}
+
+// AssignExperiment returns the variant of an active experiment a receipt submitted at submittedAt is assigned, or nil.
+// See RuleSets.Assign.
+func AssignExperiment(receipt model.Receipt, receiptKey, userID string, submittedAt time.Time) *model.ExperimentAssignment {
+	return ActiveRuleSets().Assign(receipt, receiptKey, userID, submittedAt)
+}
+
+// CalculateTotals computes the total points for a given receipt submitted now, by applying the enabled rules of the
+// rule set in force for it.
 // The function accepts a model.Receipt as input and returns an integer representing the calculated points.
 func CalculateTotals(receipt model.Receipt) int {
-	points := 0
-	for _, rule := range rules {
-		additionalPoint := rule(receipt)
-		// to be converted into debug log for production system
-		fmt.Printf("%s points added by rule %s\n", strconv.Itoa(additionalPoint), utils.GetFunctionName(rule))
-		points += additionalPoint
-	}
-	return points
+	return ScoreReceipt(receipt, time.Now()).Points
+}
+
+// Explain computes the total points for a receipt like CalculateTotals, and additionally returns the points awarded
+// by each rule along with the reason, in the order the rules are applied.
+func Explain(receipt model.Receipt) (int, []RuleResult) {
+	score := ScoreReceipt(receipt, time.Now())
+	return score.Points, score.Rules
 }
 
-func retailerNamePointsRule(receipt model.Receipt) int {
-	nameClean := utils.StripNonAlphanumeric(receipt.Retailer)
-	points := len(nameClean)
-	return points
+// ruleType creates the evaluate function of a rule from its configuration.
+type ruleType func(config RuleConfig, p *params) (func(model.Receipt) (int, string), error)
+
+// ruleTypes are the types available to rules in a rule set definition, by name.
+var ruleTypes = map[string]ruleType{
+	"retailer-name-length":    retailerNameLengthRule,
+	"total-multiple":          totalMultipleRule,
+	"item-groups":             itemGroupsRule,
+	"item-description-length": itemDescriptionLengthRule,
+	"item-category":           itemCategoryRule,
+	"purchase-day-parity":     purchaseDayParityRule,
+	"purchase-time-window":    purchaseTimeWindowRule,
+	"calendar":                calendarRule,
+	"expression":              expressionRule,
 }
 
-func checkWholeDollarTotalRule(receipt model.Receipt) int {
-	totalString := receipt.Total
-	if strings.HasSuffix(totalString, ".00") {
-		return wholeDollarRulePoints
+// retailerNameLengthRule awards the points for every alphanumeric character in the retailer name.
+func retailerNameLengthRule(config RuleConfig, _ *params) (func(model.Receipt) (int, string), error) {
+	return func(receipt model.Receipt) (int, string) {
+		characters := len(utils.StripNonAlphanumeric(receipt.Retailer))
+		return characters * config.Points, fmt.Sprintf("retailer name has %d alphanumeric characters", characters)
+	}, nil
+}
+
+// totalMultipleRule awards the points if the total is a multiple of the multiple parameter.
+func totalMultipleRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	multiple, err := p.amount("multiple")
+	if err != nil {
+		return nil, err
 	}
-	return 0
+	return func(receipt model.Receipt) (int, string) {
+		// parsing errors can be ignored due to preceding validation rules
+		total, _ := utils.ParseTotal(receipt)
+		if total.IsMultipleOf(multiple) {
+			return config.Points, fmt.Sprintf("total %s is a multiple of %s", receipt.Total, multiple)
+		}
+		return 0, fmt.Sprintf("total %s is not a multiple of %s", receipt.Total, multiple)
+	}, nil
 }
 
-func checkQuarterDollarTotalRule(receipt model.Receipt) int {
-	// parsing errors can be ignored due to preceding validation rules
-	total, _ := utils.ParseTotal(receipt)
-	if math.Mod(total, 0.25) == 0 {
-		return quarterDollarsRulePoints
+// itemGroupsRule awards the points for every complete group of items of the size parameter.
+func itemGroupsRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	size, err := p.integer("size")
+	if err != nil {
+		return nil, err
 	}
-	return 0
+	return func(receipt model.Receipt) (int, string) {
+		groups := len(receipt.Items) / size
+		return groups * config.Points, fmt.Sprintf("%d items make %d groups of %d", len(receipt.Items), groups, size)
+	}, nil
 }
 
-func itemPairPointsRule(receipt model.Receipt) int {
-	return (len(receipt.Items) / 2) * 5
+// itemDescriptionLengthRule awards, for every item whose trimmed description length is a multiple of the multiple
+// parameter, the item price multiplied by the priceMultiplier parameter and rounded up. It does not use the points.
+func itemDescriptionLengthRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	if config.Points != 0 {
+		return nil, errors.New("points are derived from the item prices and must not be set")
+	}
+	multiple, err := p.integer("multiple")
+	if err != nil {
+		return nil, err
+	}
+	priceMultiplier, err := p.factor("priceMultiplier")
+	if err != nil {
+		return nil, err
+	}
+	return func(receipt model.Receipt) (int, string) {
+		points := 0
+		matching := 0
+		for _, item := range receipt.Items {
+			if len(strings.TrimSpace(item.ShortDescription))%multiple == 0 {
+				// parsing errors can be ignored due to preceding validation rules
+				price, _ := money.Parse(item.Price)
+				points += int(price.MulCeil(priceMultiplier))
+				matching++
+			}
+		}
+		return points, fmt.Sprintf("%d items have a description length that is a multiple of %d", matching, multiple)
+	}, nil
 }
 
-func itemsDescriptionRule(receipt model.Receipt) int {
-	points := 0
-	for _, item := range receipt.Items {
-		if len(strings.TrimSpace(item.ShortDescription))%3 == 0 {
-			price, _ := strconv.ParseFloat(item.Price, 64)
-			points += int(math.Ceil(price * 0.2))
+// itemCategoryRule awards the points for every item in the category parameter. Items are assigned their category when
+// the receipt is stored.
+func itemCategoryRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	category, err := p.text("category")
+	if err != nil {
+		return nil, err
+	}
+	if category == "" {
+		return nil, errors.New("parameter category must not be empty")
+	}
+	return func(receipt model.Receipt) (int, string) {
+		matching := 0
+		for _, item := range receipt.Items {
+			if item.Category != nil && *item.Category == category {
+				matching++
+			}
 		}
+		return matching * config.Points, fmt.Sprintf("%d items are in category %s", matching, category)
+	}, nil
+}
+
+// purchaseDayParityRule awards the points if the day of the purchase date is odd or even, as given by the parity
+// parameter. With the optional timeZone parameter, the day is that of the purchase in the given zone. It is the
+// calendar rule with monthDays set to the parity.
+func purchaseDayParityRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	parity, err := p.text("parity")
+	if err != nil {
+		return nil, err
 	}
-	return points
+	if parity != "odd" && parity != "even" {
+		return nil, fmt.Errorf("parameter parity must be odd or even, got %q", parity)
+	}
+	zone, err := p.timeZone("timeZone")
+	if err != nil {
+		return nil, err
+	}
+	return calendar{parity: parity, zone: zone}.rule(config.Points), nil
+}
 
+// purchaseTimeWindowRule awards the points if the purchase time is within the window given by the from and to
+// parameters in the format HH:MM. The window includes from and excludes to. With the optional timeZone parameter, the
+// window applies to the time of the purchase in the given zone. It is the calendar rule with a single window.
+func purchaseTimeWindowRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	from, err := timeParam(p, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := timeParam(p, "to")
+	if err != nil {
+		return nil, err
+	}
+	if from >= to {
+		return nil, errors.New("parameter from must be before to")
+	}
+	zone, err := p.timeZone("timeZone")
+	if err != nil {
+		return nil, err
+	}
+	return calendar{windows: []timeWindow{{from: from, to: to}}, zone: zone}.rule(config.Points), nil
+}
+
+// expressionRule awards the points if the condition given by the when parameter holds. See package expr for the
+// expression language.
+func expressionRule(config RuleConfig, p *params) (func(model.Receipt) (int, string), error) {
+	when, err := p.text("when")
+	if err != nil {
+		return nil, err
+	}
+	condition, err := expr.CompileCondition(when)
+	if err != nil {
+		return nil, fmt.Errorf("parameter when: %w", err)
+	}
+	return func(receipt model.Receipt) (int, string) {
+		if condition.Holds(receipt) {
+			return config.Points, fmt.Sprintf("%s holds", condition)
+		}
+		return 0, fmt.Sprintf("%s does not hold", condition)
+	}, nil
 }
 
-func oddDayPointsRule(receipt model.Receipt) int {
-	_, _, day := receipt.PurchaseDate.Date()
-	if day%2 != 0 {
-		return oddDayRulePoints
+func timeParam(p *params, name string) (int, error) {
+	value, err := p.text(name)
+	if err != nil {
+		return 0, err
 	}
-	return 0
+	minutes, err := parseMinutes(value)
+	if err != nil {
+		return 0, fmt.Errorf("parameter %s must be a time in the format HH:MM, got %q", name, value)
+	}
+	return minutes, nil
 }
 
-func afternoonTimePointsRule(receipt model.Receipt) int {
-	split := strings.Split(receipt.PurchaseTime, ":")
-	hour, _ := strconv.Atoi(split[0])
-	// edge case: 2:00pm is considered after 2pm, 3:59pm is the last applicable time before 4pm
-	if hour >= 14 && hour < 16 {
-		return afternoonTimeRulePoints
+// parseMinutes returns the minutes since midnight of a time in the format HH:MM.
+func parseMinutes(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
 	}
-	return 0
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatMinutes(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
 }
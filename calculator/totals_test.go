@@ -2,6 +2,7 @@ package calculator
 
 import (
 	"fetch-assessment/model"
+	"fmt"
 	openapi_types "github.com/oapi-codegen/runtime/types"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -9,45 +10,45 @@ import (
 )
 
 func TestCalculationRules(t *testing.T) {
-	t.Run("retailerNamePointsRule", func(t *testing.T) {
-		require.Equal(t, 6, retailerNamePointsRule(model.Receipt{Retailer: "Target"}))
-		require.Equal(t, 13, retailerNamePointsRule(model.Receipt{Retailer: "Bed Bath & Beyond"}))
+	t.Run("retailer-name", func(t *testing.T) {
+		require.Equal(t, 6, pointsOf(evaluate("retailer-name", model.Receipt{Retailer: "Target"})))
+		require.Equal(t, 13, pointsOf(evaluate("retailer-name", model.Receipt{Retailer: "Bed Bath & Beyond"})))
 	})
 
-	t.Run("checkWholeDollarTotalRule", func(t *testing.T) {
-		require.Equal(t, 50, checkWholeDollarTotalRule(model.Receipt{Total: "10.00"}))
-		require.Equal(t, 0, checkWholeDollarTotalRule(model.Receipt{Total: "10.01"}))
+	t.Run("round-dollar-total", func(t *testing.T) {
+		require.Equal(t, 50, pointsOf(evaluate("round-dollar-total", model.Receipt{Total: "10.00"})))
+		require.Equal(t, 0, pointsOf(evaluate("round-dollar-total", model.Receipt{Total: "10.01"})))
 	})
 
-	t.Run("itemPairPointsRule", func(t *testing.T) {
-		require.Equal(t, 0, itemPairPointsRule(model.Receipt{Items: make([]model.Item, 0)}))
-		require.Equal(t, 0, itemPairPointsRule(model.Receipt{Items: make([]model.Item, 1)}))
-		require.Equal(t, 5, itemPairPointsRule(model.Receipt{Items: make([]model.Item, 2)}))
-		require.Equal(t, 5, itemPairPointsRule(model.Receipt{Items: make([]model.Item, 3)}))
-		require.Equal(t, 10, itemPairPointsRule(model.Receipt{Items: make([]model.Item, 4)}))
+	t.Run("item-pairs", func(t *testing.T) {
+		require.Equal(t, 0, pointsOf(evaluate("item-pairs", model.Receipt{Items: make([]model.Item, 0)})))
+		require.Equal(t, 0, pointsOf(evaluate("item-pairs", model.Receipt{Items: make([]model.Item, 1)})))
+		require.Equal(t, 5, pointsOf(evaluate("item-pairs", model.Receipt{Items: make([]model.Item, 2)})))
+		require.Equal(t, 5, pointsOf(evaluate("item-pairs", model.Receipt{Items: make([]model.Item, 3)})))
+		require.Equal(t, 10, pointsOf(evaluate("item-pairs", model.Receipt{Items: make([]model.Item, 4)})))
 	})
 
-	t.Run("checkQuarterDollarTotalRule", func(t *testing.T) {
-		require.Equal(t, 25, checkQuarterDollarTotalRule(model.Receipt{Total: "0.00"})) // 0 is considered a multiple for any number
-		require.Equal(t, 25, checkQuarterDollarTotalRule(model.Receipt{Total: "0.25"}))
-		require.Equal(t, 25, checkQuarterDollarTotalRule(model.Receipt{Total: "1.50"}))
-		require.Equal(t, 25, checkQuarterDollarTotalRule(model.Receipt{Total: "2.75"}))
-		require.Equal(t, 0, checkQuarterDollarTotalRule(model.Receipt{Total: "2.99"}))
+	t.Run("quarter-multiple-total", func(t *testing.T) {
+		require.Equal(t, 25, pointsOf(evaluate("quarter-multiple-total", model.Receipt{Total: "0.00"}))) // 0 is considered a multiple for any number
+		require.Equal(t, 25, pointsOf(evaluate("quarter-multiple-total", model.Receipt{Total: "0.25"})))
+		require.Equal(t, 25, pointsOf(evaluate("quarter-multiple-total", model.Receipt{Total: "1.50"})))
+		require.Equal(t, 25, pointsOf(evaluate("quarter-multiple-total", model.Receipt{Total: "2.75"})))
+		require.Equal(t, 0, pointsOf(evaluate("quarter-multiple-total", model.Receipt{Total: "2.99"})))
 	})
 
-	t.Run("itemsDescriptionRule", func(t *testing.T) {
-		require.Equal(t, 0, itemsDescriptionRule(model.Receipt{Items: []model.Item{
+	t.Run("item-description-length", func(t *testing.T) {
+		require.Equal(t, 0, pointsOf(evaluate("item-description-length", model.Receipt{Items: []model.Item{
 			{
 				ShortDescription: "xx",
 			},
-		}}))
-		require.Equal(t, 1, itemsDescriptionRule(model.Receipt{Items: []model.Item{
+		}})))
+		require.Equal(t, 1, pointsOf(evaluate("item-description-length", model.Receipt{Items: []model.Item{
 			{
 				ShortDescription: "xxx",
 				Price:            "1.00",
 			},
-		}}))
-		require.Equal(t, 3, itemsDescriptionRule(model.Receipt{Items: []model.Item{
+		}})))
+		require.Equal(t, 3, pointsOf(evaluate("item-description-length", model.Receipt{Items: []model.Item{
 			{
 				ShortDescription: "xxx",
 				Price:            "1.00",
@@ -56,29 +57,51 @@ func TestCalculationRules(t *testing.T) {
 				ShortDescription: "yyy",
 				Price:            "10.00",
 			},
-		}}))
+		}})))
 	})
 
-	t.Run("oddDayPointsRule", func(t *testing.T) {
-		require.Equal(t, 6, oddDayPointsRule(model.Receipt{PurchaseDate: mustParseDate("2025-01-01")}))
-		require.Equal(t, 0, oddDayPointsRule(model.Receipt{PurchaseDate: mustParseDate("2025-01-02")}))
-		require.Equal(t, 6, oddDayPointsRule(model.Receipt{PurchaseDate: mustParseDate("2025-02-03")}))
-		require.Equal(t, 0, oddDayPointsRule(model.Receipt{PurchaseDate: mustParseDate("2025-10-10")}))
+	t.Run("odd-purchase-day", func(t *testing.T) {
+		require.Equal(t, 6, pointsOf(evaluate("odd-purchase-day", model.Receipt{PurchaseDate: mustParseDate("2025-01-01")})))
+		require.Equal(t, 0, pointsOf(evaluate("odd-purchase-day", model.Receipt{PurchaseDate: mustParseDate("2025-01-02")})))
+		require.Equal(t, 6, pointsOf(evaluate("odd-purchase-day", model.Receipt{PurchaseDate: mustParseDate("2025-02-03")})))
+		require.Equal(t, 0, pointsOf(evaluate("odd-purchase-day", model.Receipt{PurchaseDate: mustParseDate("2025-10-10")})))
 
 	})
 
-	t.Run("afternoonTimePointsRule", func(t *testing.T) {
-		require.Equal(t, 0, afternoonTimePointsRule(model.Receipt{PurchaseTime: "13:00"}))
-		require.Equal(t, 0, afternoonTimePointsRule(model.Receipt{PurchaseTime: "13:59"}))
-		require.Equal(t, 10, afternoonTimePointsRule(model.Receipt{PurchaseTime: "14:00"}))
-		require.Equal(t, 10, afternoonTimePointsRule(model.Receipt{PurchaseTime: "14:01"}))
-		require.Equal(t, 10, afternoonTimePointsRule(model.Receipt{PurchaseTime: "15:00"}))
-		require.Equal(t, 10, afternoonTimePointsRule(model.Receipt{PurchaseTime: "15:59"}))
-		require.Equal(t, 0, afternoonTimePointsRule(model.Receipt{PurchaseTime: "10:00"}))
+	t.Run("afternoon-purchase-time", func(t *testing.T) {
+		require.Equal(t, 0, pointsOf(evaluate("afternoon-purchase-time", model.Receipt{PurchaseTime: "13:00"})))
+		require.Equal(t, 0, pointsOf(evaluate("afternoon-purchase-time", model.Receipt{PurchaseTime: "13:59"})))
+		require.Equal(t, 10, pointsOf(evaluate("afternoon-purchase-time", model.Receipt{PurchaseTime: "14:00"})))
+		require.Equal(t, 10, pointsOf(evaluate("afternoon-purchase-time", model.Receipt{PurchaseTime: "14:01"})))
+		require.Equal(t, 10, pointsOf(evaluate("afternoon-purchase-time", model.Receipt{PurchaseTime: "15:00"})))
+		require.Equal(t, 10, pointsOf(evaluate("afternoon-purchase-time", model.Receipt{PurchaseTime: "15:59"})))
+		require.Equal(t, 0, pointsOf(evaluate("afternoon-purchase-time", model.Receipt{PurchaseTime: "10:00"})))
 	})
 
 }
 
+// TestAmountRulesForEveryCentValue checks the rules on amounts against integer arithmetic for every amount up to
+// 1000.00, as floating point arithmetic would be off for some of them.
+func TestAmountRulesForEveryCentValue(t *testing.T) {
+	for cents := 0; cents <= 100_000; cents++ {
+		amount := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+		receipt := model.Receipt{Total: amount, Items: []model.Item{{ShortDescription: "abc", Price: amount}}}
+
+		roundDollar := 0
+		if cents%100 == 0 {
+			roundDollar = 50
+		}
+		require.Equal(t, roundDollar, pointsOf(evaluate("round-dollar-total", receipt)), amount)
+		quarterMultiple := 0
+		if cents%25 == 0 {
+			quarterMultiple = 25
+		}
+		require.Equal(t, quarterMultiple, pointsOf(evaluate("quarter-multiple-total", receipt)), amount)
+		// the price multiplied by 0.2 and rounded up is the price in cents divided by 500, rounded up
+		require.Equal(t, (cents+499)/500, pointsOf(evaluate("item-description-length", receipt)), amount)
+	}
+}
+
 func TestCalculateTotal(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -95,7 +118,7 @@ func TestCalculateTotal(t *testing.T) {
 					{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
 					{ShortDescription: "Knorr Creamy Chicken", Price: "1.26"},
 					{ShortDescription: "Doritos Nacho Cheese", Price: "3.35"},
-					{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12"},
+					{ShortDescription: "   Klarbrunn 12-PK 12 FL OZ  ", Price: "12.00"},
 				},
 				PurchaseDate: mustParseDate("2022-01-01"),
 				PurchaseTime: "13:01",
@@ -180,6 +203,49 @@ func TestCalculateTotal(t *testing.T) {
 
 }
 
+func TestExplain(t *testing.T) {
+	receipt := model.Receipt{
+		Retailer: "M&M Corner Market",
+		Total:    "9.00",
+		Items: []model.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Gatorade", Price: "2.25"},
+		},
+		PurchaseDate: mustParseDate("2022-03-20"),
+		PurchaseTime: "14:33",
+	}
+
+	points, results := Explain(receipt)
+
+	require.Equal(t, 109, points)
+	require.Equal(t, []RuleResult{
+		{Rule: "retailer-name", Points: 14, Reason: "retailer name has 14 alphanumeric characters"},
+		{Rule: "round-dollar-total", Points: 50, Reason: "total 9.00 is a multiple of 1.00"},
+		{Rule: "quarter-multiple-total", Points: 25, Reason: "total 9.00 is a multiple of 0.25"},
+		{Rule: "item-pairs", Points: 10, Reason: "4 items make 2 groups of 2"},
+		{Rule: "item-description-length", Points: 0, Reason: "0 items have a description length that is a multiple of 3"},
+		{Rule: "odd-purchase-day", Points: 0, Reason: "purchase day 20 is even"},
+		{Rule: "afternoon-purchase-time", Points: 10, Reason: "purchase time 14:33 is between 14:00 and 16:00"},
+	}, results)
+}
+
+// evaluate applies the rule of the default rule set with the given ID.
+func evaluate(id string, receipt model.Receipt) (int, string) {
+	for _, rule := range ActiveRuleSets().Latest().Rules() {
+		if rule.ID == id {
+			return rule.Evaluate(receipt)
+		}
+	}
+	panic("unknown rule " + id)
+}
+
+// pointsOf returns the points awarded by a rule, dropping the reason.
+func pointsOf(points int, _ string) int {
+	return points
+}
+
 func mustParseDate(dateStr string) openapi_types.Date {
 	date, err := time.Parse("2006-01-02", dateStr)
 	if err != nil {
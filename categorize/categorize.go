@@ -0,0 +1,249 @@
+// Package categorize assigns product categories, such as dairy or beverages, to receipt items by their short
+// descriptions.
+//
+// A description is split into lowercase words, and every word listed in the synonyms table is replaced by its
+// canonical word, so that "pop" and "soda" are treated alike. An item belongs to the first category with a keyword
+// occurring in these words, or with a regular expression matching the description.
+package categorize
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fetch-assessment/model"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+// defaultCategories defines the categories used unless they are loaded from a file.
+//
+//go:embed default_categories.yaml
+var defaultCategories []byte
+
+// Config is the declarative definition of the categories. It is read from YAML, or from JSON, which is a subset of
+// YAML.
+type Config struct {
+	// Synonyms maps words to the canonical word they are replaced with before matching keywords.
+	Synonyms   map[string]string `yaml:"synonyms"`
+	Categories []CategoryConfig  `yaml:"categories"`
+}
+
+// CategoryConfig defines a category by keywords, each one or more words, and regular expressions. At least one of them
+// is required.
+type CategoryConfig struct {
+	Name     string   `yaml:"name"`
+	Keywords []string `yaml:"keywords"`
+	Patterns []string `yaml:"patterns"`
+}
+
+// Categorizer assigns categories to item descriptions. It is safe for concurrent use.
+type Categorizer struct {
+	synonyms   map[string]string
+	categories []category
+}
+
+type category struct {
+	name string
+	// keywords hold the words of every keyword, with synonyms replaced.
+	keywords [][]string
+	patterns []*regexp.Regexp
+}
+
+// New validates the definition and returns a categorizer trying the categories in the order they are defined. All
+// invalid categories are reported at once.
+func New(config Config) (*Categorizer, error) {
+	if len(config.Categories) == 0 {
+		return nil, errors.New("no categories are defined")
+	}
+	var errs []error
+	c := &Categorizer{synonyms: make(map[string]string, len(config.Synonyms))}
+	for word, canonical := range config.Synonyms {
+		words, canonicalWords := split(word), split(canonical)
+		if len(words) != 1 || len(canonicalWords) != 1 {
+			errs = append(errs, fmt.Errorf("synonym %q of %q must be a single word", word, canonical))
+			continue
+		}
+		c.synonyms[words[0]] = canonicalWords[0]
+	}
+
+	names := make(map[string]bool)
+	for i, categoryConfig := range config.Categories {
+		category, err := c.newCategory(categoryConfig)
+		if err == nil && names[category.name] {
+			err = errors.New("name is already defined")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("category %d (%s): %w", i+1, categoryConfig.Name, err))
+			continue
+		}
+		names[category.name] = true
+		c.categories = append(c.categories, category)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Categorizer) newCategory(config CategoryConfig) (category, error) {
+	var errs []error
+	if config.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+	if len(config.Keywords) == 0 && len(config.Patterns) == 0 {
+		errs = append(errs, errors.New("either keywords or patterns are required"))
+	}
+	category := category{name: config.Name}
+	for i, keyword := range config.Keywords {
+		words := c.words(keyword)
+		if len(words) == 0 {
+			errs = append(errs, fmt.Errorf("keyword %d must contain a letter or digit, got %q", i+1, keyword))
+			continue
+		}
+		category.keywords = append(category.keywords, words)
+	}
+	for i, pattern := range config.Patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("pattern %d: %w", i+1, err))
+			continue
+		}
+		category.patterns = append(category.patterns, re)
+	}
+	return category, errors.Join(errs...)
+}
+
+// Parse reads a YAML or JSON definition. Unknown fields are rejected. See New.
+func Parse(data []byte) (*Categorizer, error) {
+	var config Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("reading categories: %w", err)
+	}
+	return New(config)
+}
+
+// Load reads the definition from the YAML or JSON file at path. See Parse.
+func Load(path string) (*Categorizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// LoadOrDefault loads the categories from path, or returns the built-in ones if path is empty.
+func LoadOrDefault(path string) (*Categorizer, error) {
+	if path == "" {
+		return Parse(defaultCategories)
+	}
+	return Load(path)
+}
+
+// Categories returns the names of the categories in the order they are tried.
+func (c *Categorizer) Categories() []string {
+	names := make([]string, 0, len(c.categories))
+	for _, category := range c.categories {
+		names = append(names, category.name)
+	}
+	return names
+}
+
+// Category returns the category of an item description, or false if no category matches.
+func (c *Categorizer) Category(description string) (string, bool) {
+	words := c.words(description)
+	description = strings.TrimSpace(description)
+	for _, category := range c.categories {
+		for _, keyword := range category.keywords {
+			if containsWords(words, keyword) {
+				return category.name, true
+			}
+		}
+		for _, pattern := range category.patterns {
+			if pattern.MatchString(description) {
+				return category.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Items sets the category of every item, replacing any category it had. Items matching no category are left without.
+func (c *Categorizer) Items(items []model.Item) {
+	for i := range items {
+		items[i].Category = nil
+		if name, ok := c.Category(items[i].ShortDescription); ok {
+			items[i].Category = &name
+		}
+	}
+}
+
+// words splits text into lowercase words of letters and digits and replaces synonyms.
+func (c *Categorizer) words(text string) []string {
+	words := split(text)
+	for i, word := range words {
+		if canonical, ok := c.synonyms[word]; ok {
+			words[i] = canonical
+		}
+	}
+	return words
+}
+
+func split(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsWords reports whether keyword occurs in words as a contiguous sequence.
+func containsWords(words, keyword []string) bool {
+	for i := 0; i+len(keyword) <= len(words); i++ {
+		match := true
+		for j, word := range keyword {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// active holds the categorizer used when receipts are stored.
+var active atomic.Pointer[Categorizer]
+
+func init() {
+	c, err := Parse(defaultCategories)
+	if err != nil {
+		panic(err)
+	}
+	active.Store(c)
+}
+
+// Active returns the categorizer used when receipts are stored.
+func Active() *Categorizer {
+	return active.Load()
+}
+
+// Activate makes c the categorizer used when receipts are stored.
+func Activate(c *Categorizer) {
+	active.Store(c)
+}
+
+// Items sets the category of every item using the active categorizer.
+func Items(items []model.Item) {
+	Active().Items(items)
+}
@@ -0,0 +1,142 @@
+package categorize
+
+import (
+	"fetch-assessment/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDefaultCategories(t *testing.T) {
+	c, err := LoadOrDefault("")
+	require.NoError(t, err)
+
+	tests := []struct {
+		description string
+		want        string
+	}{
+		{"Mountain Dew 12PK", "beverages"},
+		{"   Klarbrunn 12-PK 12 FL OZ  ", "beverages"},
+		{"Diet Pop", "beverages"},
+		{"Emils Cheese Pizza", "frozen"},
+		{"Doritos Nacho Cheese", "snacks"},
+		{"Knorr Creamy Chicken", "pantry"},
+		{"Gallon of Milk", "dairy"},
+		{"Paper Towels 6 Rolls", "household"},
+		{"Bananas", "produce"},
+		{"Gift Card", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			category, ok := c.Category(tc.description)
+			assert.Equal(t, tc.want != "", ok)
+			assert.Equal(t, tc.want, category)
+		})
+	}
+}
+
+func TestCategory(t *testing.T) {
+	c, err := Parse([]byte(`
+synonyms: {pop: soda, "Crisps": chips}
+categories:
+  - name: beverages
+    keywords: [soda, sparkling water]
+    patterns: ['\d+\s*pk$']
+  - name: snacks
+    keywords: [chips]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"beverages", "snacks"}, c.Categories())
+
+	tests := []struct {
+		description string
+		want        string
+	}{
+		{"SODA", "beverages"},
+		{"Grape-Pop", "beverages"},
+		{"Sparkling  Water", "beverages"},
+		{"Water Sparkling", ""},
+		{"Sodastream", ""},
+		{"Store Brand 12 PK", "beverages"},
+		{"Salted crisps", "snacks"},
+		{"Chips and Soda", "beverages"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			category, _ := c.Category(tc.description)
+			assert.Equal(t, tc.want, category)
+		})
+	}
+}
+
+func TestItems(t *testing.T) {
+	c, err := Parse([]byte(`categories: [{name: beverages, keywords: [soda]}]`))
+	require.NoError(t, err)
+	submitted := "snacks"
+	items := []model.Item{
+		{ShortDescription: "Soda", Price: "1.00", Category: &submitted},
+		{ShortDescription: "Chips", Price: "1.00", Category: &submitted},
+	}
+
+	c.Items(items)
+	require.NotNil(t, items[0].Category)
+	assert.Equal(t, "beverages", *items[0].Category)
+	assert.Nil(t, items[1].Category)
+	assert.Equal(t, "snacks", submitted)
+}
+
+func TestParseRejectsInvalidDefinitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		wantErr    string
+	}{
+		{
+			name:       "no categories",
+			definition: `synonyms: {pop: soda}`,
+			wantErr:    "no categories are defined",
+		},
+		{
+			name:       "unknown field",
+			definition: `categories: [{name: a, keyword: [b]}]`,
+			wantErr:    "field keyword not found",
+		},
+		{
+			name:       "missing name",
+			definition: `categories: [{keywords: [b]}]`,
+			wantErr:    "category 1 (): name is required",
+		},
+		{
+			name:       "duplicate name",
+			definition: `categories: [{name: a, keywords: [b]}, {name: a, keywords: [c]}]`,
+			wantErr:    "category 2 (a): name is already defined",
+		},
+		{
+			name:       "neither keywords nor patterns",
+			definition: `categories: [{name: a}]`,
+			wantErr:    "category 1 (a): either keywords or patterns are required",
+		},
+		{
+			name:       "empty keyword",
+			definition: `categories: [{name: a, keywords: [b, "-"]}]`,
+			wantErr:    `category 1 (a): keyword 2 must contain a letter or digit, got "-"`,
+		},
+		{
+			name:       "invalid pattern",
+			definition: `categories: [{name: a, patterns: ["("]}]`,
+			wantErr:    "category 1 (a): pattern 1: error parsing regexp",
+		},
+		{
+			name:       "synonym of several words",
+			definition: `{synonyms: {"soft drink": soda}, categories: [{name: a, keywords: [b]}]}`,
+			wantErr:    `synonym "soft drink" of "soda" must be a single word`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.definition))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -2,24 +2,43 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fetch-assessment/calculator"
+	"fetch-assessment/categorize"
 	"fetch-assessment/model"
+	"fetch-assessment/money"
+	"fetch-assessment/rescore"
 	"fetch-assessment/store"
 	"fetch-assessment/validation"
 	"fmt"
 	"github.com/gorilla/mux"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 type totalResponse struct {
-	Points int `json:"points"`
+	Points         int                         `json:"points"`
+	RuleSetVersion string                      `json:"ruleSetVersion"`
+	Review         bool                        `json:"review"`
+	Experiment     *model.ExperimentAssignment `json:"experiment,omitempty"`
+	Breakdown      []model.RulePoints          `json:"breakdown,omitempty"`
 }
 
 type uuidResponse struct {
 	Id string `json:"id"`
 }
 
-func StoreReceiptHandler(w http.ResponseWriter, r *http.Request, receiptStore *store.ReceiptStore) {
+type duplicateResponse struct {
+	ExistingId string `json:"existingId"`
+}
+
+type ruleSetResponse struct {
+	Version string `json:"version"`
+}
+
+func StoreReceiptHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
 
 	decoder := json.NewDecoder(r.Body)
 	var rc model.Receipt
@@ -34,10 +53,20 @@ func StoreReceiptHandler(w http.ResponseWriter, r *http.Request, receiptStore *s
 		writeErrorResponse(w, err)
 		return
 	}
+	categorize.Items(rc.Items)
+	rc.Experiment = calculator.AssignExperiment(rc, store.Fingerprint(rc), r.Header.Get("X-User-ID"), time.Now())
 
 	item, err := receiptStore.Store(rc)
+	var duplicateErr *store.DuplicateReceiptError
+	if errors.As(err, &duplicateErr) {
+		fmt.Printf("receipt is a duplicate of %s\n", duplicateErr.ExistingID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(duplicateResponse{ExistingId: duplicateErr.ExistingID})
+		return
+	}
 	if err != nil {
-		writeErrorResponse(w, err)
+		writeInternalErrorResponse(w, err)
 		return
 	}
 
@@ -52,7 +81,12 @@ func writeErrorResponse(w http.ResponseWriter, err error) {
 	http.Error(w, "Invalid request format", http.StatusBadRequest)
 }
 
-func GetPointsHandler(w http.ResponseWriter, r *http.Request, receiptStore *store.ReceiptStore) {
+func writeInternalErrorResponse(w http.ResponseWriter, err error) {
+	fmt.Println("Internal error", err)
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+func GetPointsHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
 
 	vars := mux.Vars(r)
 	id, ok := vars["id"]
@@ -61,17 +95,495 @@ func GetPointsHandler(w http.ResponseWriter, r *http.Request, receiptStore *stor
 		http.Error(w, "Receipt ID missing", http.StatusNotFound)
 		return
 	}
-	receipt := receiptStore.GetReceipt(id)
-	if receipt == nil {
+	explain := false
+	if value := r.URL.Query().Get("explain"); value != "" {
+		var err error
+		if explain, err = strconv.ParseBool(value); err != nil {
+			writeErrorResponse(w, err)
+			return
+		}
+	}
+	stored, err := receiptStore.Get(id)
+	if errors.Is(err, store.ErrReceiptNotFound) {
 		fmt.Printf("receipt for id %s not present\n", id)
 		http.Error(w, "Receipt ID not found", http.StatusNotFound)
 		return
 	}
-	total := calculator.CalculateTotals(*receipt)
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+	score := calculator.ScoreReceipt(stored.Receipt, stored.ReceivedAt)
 	w.Header().Set("Content-Type", "application/json")
 	var totalRsp = totalResponse{
-		Points: total,
+		Points:         score.Points,
+		RuleSetVersion: score.RuleSetVersion,
+		Review:         score.Review,
+		Experiment:     score.Experiment,
+	}
+	if explain {
+		totalRsp.Breakdown = toRulePointsModel(score.Rules)
 	}
 	json.NewEncoder(w).Encode(totalRsp)
 
 }
+
+func GetReceiptHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		fmt.Println("id is missing in parameters")
+		http.Error(w, "Receipt ID missing", http.StatusNotFound)
+		return
+	}
+	stored, err := receiptStore.Get(id)
+	if errors.Is(err, store.ErrReceiptNotFound) {
+		fmt.Printf("receipt for id %s not present\n", id)
+		http.Error(w, "Receipt ID not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toStoredReceiptModel(stored))
+}
+
+func UpdateReceiptHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		fmt.Println("id is missing in parameters")
+		http.Error(w, "Receipt ID missing", http.StatusNotFound)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var rc model.Receipt
+	err := decoder.Decode(&rc)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	valid, err := validation.ValidateReceipt(rc)
+	if err != nil || !valid {
+		writeErrorResponse(w, err)
+		return
+	}
+	categorize.Items(rc.Items)
+	// a correction keeps the variant the receipt was assigned when it was submitted
+	rc.Experiment = nil
+	if previous, err := receiptStore.Get(id); err == nil {
+		rc.Experiment = previous.Receipt.Experiment
+	}
+
+	stored, err := receiptStore.Update(id, rc)
+	if errors.Is(err, store.ErrReceiptNotFound) {
+		fmt.Printf("receipt for id %s not present\n", id)
+		http.Error(w, "Receipt ID not found", http.StatusNotFound)
+		return
+	}
+	var duplicateErr *store.DuplicateReceiptError
+	if errors.As(err, &duplicateErr) {
+		fmt.Printf("receipt is a duplicate of %s\n", duplicateErr.ExistingID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(duplicateResponse{ExistingId: duplicateErr.ExistingID})
+		return
+	}
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toStoredReceiptModel(stored))
+}
+
+func DeleteReceiptHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		fmt.Println("id is missing in parameters")
+		http.Error(w, "Receipt ID missing", http.StatusNotFound)
+		return
+	}
+	err := receiptStore.Delete(id)
+	if errors.Is(err, store.ErrReceiptNotFound) {
+		fmt.Printf("receipt for id %s not present\n", id)
+		http.Error(w, "Receipt ID not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func ListReceiptVersionsHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		fmt.Println("id is missing in parameters")
+		http.Error(w, "Receipt ID missing", http.StatusNotFound)
+		return
+	}
+	versions, err := receiptStore.Versions(id)
+	if errors.Is(err, store.ErrReceiptNotFound) {
+		fmt.Printf("receipt for id %s not present\n", id)
+		http.Error(w, "Receipt ID not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+
+	response := model.ReceiptVersions{Versions: make([]model.StoredReceipt, 0, len(versions))}
+	for _, version := range versions {
+		response.Versions = append(response.Versions, toStoredReceiptModel(version))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func GetReceiptVersionHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		fmt.Println("id is missing in parameters")
+		http.Error(w, "Receipt ID missing", http.StatusNotFound)
+		return
+	}
+	version, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		fmt.Printf("invalid version %q\n", vars["version"])
+		http.Error(w, "Receipt version not found", http.StatusNotFound)
+		return
+	}
+	versions, err := receiptStore.Versions(id)
+	if errors.Is(err, store.ErrReceiptNotFound) {
+		fmt.Printf("receipt for id %s not present\n", id)
+		http.Error(w, "Receipt ID not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+	if version < 1 || version > len(versions) {
+		fmt.Printf("version %d of receipt %s not present\n", version, id)
+		http.Error(w, "Receipt version not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toStoredReceiptModel(versions[version-1]))
+}
+
+// ReloadRulesHandler replaces the active rule sets with the ones defined in the file at rulesPath, or the built-in one
+// if rulesPath is empty. Invalid rule sets are rejected and the active ones kept.
+func ReloadRulesHandler(w http.ResponseWriter, r *http.Request, rulesPath string) {
+
+	ruleSets, err := calculator.ReloadRuleSets(rulesPath)
+	if err != nil {
+		fmt.Printf("keeping rule set version %s, reloading failed: %v\n", calculator.RuleSetVersion(), err)
+		http.Error(w, "Invalid rule set", http.StatusUnprocessableEntity)
+		return
+	}
+	version := ruleSets.Latest().Version()
+	fmt.Printf("activated rule set version %s\n", version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ruleSetResponse{Version: version})
+}
+
+// WhatIfHandler scores a receipt, given inline or by ID, under the active and the candidate rule sets in the request,
+// without storing anything.
+func WhatIfHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	var request model.WhatIfRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+	if (request.Receipt == nil) == (request.ReceiptId == nil) || request.RuleSets == nil {
+		writeErrorResponse(w, errors.New("either receipt or receiptId, and ruleSets are required"))
+		return
+	}
+
+	receipt, receivedAt := model.Receipt{}, time.Now()
+	if request.Receipt != nil {
+		valid, err := validation.ValidateReceipt(*request.Receipt)
+		if err != nil || !valid {
+			writeErrorResponse(w, err)
+			return
+		}
+		receipt = *request.Receipt
+		categorize.Items(receipt.Items)
+		// the experiment is assigned as if the receipt were submitted now, never chosen by the client
+		receipt.Experiment = calculator.AssignExperiment(receipt, store.Fingerprint(receipt), r.Header.Get("X-User-ID"),
+			receivedAt)
+	} else {
+		stored, err := receiptStore.Get(*request.ReceiptId)
+		if errors.Is(err, store.ErrReceiptNotFound) {
+			fmt.Printf("receipt for id %s not present\n", *request.ReceiptId)
+			http.Error(w, "Receipt ID not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeInternalErrorResponse(w, err)
+			return
+		}
+		receipt, receivedAt = stored.Receipt, stored.ReceivedAt
+	}
+
+	// the rule set definition is read like a rules file, of which JSON is a subset
+	definition, err := json.Marshal(request.RuleSets)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+	candidate, err := calculator.ParseRuleSets(definition)
+	if err != nil {
+		fmt.Println("invalid candidate rule set", err)
+		http.Error(w, "Invalid rule set\n"+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	active := calculator.ScoreReceipt(receipt, receivedAt)
+	candidateScore := candidate.Score(receipt, receivedAt)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.WhatIfResult{
+		Active:     toScoreModel(active),
+		Candidate:  toScoreModel(candidateScore),
+		Difference: candidateScore.Points - active.Points,
+	})
+}
+
+// RescoreHandler compares the points of all stored receipts under two versions of the active rule sets, and responds
+// with the report as JSON or CSV.
+func RescoreHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	values := r.URL.Query()
+	top := rescore.DefaultTop
+	if value := values.Get("top"); value != "" {
+		var err error
+		if top, err = strconv.Atoi(value); err != nil || top < 0 {
+			writeErrorResponse(w, fmt.Errorf("top must be a non-negative integer, got %q", value))
+			return
+		}
+	}
+	format := values.Get("format")
+	if format != "" && format != string(model.Json) && format != string(model.Csv) {
+		writeErrorResponse(w, fmt.Errorf("format must be json or csv, got %q", format))
+		return
+	}
+	ruleSets := calculator.ActiveRuleSets()
+	from, to, err := rescore.Resolve(ruleSets, values.Get("from"), values.Get("to"), time.Now())
+	if errors.Is(err, rescore.ErrUnknownVersion) {
+		fmt.Println(err)
+		http.Error(w, "Rule set version not found", http.StatusNotFound)
+		return
+	}
+
+	receipts, err := receiptStore.List()
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+	report := rescore.Compare(ruleSets, receipts, from, to, top)
+	fmt.Printf("rescored %d receipts from rule set version %s to %s, %d changed\n", report.Receipts, report.From,
+		report.To, report.ChangedReceipts)
+
+	if format == string(model.Csv) {
+		w.Header().Set("Content-Type", "text/csv")
+		if err := report.WriteCSV(w); err != nil {
+			fmt.Println("writing rescore report failed", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toRescoreReportModel(report))
+}
+
+func toRescoreReportModel(report rescore.Report) model.RescoreReport {
+	result := model.RescoreReport{
+		From:            report.From,
+		To:              report.To,
+		Receipts:        report.Receipts,
+		ChangedReceipts: report.ChangedReceipts,
+		FromPoints:      report.FromPoints,
+		ToPoints:        report.ToPoints,
+		Delta:           report.Delta(),
+		Rules:           make([]model.RuleDelta, 0, len(report.Rules)),
+		LargestChanges:  make([]model.ReceiptDelta, 0, len(report.LargestChanges)),
+		Variants:        make([]model.VariantDelta, 0, len(report.Variants)),
+	}
+	for _, rule := range report.Rules {
+		result.Rules = append(result.Rules, model.RuleDelta{
+			Rule:            rule.Rule,
+			FromPoints:      rule.FromPoints,
+			ToPoints:        rule.ToPoints,
+			Delta:           rule.Delta(),
+			ChangedReceipts: rule.ChangedReceipts,
+		})
+	}
+	for _, receipt := range report.LargestChanges {
+		result.LargestChanges = append(result.LargestChanges, model.ReceiptDelta{
+			Id:         receipt.ID,
+			FromPoints: receipt.FromPoints,
+			ToPoints:   receipt.ToPoints,
+			Delta:      receipt.Delta(),
+		})
+	}
+	for _, variant := range report.Variants {
+		result.Variants = append(result.Variants, model.VariantDelta{
+			Experiment:      variant.Experiment,
+			Variant:         variant.Variant,
+			Receipts:        variant.Receipts,
+			ChangedReceipts: variant.ChangedReceipts,
+			FromPoints:      variant.FromPoints,
+			ToPoints:        variant.ToPoints,
+			Delta:           variant.Delta(),
+		})
+	}
+	return result
+}
+
+func toScoreModel(score calculator.Score) model.Score {
+	return model.Score{
+		RuleSetVersion: score.RuleSetVersion,
+		Points:         score.Points,
+		Review:         score.Review,
+		Breakdown:      toRulePointsModel(score.Rules),
+	}
+}
+
+func toRulePointsModel(results []calculator.RuleResult) []model.RulePoints {
+	breakdown := make([]model.RulePoints, 0, len(results))
+	for _, result := range results {
+		rulePoints := model.RulePoints{
+			Rule:   result.Rule,
+			Points: result.Points,
+			Reason: result.Reason,
+		}
+		if result.Campaign {
+			campaign := true
+			rulePoints.Campaign = &campaign
+		}
+		if result.Capped {
+			capped := true
+			rulePoints.Capped = &capped
+		}
+		if result.Suspicious {
+			suspicious := true
+			rulePoints.Suspicious = &suspicious
+		}
+		breakdown = append(breakdown, rulePoints)
+	}
+	return breakdown
+}
+
+func toStoredReceiptModel(stored store.StoredReceipt) model.StoredReceipt {
+	result := model.StoredReceipt{
+		Id:             stored.ID,
+		Version:        stored.Version,
+		Receipt:        stored.Receipt,
+		ReceivedAt:     stored.ReceivedAt,
+		UpdatedAt:      stored.UpdatedAt,
+		RuleSetVersion: calculator.RuleSetFor(stored.Receipt, stored.ReceivedAt).Version(),
+	}
+	if stored.Deleted {
+		result.Deleted = &stored.Deleted
+	}
+	return result
+}
+
+func ListReceiptsHandler(w http.ResponseWriter, r *http.Request, receiptStore store.ReceiptRepository) {
+
+	query, err := parseQuery(r.URL.Query())
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	page, err := receiptStore.Search(query)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		writeErrorResponse(w, err)
+		return
+	}
+	if err != nil {
+		writeInternalErrorResponse(w, err)
+		return
+	}
+
+	response := model.ReceiptPage{Receipts: make([]model.StoredReceipt, 0, len(page.Receipts))}
+	for _, stored := range page.Receipts {
+		response.Receipts = append(response.Receipts, toStoredReceiptModel(stored))
+	}
+	if page.NextCursor != "" {
+		response.NextCursor = &page.NextCursor
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func parseQuery(values url.Values) (store.Query, error) {
+	query := store.Query{
+		Retailer:        values.Get("retailer"),
+		ItemDescription: values.Get("item"),
+		Cursor:          values.Get("cursor"),
+	}
+
+	var err error
+	if query.PurchasedFrom, err = parseDateParam(values, "purchasedFrom"); err != nil {
+		return store.Query{}, err
+	}
+	if query.PurchasedTo, err = parseDateParam(values, "purchasedTo"); err != nil {
+		return store.Query{}, err
+	}
+	if query.MinTotalCents, err = parseAmountParam(values, "minTotal"); err != nil {
+		return store.Query{}, err
+	}
+	if query.MaxTotalCents, err = parseAmountParam(values, "maxTotal"); err != nil {
+		return store.Query{}, err
+	}
+	if limit := values.Get("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 1 || query.Limit > store.MaxPageSize {
+			return store.Query{}, fmt.Errorf("limit must be between 1 and %d", store.MaxPageSize)
+		}
+	}
+	return query, nil
+}
+
+func parseDateParam(values url.Values, name string) (*time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date in the format YYYY-MM-DD", name)
+	}
+	return &date, nil
+}
+
+func parseAmountParam(values url.Values, name string) (*int64, error) {
+	value := values.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+	amount, err := money.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an amount in the format 0.00", name)
+	}
+	cents := amount.Cents()
+	return &cents, nil
+}
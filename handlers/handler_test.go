@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fetch-assessment/calculator"
+	"fetch-assessment/model"
+	"fetch-assessment/store"
+	"fmt"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWhatIfAssignsExperiment(t *testing.T) {
+	builtIn := calculator.ActiveRuleSets()
+	t.Cleanup(func() { calculator.Activate(builtIn) })
+	today := time.Now().UTC()
+	ruleSets, err := calculator.ParseRuleSets([]byte(fmt.Sprintf(`
+version: "1"
+rules: [{id: name, type: retailer-name-length, points: 1}]
+experiments:
+  - id: name-points
+    ruleSet: "1"
+    from: %s
+    to: %s
+    bucketBy: user
+    variants: [{name: control}, {name: more, rules: [{id: name, points: 10}]}]
+`, today.AddDate(0, 0, -1).Format("2006-01-02"), today.AddDate(0, 0, 1).Format("2006-01-02"))))
+	require.NoError(t, err)
+	calculator.Activate(ruleSets)
+
+	receipt := model.Receipt{Retailer: "Target", PurchaseDate: openapi_types.Date{Time: time.Date(2022, 1, 1, 0, 0, 0, 0,
+		time.UTC)}, PurchaseTime: "13:01", Total: "6.49",
+		Items: []model.Item{{ShortDescription: "Mountain Dew 12PK", Price: "6.49"}}}
+	whatIf := func(userID string) model.WhatIfResult {
+		forged := receipt
+		forged.Experiment = &model.ExperimentAssignment{Id: "name-points", Variant: "more"}
+		body, err := json.Marshal(model.WhatIfRequest{Receipt: &forged,
+			RuleSets: map[string]any{"version": "1", "rules": []any{
+				map[string]any{"id": "name", "type": "retailer-name-length", "points": 1}}}})
+		require.NoError(t, err)
+		request := httptest.NewRequest(http.MethodPost, "/admin/rules/what-if", strings.NewReader(string(body)))
+		if userID != "" {
+			request.Header.Set("X-User-ID", userID)
+		}
+		recorder := httptest.NewRecorder()
+		WhatIfHandler(recorder, request, store.NewReceiptStore())
+		require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+		var result model.WhatIfResult
+		require.NoError(t, json.NewDecoder(recorder.Body).Decode(&result))
+		return result
+	}
+
+	// the experiment sent by the client is ignored; without a user the receipt takes part in no experiment
+	assert.Equal(t, 6, whatIf("").Active.Points)
+
+	for _, userID := range []string{"user-1", "user-2", "user-3", "user-4"} {
+		assignment := calculator.AssignExperiment(receipt, store.Fingerprint(receipt), userID, time.Now())
+		require.NotNil(t, assignment)
+		wantPoints := 6
+		if assignment.Variant == "more" {
+			wantPoints = 60
+		}
+		assert.Equal(t, wantPoints, whatIf(userID).Active.Points, userID)
+	}
+}
@@ -0,0 +1,179 @@
+// Package idempotency lets clients safely retry requests by sending an Idempotency-Key header. The response to the
+// first request with a given key is recorded and replayed for every repeated request with the same key and body.
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderKey      = "Idempotency-Key"
+	HeaderReplayed = "Idempotent-Replayed"
+
+	// DefaultWindow is the default duration for which responses are kept.
+	DefaultWindow = 24 * time.Hour
+)
+
+// Cache records responses by idempotency key. Keys expire after the configured window. It is safe for concurrent use.
+type Cache struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*entry
+	// order holds the entries in insertion order, so that expired entries can be evicted from the front.
+	order []orderedEntry
+	now   func() time.Time
+}
+
+// orderedEntry is a slot in the eviction order. A slot whose entry is no longer the one recorded for its key, because
+// it was deleted after a server error and possibly recorded again, is skipped.
+type orderedEntry struct {
+	key   string
+	entry *entry
+}
+
+type entry struct {
+	bodyHash  [sha256.Size]byte
+	createdAt time.Time
+	// done is false while the first request is still being processed.
+	done   bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+func NewCache(window time.Duration) *Cache {
+	return &Cache{
+		window:  window,
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Middleware wraps next, so that requests carrying an Idempotency-Key header are processed at most once per key.
+// A repeated request with the same key and body receives the recorded response. A repeated request with a different
+// body is rejected with 422, and a request arriving while the first one with the same key is still in progress is
+// rejected with 409. Server errors are not recorded, so that the request can be retried with the same key.
+// Requests without the header are passed through unchanged.
+func (c *Cache) Middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			fmt.Println("Invalid request format", err)
+			http.Error(w, "Invalid request format", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		bodyHash := sha256.Sum256(body)
+
+		existing, created := c.reserve(key, bodyHash)
+		if !created {
+			replay(w, existing, bodyHash)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+		c.complete(key, recorder)
+	}
+}
+
+// reserve returns a copy of the entry recorded for key, or creates a pending entry if there is none.
+func (c *Cache) reserve(key string, bodyHash [sha256.Size]byte) (entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evictExpired()
+
+	if e, ok := c.entries[key]; ok {
+		return *e, false
+	}
+	e := &entry{bodyHash: bodyHash, createdAt: c.now()}
+	c.entries[key] = e
+	c.order = append(c.order, orderedEntry{key: key, entry: e})
+	return entry{}, true
+}
+
+func (c *Cache) complete(key string, recorder *responseRecorder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if recorder.status >= http.StatusInternalServerError {
+		// the slot stays in order until it reaches the front, eviction skips slots of deleted entries
+		delete(c.entries, key)
+		return
+	}
+	e.done = true
+	e.status = recorder.status
+	e.header = recorder.Header().Clone()
+	e.body = recorder.body.Bytes()
+}
+
+func (c *Cache) evictExpired() {
+	cutoff := c.now().Add(-c.window)
+	for len(c.order) > 0 {
+		slot := c.order[0]
+		if e, ok := c.entries[slot.key]; ok && e == slot.entry {
+			if e.createdAt.After(cutoff) {
+				return
+			}
+			delete(c.entries, slot.key)
+		}
+		c.order = c.order[1:]
+	}
+}
+
+func replay(w http.ResponseWriter, e entry, bodyHash [sha256.Size]byte) {
+	if e.bodyHash != bodyHash {
+		fmt.Println("Idempotency key reused with a different request body")
+		http.Error(w, "Idempotency key reused with a different request body", http.StatusUnprocessableEntity)
+		return
+	}
+	if !e.done {
+		fmt.Println("Request with the same idempotency key is still in progress")
+		http.Error(w, "Request with the same idempotency key is still in progress", http.StatusConflict)
+		return
+	}
+	for name, values := range e.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// responseRecorder passes the response through to the client while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,198 @@
+package idempotency
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Run("without key every request is processed", func(t *testing.T) {
+		handler, calls := countingHandler(http.StatusOK)
+		wrapped := NewCache(time.Hour).Middleware(handler)
+
+		first := send(wrapped, "", "body")
+		second := send(wrapped, "", "body")
+
+		assert.Equal(t, 2, *calls)
+		assert.Equal(t, `{"call":1}`, first.Body.String())
+		assert.Equal(t, `{"call":2}`, second.Body.String())
+	})
+
+	t.Run("repeated key replays the original response", func(t *testing.T) {
+		handler, calls := countingHandler(http.StatusOK)
+		wrapped := NewCache(time.Hour).Middleware(handler)
+
+		first := send(wrapped, "key", "body")
+		second := send(wrapped, "key", "body")
+
+		assert.Equal(t, 1, *calls)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Equal(t, first.Body.String(), second.Body.String())
+		assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+		assert.Equal(t, "true", second.Header().Get(HeaderReplayed))
+		assert.Empty(t, first.Header().Get(HeaderReplayed))
+	})
+
+	t.Run("different keys are processed separately", func(t *testing.T) {
+		handler, calls := countingHandler(http.StatusOK)
+		wrapped := NewCache(time.Hour).Middleware(handler)
+
+		send(wrapped, "first", "body")
+		send(wrapped, "second", "body")
+
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("repeated key with a different body is rejected", func(t *testing.T) {
+		handler, calls := countingHandler(http.StatusOK)
+		wrapped := NewCache(time.Hour).Middleware(handler)
+
+		send(wrapped, "key", "body")
+		second := send(wrapped, "key", "other body")
+
+		assert.Equal(t, 1, *calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, second.Code)
+	})
+
+	t.Run("client errors are replayed", func(t *testing.T) {
+		handler, calls := countingHandler(http.StatusBadRequest)
+		wrapped := NewCache(time.Hour).Middleware(handler)
+
+		send(wrapped, "key", "body")
+		second := send(wrapped, "key", "body")
+
+		assert.Equal(t, 1, *calls)
+		assert.Equal(t, http.StatusBadRequest, second.Code)
+	})
+
+	t.Run("server errors are not recorded", func(t *testing.T) {
+		handler, calls := countingHandler(http.StatusInternalServerError)
+		wrapped := NewCache(time.Hour).Middleware(handler)
+
+		send(wrapped, "key", "body")
+		second := send(wrapped, "key", "body")
+
+		assert.Equal(t, 2, *calls)
+		assert.Empty(t, second.Header().Get(HeaderReplayed))
+	})
+
+	t.Run("keys expire after the window", func(t *testing.T) {
+		handler, calls := countingHandler(http.StatusOK)
+		cache := NewCache(time.Hour)
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+		wrapped := cache.Middleware(handler)
+
+		send(wrapped, "key", "body")
+		now = now.Add(59 * time.Minute)
+		send(wrapped, "key", "body")
+		assert.Equal(t, 1, *calls)
+
+		now = now.Add(2 * time.Minute)
+		second := send(wrapped, "key", "other body")
+		assert.Equal(t, 2, *calls)
+		assert.Equal(t, http.StatusOK, second.Code)
+		assert.Len(t, cache.entries, 1)
+	})
+
+	t.Run("keys retried after a server error do not hold back eviction", func(t *testing.T) {
+		cache := NewCache(time.Hour)
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		cache.now = func() time.Time { return now }
+		failed := false
+		wrapped := cache.Middleware(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(HeaderKey) == "retried" && !failed {
+				failed = true
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		})
+
+		send(wrapped, "first", "body")
+		now = now.Add(time.Minute)
+		send(wrapped, "retried", "body")
+		now = now.Add(time.Minute)
+		send(wrapped, "second", "body")
+		now = now.Add(time.Minute)
+		retry := send(wrapped, "retried", "body")
+		assert.Equal(t, http.StatusOK, retry.Code)
+
+		// first and second expire, while the retry is still within the window
+		now = now.Add(59*time.Minute + 30*time.Second)
+		send(wrapped, "third", "body")
+		assert.NotContains(t, cache.entries, "first")
+		assert.NotContains(t, cache.entries, "second")
+		assert.Contains(t, cache.entries, "retried")
+		assert.Len(t, cache.entries, 2)
+	})
+
+	t.Run("the body is passed on to the handler", func(t *testing.T) {
+		var received string
+		wrapped := NewCache(time.Hour).Middleware(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received = string(body)
+		})
+
+		send(wrapped, "key", "body")
+
+		assert.Equal(t, "body", received)
+	})
+}
+
+func TestConcurrentRequestsWithSameKey(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var once sync.Once
+	wrapped := NewCache(time.Hour).Middleware(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { close(started) })
+		<-release
+		w.Write([]byte("done"))
+	})
+
+	var first *httptest.ResponseRecorder
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		first = send(wrapped, "key", "body")
+	}()
+
+	<-started
+	inProgress := send(wrapped, "key", "body")
+	assert.Equal(t, http.StatusConflict, inProgress.Code)
+
+	close(release)
+	wg.Wait()
+	assert.Equal(t, "done", first.Body.String())
+	assert.Equal(t, "done", send(wrapped, "key", "body").Body.String())
+}
+
+func countingHandler(status int) (http.HandlerFunc, *int) {
+	calls := 0
+	var mu sync.Mutex
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"call":%d}`, call)
+	}, &calls
+}
+
+func send(handler http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/receipts/process", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(HeaderKey, key)
+	}
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
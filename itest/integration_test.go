@@ -6,11 +6,13 @@ package itest
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestIntegration(t *testing.T) {
@@ -78,8 +80,9 @@ func callPost(t *testing.T, err error, in string) string {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	// receipts submitted by a previous run against the same server are rejected as duplicates
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected status 200 OK or 409 Conflict, got %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -89,7 +92,8 @@ func callPost(t *testing.T, err error, in string) string {
 
 	// Define the struct to hold the response (if not already defined)
 	type uuidResponse struct {
-		UUID string `json:"id"`
+		UUID       string `json:"id"`
+		ExistingID string `json:"existingId"`
 	}
 
 	var result uuidResponse
@@ -98,6 +102,9 @@ func callPost(t *testing.T, err error, in string) string {
 		t.Fatalf("Failed to unmarshal response: %v", err)
 	}
 
+	if resp.StatusCode == http.StatusConflict {
+		return result.ExistingID
+	}
 	return result.UUID
 }
 
@@ -138,3 +145,319 @@ func callGet(t *testing.T, err error, uuid string) int {
 
 	return result.Points
 }
+
+func TestListReceipts(t *testing.T) {
+
+	data, err := os.ReadFile("testdata/example1.json")
+	if err != nil {
+		t.Fatalf("Failed to read JSON file: %v", err)
+	}
+	uuid := callPost(t, err, string(data))
+
+	resp, err := http.Get("http://localhost:8080/receipts?retailer=target&item=dew&purchasedFrom=2022-01-01&purchasedTo=2022-01-01&limit=100")
+	if err != nil {
+		t.Fatalf("Failed to send GET request: %v, ensure the server is running", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	}
+
+	type receiptPage struct {
+		Receipts []struct {
+			Id string `json:"id"`
+		} `json:"receipts"`
+	}
+
+	var result receiptPage
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	ids := make([]string, 0)
+	for _, receipt := range result.Receipts {
+		ids = append(ids, receipt.Id)
+	}
+	assert.Contains(t, ids, uuid)
+}
+
+func TestCorrectAndDeleteReceipt(t *testing.T) {
+
+	// a unique retailer keeps the receipt from being rejected as a duplicate of an earlier run
+	retailer := fmt.Sprintf("Corner Market %d", time.Now().UnixNano())
+	receipt := `{"retailer": "%s", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "%s",
+		"items": [{"shortDescription": "Gum", "price": "%s"}]}`
+	uuid := callPost(t, nil, fmt.Sprintf(receipt, retailer, "1.25", "1.25"))
+	before := callGet(t, nil, uuid)
+
+	corrected := fmt.Sprintf(receipt, retailer, "2.00", "2.00")
+	resp := send(t, "PUT", "http://localhost:8080/receipts/"+uuid, corrected)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	// the total is now a round dollar amount, which scores 50 additional points
+	assert.Equal(t, before+50, callGet(t, nil, uuid))
+
+	resp = send(t, "DELETE", "http://localhost:8080/receipts/"+uuid, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = send(t, "GET", "http://localhost:8080/receipts/"+uuid+"/points", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = send(t, "GET", "http://localhost:8080/receipts/"+uuid+"/versions", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var result struct {
+		Versions []struct {
+			Version int  `json:"version"`
+			Deleted bool `json:"deleted"`
+		} `json:"versions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if assert.Len(t, result.Versions, 3) {
+		assert.True(t, result.Versions[2].Deleted)
+	}
+}
+
+// send issues a request and returns the response, whose body is closed when the test ends.
+func send(t *testing.T, method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Failed to create %s request: %v", method, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send %s request: %v, ensure the server is running", method, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestExplainPoints(t *testing.T) {
+
+	data, err := os.ReadFile("testdata/example2.json")
+	if err != nil {
+		t.Fatalf("Failed to read JSON file: %v", err)
+	}
+	uuid := callPost(t, err, string(data))
+
+	resp := send(t, "GET", "http://localhost:8080/receipts/"+uuid+"/points?explain=true", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	}
+	var result struct {
+		Points         int    `json:"points"`
+		RuleSetVersion string `json:"ruleSetVersion"`
+		Review         bool   `json:"review"`
+		Breakdown      []struct {
+			Rule   string `json:"rule"`
+			Points int    `json:"points"`
+			Reason string `json:"reason"`
+		} `json:"breakdown"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	assert.Equal(t, 109, result.Points)
+	assert.Equal(t, "1", result.RuleSetVersion)
+	assert.False(t, result.Review)
+	sum := 0
+	for _, rule := range result.Breakdown {
+		assert.NotEmpty(t, rule.Rule)
+		assert.NotEmpty(t, rule.Reason)
+		sum += rule.Points
+	}
+	assert.Equal(t, result.Points, sum)
+}
+
+func TestItemCategories(t *testing.T) {
+
+	// a unique retailer keeps the receipt from being rejected as a duplicate of an earlier run
+	retailer := fmt.Sprintf("Corner Market %d", time.Now().UnixNano())
+	uuid := callPost(t, nil, fmt.Sprintf(`{"retailer": "%s", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"total": "8.49", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49", "category": "dairy"},
+		{"shortDescription": "Gift Card", "price": "2.00"}]}`, retailer))
+
+	resp := send(t, "GET", "http://localhost:8080/receipts/"+uuid, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	}
+	var result struct {
+		Receipt struct {
+			Items []struct {
+				Category *string `json:"category"`
+			} `json:"items"`
+		} `json:"receipt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if assert.Len(t, result.Receipt.Items, 2) && assert.NotNil(t, result.Receipt.Items[0].Category) {
+		// the submitted category is replaced by the one assigned from the description
+		assert.Equal(t, "beverages", *result.Receipt.Items[0].Category)
+		assert.Nil(t, result.Receipt.Items[1].Category)
+	}
+}
+
+func TestExperimentIgnoredWhenSubmitted(t *testing.T) {
+
+	// a unique retailer keeps the receipt from being rejected as a duplicate of an earlier run
+	retailer := fmt.Sprintf("Corner Market %d", time.Now().UnixNano())
+	uuid := callPost(t, nil, fmt.Sprintf(`{"retailer": "%s", "purchaseDate": "2022-01-01", "purchaseTime": "13:01",
+		"total": "6.49", "items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}],
+		"experiment": {"id": "item-pairs-points", "variant": "double"}}`, retailer))
+
+	resp := send(t, "GET", "http://localhost:8080/receipts/"+uuid, "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	}
+	var result struct {
+		Receipt map[string]any `json:"receipt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	// no experiment is running with the built-in rule set
+	assert.NotContains(t, result.Receipt, "experiment")
+}
+
+func TestWhatIf(t *testing.T) {
+
+	data, err := os.ReadFile("testdata/example2.json")
+	if err != nil {
+		t.Fatalf("Failed to read JSON file: %v", err)
+	}
+	const candidate = `{"version": "2", "rules": [{"id": "retailer-name", "type": "retailer-name-length", "points": 2}]}`
+	url := "http://localhost:8080/admin/rules/what-if"
+
+	type score struct {
+		RuleSetVersion string `json:"ruleSetVersion"`
+		Points         int    `json:"points"`
+		Breakdown      []struct {
+			Rule   string `json:"rule"`
+			Points int    `json:"points"`
+		} `json:"breakdown"`
+	}
+	whatIf := func(body string) (score, score, int) {
+		resp := send(t, "POST", url, body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+		}
+		var result struct {
+			Active     score `json:"active"`
+			Candidate  score `json:"candidate"`
+			Difference int   `json:"difference"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to unmarshal response: %v", err)
+		}
+		return result.Active, result.Candidate, result.Difference
+	}
+
+	// M&M Corner Market has 14 alphanumeric characters
+	active, candidateScore, difference := whatIf(`{"receipt": ` + string(data) + `, "ruleSets": ` + candidate + `}`)
+	assert.Equal(t, 109, active.Points)
+	assert.Equal(t, "1", active.RuleSetVersion)
+	assert.Len(t, active.Breakdown, 7)
+	assert.Equal(t, 28, candidateScore.Points)
+	assert.Equal(t, "2", candidateScore.RuleSetVersion)
+	assert.Len(t, candidateScore.Breakdown, 1)
+	assert.Equal(t, -81, difference)
+
+	uuid := callPost(t, nil, string(data))
+	active, candidateScore, _ = whatIf(`{"receiptId": "` + uuid + `", "ruleSets": ` + candidate + `}`)
+	assert.Equal(t, 109, active.Points)
+	assert.Equal(t, 28, candidateScore.Points)
+
+	resp := send(t, "POST", url, `{"receiptId": "unknown", "ruleSets": `+candidate+`}`)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = send(t, "POST", url, `{"ruleSets": `+candidate+`}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = send(t, "POST", url, `{"receiptId": "`+uuid+`", "ruleSets": {"version": "2", "rules": [{"id": "a", "type": "unknown"}]}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `unknown rule type "unknown"`)
+}
+
+func TestWhatIfFraudRules(t *testing.T) {
+
+	const candidate = `{"version": "2", "rules": [{"id": "retailer-name", "type": "retailer-name-length", "points": 2}],
+		"fraudRules": [{"id": "mismatch", "type": "total-mismatch", "forfeit": true}]}`
+	receipt := `{"retailer": "Target", "purchaseDate": "2022-01-01", "purchaseTime": "13:01", "total": "9.99",
+		"items": [{"shortDescription": "Mountain Dew 12PK", "price": "6.49"}]}`
+
+	resp := send(t, "POST", "http://localhost:8080/admin/rules/what-if",
+		`{"receipt": `+receipt+`, "ruleSets": `+candidate+`}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	}
+	var result struct {
+		Candidate struct {
+			Points    int  `json:"points"`
+			Review    bool `json:"review"`
+			Breakdown []struct {
+				Rule       string `json:"rule"`
+				Points     int    `json:"points"`
+				Suspicious bool   `json:"suspicious"`
+			} `json:"breakdown"`
+		} `json:"candidate"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+
+	assert.Equal(t, 0, result.Candidate.Points)
+	assert.True(t, result.Candidate.Review)
+	if assert.Len(t, result.Candidate.Breakdown, 2) {
+		assert.Equal(t, "mismatch", result.Candidate.Breakdown[1].Rule)
+		assert.Equal(t, -12, result.Candidate.Breakdown[1].Points)
+		assert.True(t, result.Candidate.Breakdown[1].Suspicious)
+	}
+}
+
+func TestRescore(t *testing.T) {
+
+	data, err := os.ReadFile("testdata/example1.json")
+	if err != nil {
+		t.Fatalf("Failed to read JSON file: %v", err)
+	}
+	callPost(t, err, string(data))
+
+	resp := send(t, "GET", "http://localhost:8080/admin/rescore", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 OK, got %d", resp.StatusCode)
+	}
+	var report struct {
+		From            string `json:"from"`
+		To              string `json:"to"`
+		Receipts        int    `json:"receipts"`
+		ChangedReceipts int    `json:"changedReceipts"`
+		FromPoints      int    `json:"fromPoints"`
+		ToPoints        int    `json:"toPoints"`
+		Rules           []struct {
+			Rule string `json:"rule"`
+		} `json:"rules"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	// the built-in rule set is compared with itself
+	assert.Equal(t, "1", report.From)
+	assert.Equal(t, "1", report.To)
+	assert.Positive(t, report.Receipts)
+	assert.Zero(t, report.ChangedReceipts)
+	assert.Equal(t, report.FromPoints, report.ToPoints)
+	assert.Len(t, report.Rules, 7)
+
+	resp = send(t, "GET", "http://localhost:8080/admin/rescore?format=csv&top=5", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "kind,id,from_points,to_points,delta,changed_receipts\ntotal,1 -> 1,")
+
+	resp = send(t, "GET", "http://localhost:8080/admin/rescore?to=unknown", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = send(t, "GET", "http://localhost:8080/admin/rescore?top=-1", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
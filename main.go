@@ -1,33 +1,193 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fetch-assessment/calculator"
+	"fetch-assessment/categorize"
 	"fetch-assessment/handlers"
+	"fetch-assessment/idempotency"
 	"fetch-assessment/store"
+	"fetch-assessment/store/sqlstore"
+	"flag"
+	"fmt"
 	mux2 "github.com/gorilla/mux"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 const serverPort = ":8080"
 
 func main() {
 
+	var cfg storeConfig
+	flag.StringVar(&cfg.storeType, "store", "memory", "receipt storage backend: memory, file or sqlite")
+	flag.StringVar(&cfg.dataDir, "data-dir", "data", "directory used by the file storage backend")
+	flag.IntVar(&cfg.snapshotInterval, "snapshot-interval", store.DefaultSnapshotInterval, "number of log records after which the file storage backend takes a snapshot")
+	flag.StringVar(&cfg.databasePath, "database", "receipts.db", "database file used by the sqlite storage backend")
+	rulesPath := flag.String("rules", "", "YAML or JSON file defining the points rule set; the built-in rule set is used if empty")
+	holidaysPath := flag.String("holidays", "", "YAML or JSON file defining the holiday calendars used by calendar rules")
+	categoriesPath := flag.String("categories", "", "YAML or JSON file defining the product categories of items; the built-in categories are used if empty")
+	idempotencyWindow := flag.Duration("idempotency-window", idempotency.DefaultWindow, "duration for which responses to requests with an Idempotency-Key header are replayed")
+	flag.Parse()
+
+	if *holidaysPath != "" {
+		holidays, err := calculator.LoadHolidayCalendars(*holidaysPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		calculator.ActivateHolidayCalendars(holidays)
+		log.Printf("Using holiday calendars %s", strings.Join(holidays.Names(), ", "))
+	}
+
+	ruleSets, err := calculator.LoadRuleSetsOrDefault(*rulesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	calculator.Activate(ruleSets)
+	log.Printf("Using rule set version %s", calculator.RuleSetVersion())
+
+	categorizer, err := categorize.LoadOrDefault(*categoriesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	categorize.Activate(categorizer)
+
+	switch flag.Arg(0) {
+	case "":
+	case "rescore":
+		// the report only reads receipts, and must not compact the log of a server using the same data directory
+		cfg.readOnly = true
+		receiptStore, err := newReceiptRepository(cfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runRescore(receiptStore, flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		closeReceiptRepository(receiptStore)
+		return
+	default:
+		log.Fatalf("unknown command %q", flag.Arg(0))
+	}
+
+	receiptStore, err := newReceiptRepository(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	go reloadRulesOnSignal(*rulesPath)
+
 	mux := mux2.NewRouter()
 
 	const serverURL = "localhost" + serverPort
 
-	receiptStore := store.NewReceiptStore()
-	mux.HandleFunc("/receipts/process", func(w http.ResponseWriter, r *http.Request) {
+	idempotencyCache := idempotency.NewCache(*idempotencyWindow)
+	mux.HandleFunc("/receipts/process", idempotencyCache.Middleware(func(w http.ResponseWriter, r *http.Request) {
 		handlers.StoreReceiptHandler(w, r, receiptStore)
-	}).Methods("POST")
+	})).Methods("POST")
+	mux.HandleFunc("/receipts", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListReceiptsHandler(w, r, receiptStore)
+	}).Methods("GET")
+	mux.HandleFunc("/receipts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetReceiptHandler(w, r, receiptStore)
+	}).Methods("GET")
+	mux.HandleFunc("/receipts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateReceiptHandler(w, r, receiptStore)
+	}).Methods("PUT")
+	mux.HandleFunc("/receipts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteReceiptHandler(w, r, receiptStore)
+	}).Methods("DELETE")
+	mux.HandleFunc("/receipts/{id}/versions", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListReceiptVersionsHandler(w, r, receiptStore)
+	}).Methods("GET")
+	mux.HandleFunc("/receipts/{id}/versions/{version}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetReceiptVersionHandler(w, r, receiptStore)
+	}).Methods("GET")
 	mux.HandleFunc("/receipts/{id}/points", func(w http.ResponseWriter, r *http.Request) {
 		handlers.GetPointsHandler(w, r, receiptStore)
 	}).Methods("GET")
+	mux.HandleFunc("/admin/rules/reload", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ReloadRulesHandler(w, r, *rulesPath)
+	}).Methods("POST")
+	mux.HandleFunc("/admin/rules/what-if", func(w http.ResponseWriter, r *http.Request) {
+		handlers.WhatIfHandler(w, r, receiptStore)
+	}).Methods("POST")
+	mux.HandleFunc("/admin/rescore", func(w http.ResponseWriter, r *http.Request) {
+		handlers.RescoreHandler(w, r, receiptStore)
+	}).Methods("GET")
 
-	err := http.ListenAndServe(serverPort, mux)
-	if err != nil {
+	server := &http.Server{Addr: serverPort, Handler: mux}
+	go shutdownOnSignal(server)
+
+	log.Printf("Server starting on %s", serverURL)
+	err = server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 
-	log.Printf("Server started on %s", serverURL)
+	closeReceiptRepository(receiptStore)
+}
+
+type storeConfig struct {
+	storeType        string
+	dataDir          string
+	snapshotInterval int
+	databasePath     string
+	// readOnly opens the file storage backend without ever changing its files.
+	readOnly bool
+}
+
+func newReceiptRepository(cfg storeConfig) (store.ReceiptRepository, error) {
+	switch cfg.storeType {
+	case "memory":
+		return store.NewReceiptStore(), nil
+	case "file":
+		if cfg.readOnly {
+			return store.NewReadOnlyFileReceiptStore(cfg.dataDir)
+		}
+		return store.NewFileReceiptStore(cfg.dataDir, cfg.snapshotInterval)
+	case "sqlite":
+		return sqlstore.NewSQLReceiptStore(cfg.databasePath)
+	default:
+		return nil, fmt.Errorf("unknown store type %q", cfg.storeType)
+	}
+}
+
+func closeReceiptRepository(receiptStore store.ReceiptRepository) {
+	if closer, ok := receiptStore.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// shutdownOnSignal stops the server on SIGINT or SIGTERM, so that the receipt store can be closed cleanly.
+func shutdownOnSignal(server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	if err := server.Shutdown(context.Background()); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+}
+
+// reloadRulesOnSignal reloads the rule set from rulesPath on SIGHUP. An invalid rule set is logged and the active one
+// kept.
+func reloadRulesOnSignal(rulesPath string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		ruleSets, err := calculator.ReloadRuleSets(rulesPath)
+		if err != nil {
+			log.Printf("Keeping rule set version %s, reloading failed: %v", calculator.RuleSetVersion(), err)
+			continue
+		}
+		log.Printf("Activated rule set version %s", ruleSets.Latest().Version())
+	}
 }
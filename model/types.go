@@ -1,14 +1,34 @@
 // Package model provides primitives to interact with the openapi HTTP API.
 //
-// Code generated by github.com/deepmap/oapi-codegen version v1.16.3 DO NOT EDIT.
+// Code generated by github.com/oapi-codegen/oapi-codegen/v2 version v2.4.1 DO NOT EDIT.
 package model
 
 import (
+	"time"
+
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
 
+// Defines values for GetAdminRescoreParamsFormat.
+const (
+	Csv  GetAdminRescoreParamsFormat = "csv"
+	Json GetAdminRescoreParamsFormat = "json"
+)
+
+// ExperimentAssignment The variant of a rule experiment the receipt was assigned when it was submitted. It is absent if no experiment was running, and ignored when submitted.
+type ExperimentAssignment struct {
+	// Id The id of the experiment.
+	Id string `json:"id"`
+
+	// Variant The name of the variant the receipt is scored by.
+	Variant string `json:"variant"`
+}
+
 // Item defines model for Item.
 type Item struct {
+	// Category The product category the item was assigned from its description when the receipt was stored. It is absent if no category matches, and ignored when submitted.
+	Category *string `json:"category,omitempty"`
+
 	// Price The total price payed for this item.
 	Price string `json:"price"`
 
@@ -18,7 +38,9 @@ type Item struct {
 
 // Receipt defines model for Receipt.
 type Receipt struct {
-	Items []Item `json:"items"`
+	// Experiment The variant of a rule experiment the receipt was assigned when it was submitted. It is absent if no experiment was running, and ignored when submitted.
+	Experiment *ExperimentAssignment `json:"experiment,omitempty"`
+	Items      []Item                `json:"items"`
 
 	// PurchaseDate The date of the purchase printed on the receipt.
 	PurchaseDate openapi_types.Date `json:"purchaseDate"`
@@ -29,9 +51,237 @@ type Receipt struct {
 	// Retailer The name of the retailer or store the receipt is from.
 	Retailer string `json:"retailer"`
 
+	// TimeZone The time zone of the purchase date and time, either an IANA time zone name or a UTC offset in the format +HH:MM or -HH:MM. Without it, the date and time are taken as printed, in whatever zone the retailer is in.
+	TimeZone *string `json:"timeZone,omitempty"`
+
 	// Total The total amount paid on the receipt.
 	Total string `json:"total"`
 }
 
+// ReceiptDelta defines model for ReceiptDelta.
+type ReceiptDelta struct {
+	Delta      int    `json:"delta"`
+	FromPoints int    `json:"fromPoints"`
+	Id         string `json:"id"`
+	ToPoints   int    `json:"toPoints"`
+}
+
+// ReceiptPage defines model for ReceiptPage.
+type ReceiptPage struct {
+	// NextCursor Requests the next page when passed as the cursor parameter. Absent on the last page.
+	NextCursor *string         `json:"nextCursor,omitempty"`
+	Receipts   []StoredReceipt `json:"receipts"`
+}
+
+// ReceiptVersions defines model for ReceiptVersions.
+type ReceiptVersions struct {
+	Versions []StoredReceipt `json:"versions"`
+}
+
+// RescoreReport defines model for RescoreReport.
+type RescoreReport struct {
+	// ChangedReceipts The number of receipts whose points change.
+	ChangedReceipts int `json:"changedReceipts"`
+	Delta           int `json:"delta"`
+
+	// From The version compared from.
+	From string `json:"from"`
+
+	// FromPoints The points of all receipts under the from version.
+	FromPoints int `json:"fromPoints"`
+
+	// LargestChanges The receipts with the largest changes, largest first.
+	LargestChanges []ReceiptDelta `json:"largestChanges"`
+
+	// Receipts The number of receipts scored.
+	Receipts int `json:"receipts"`
+
+	// Rules The points awarded by every rule of either version, every campaign and every fraud rule. A rule missing in a version awards none.
+	Rules []RuleDelta `json:"rules"`
+
+	// To The version compared to.
+	To string `json:"to"`
+
+	// ToPoints The points of all receipts under the to version.
+	ToPoints int `json:"toPoints"`
+
+	// Variants The points of the receipts assigned a variant of an experiment, by variant. They are included in the totals, scored by the variant under the version the experiment is on and by the plain rule set under any other.
+	Variants []VariantDelta `json:"variants"`
+}
+
+// RuleDelta defines model for RuleDelta.
+type RuleDelta struct {
+	// ChangedReceipts The number of receipts for which the rule awards different points.
+	ChangedReceipts int    `json:"changedReceipts"`
+	Delta           int    `json:"delta"`
+	FromPoints      int    `json:"fromPoints"`
+	Rule            string `json:"rule"`
+	ToPoints        int    `json:"toPoints"`
+}
+
+// RulePoints defines model for RulePoints.
+type RulePoints struct {
+	// Campaign Whether the entry is a campaign awarding points on top of the rules, in which case rule is the campaign id.
+	Campaign *bool `json:"campaign,omitempty"`
+
+	// Capped Whether a maximum clipped the points, either of the rule or, with rule maxPoints, of the receipt.
+	Capped *bool `json:"capped,omitempty"`
+
+	// Points The points awarded by the rule.
+	Points int `json:"points"`
+
+	// Reason Why the rule awarded the points, or none.
+	Reason string `json:"reason"`
+
+	// Rule The stable name of the rule.
+	Rule string `json:"rule"`
+
+	// Suspicious Whether the fraud rule found the receipt suspicious, in which case the points are subtracted.
+	Suspicious *bool `json:"suspicious,omitempty"`
+}
+
+// Score defines model for Score.
+type Score struct {
+	// Breakdown The points awarded by each rule, in the order the rules are applied.
+	Breakdown []RulePoints `json:"breakdown"`
+	Points    int          `json:"points"`
+
+	// Review Whether a fraud rule marked the receipt for manual review.
+	Review bool `json:"review"`
+
+	// RuleSetVersion The version of the rule set the receipt was scored by.
+	RuleSetVersion string `json:"ruleSetVersion"`
+}
+
+// StoredReceipt defines model for StoredReceipt.
+type StoredReceipt struct {
+	// Deleted Marks the version recording the deletion of the receipt. It holds the receipt as last corrected.
+	Deleted *bool `json:"deleted,omitempty"`
+
+	// Id The ID assigned to the receipt.
+	Id      string  `json:"id"`
+	Receipt Receipt `json:"receipt"`
+
+	// ReceivedAt The time the receipt was first submitted.
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	// RuleSetVersion The version of the rule set the receipt is scored by, the one in force at its purchase date or submission time.
+	RuleSetVersion string `json:"ruleSetVersion"`
+
+	// UpdatedAt The time this version was recorded.
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Version The version of the receipt, starting at 1 and incremented by every correction.
+	Version int `json:"version"`
+}
+
+// VariantDelta defines model for VariantDelta.
+type VariantDelta struct {
+	// ChangedReceipts The number of receipts assigned the variant whose points change.
+	ChangedReceipts int    `json:"changedReceipts"`
+	Delta           int    `json:"delta"`
+	Experiment      string `json:"experiment"`
+	FromPoints      int    `json:"fromPoints"`
+
+	// Receipts The number of receipts assigned the variant.
+	Receipts int    `json:"receipts"`
+	ToPoints int    `json:"toPoints"`
+	Variant  string `json:"variant"`
+}
+
+// WhatIfRequest defines model for WhatIfRequest.
+type WhatIfRequest struct {
+	Receipt *Receipt `json:"receipt,omitempty"`
+
+	// ReceiptId The ID of a stored receipt to score. Either receiptId or receipt must be given.
+	ReceiptId *string `json:"receiptId,omitempty"`
+
+	// RuleSets The candidate rule sets, in the format of the rules file.
+	RuleSets map[string]interface{} `json:"ruleSets"`
+}
+
+// WhatIfResult defines model for WhatIfResult.
+type WhatIfResult struct {
+	Active    Score `json:"active"`
+	Candidate Score `json:"candidate"`
+
+	// Difference The candidate points minus the active points.
+	Difference int `json:"difference"`
+}
+
+// GetAdminRescoreParams defines parameters for GetAdminRescore.
+type GetAdminRescoreParams struct {
+	// From The version to compare from. Defaults to the version in force now.
+	From *string `form:"from,omitempty" json:"from,omitempty"`
+
+	// To The version to compare to. Defaults to the version with the latest effective date.
+	To *string `form:"to,omitempty" json:"to,omitempty"`
+
+	// Top The number of receipts with the largest changes to list.
+	Top *int `form:"top,omitempty" json:"top,omitempty"`
+
+	// Format The format of the report. CSV has the columns kind, id, from_points, to_points, delta and changed_receipts,
+	// with a row of kind total, a row of kind rule for every rule and a row of kind receipt for every listed
+	// receipt.
+	Format *GetAdminRescoreParamsFormat `form:"format,omitempty" json:"format,omitempty"`
+}
+
+// GetAdminRescoreParamsFormat defines parameters for GetAdminRescore.
+type GetAdminRescoreParamsFormat string
+
+// PostAdminRulesWhatIfParams defines parameters for PostAdminRulesWhatIf.
+type PostAdminRulesWhatIfParams struct {
+	// XUserID Identifies the submitting user of an inline receipt, for experiments that assign variants by user.
+	XUserID *string `json:"X-User-ID,omitempty"`
+}
+
+// GetReceiptsParams defines parameters for GetReceipts.
+type GetReceiptsParams struct {
+	// Retailer Only receipts of this retailer. Names are compared ignoring case, whitespace and punctuation.
+	Retailer *string `form:"retailer,omitempty" json:"retailer,omitempty"`
+
+	// PurchasedFrom Only receipts purchased on or after this date.
+	PurchasedFrom *openapi_types.Date `form:"purchasedFrom,omitempty" json:"purchasedFrom,omitempty"`
+
+	// PurchasedTo Only receipts purchased on or before this date.
+	PurchasedTo *openapi_types.Date `form:"purchasedTo,omitempty" json:"purchasedTo,omitempty"`
+
+	// MinTotal Only receipts with a total of at least this amount.
+	MinTotal *string `form:"minTotal,omitempty" json:"minTotal,omitempty"`
+
+	// MaxTotal Only receipts with a total of at most this amount.
+	MaxTotal *string `form:"maxTotal,omitempty" json:"maxTotal,omitempty"`
+
+	// Item Only receipts with an item whose short description contains this text, ignoring case.
+	Item *string `form:"item,omitempty" json:"item,omitempty"`
+
+	// Cursor The nextCursor of the previous page.
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+
+	// Limit The maximum number of receipts per page.
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+}
+
+// PostReceiptsProcessParams defines parameters for PostReceiptsProcess.
+type PostReceiptsProcessParams struct {
+	// IdempotencyKey A unique key chosen by the client, allowing the request to be retried safely.
+	IdempotencyKey *string `json:"Idempotency-Key,omitempty"`
+
+	// XUserID Identifies the submitting user, for experiments that assign variants by user.
+	XUserID *string `json:"X-User-ID,omitempty"`
+}
+
+// GetReceiptsIdPointsParams defines parameters for GetReceiptsIdPoints.
+type GetReceiptsIdPointsParams struct {
+	// Explain Include the points awarded by each rule.
+	Explain *bool `form:"explain,omitempty" json:"explain,omitempty"`
+}
+
+// PostAdminRulesWhatIfJSONRequestBody defines body for PostAdminRulesWhatIf for application/json ContentType.
+type PostAdminRulesWhatIfJSONRequestBody = WhatIfRequest
+
 // PostReceiptsProcessJSONRequestBody defines body for PostReceiptsProcess for application/json ContentType.
 type PostReceiptsProcessJSONRequestBody = Receipt
+
+// PutReceiptsIdJSONRequestBody defines body for PutReceiptsId for application/json ContentType.
+type PutReceiptsIdJSONRequestBody = Receipt
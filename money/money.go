@@ -0,0 +1,131 @@
+// Package money provides exact arithmetic on amounts of money. Amounts are held in integer cents, so that they are not
+// exposed to the rounding errors of binary floating point.
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidAmount = errors.New("amount must be in the format 0.00")
+
+// maxDollars is the largest number of dollars whose amount in cents fits an Amount.
+const maxDollars = (math.MaxInt64 - 99) / 100
+
+// Amount is an amount of money in cents. It is never negative.
+type Amount int64
+
+// Parse converts an amount in the format of receipt totals and item prices, such as "6.49", into an Amount. It returns
+// ErrInvalidAmount unless the amount consists of dollars, a period and exactly two digits of cents.
+func Parse(amount string) (Amount, error) {
+	dollars, cents, ok := strings.Cut(amount, ".")
+	if !ok || len(cents) != 2 || !isDigits(dollars) || !isDigits(cents) {
+		return 0, ErrInvalidAmount
+	}
+	d, err := strconv.ParseInt(dollars, 10, 64)
+	if err != nil || d > maxDollars {
+		return 0, fmt.Errorf("%w: %s is out of range", ErrInvalidAmount, amount)
+	}
+	c, _ := strconv.ParseInt(cents, 10, 64)
+	return Amount(d*100 + c), nil
+}
+
+// FromDecimal converts a decimal number of dollars, such as 0.25 read from a configuration file, into an Amount. It
+// fails unless the number is a whole number of cents. The number is taken to be the shortest decimal that converts to
+// it, so 0.1 is ten cents even though the float64 nearest to 0.1 is not exactly one tenth.
+func FromDecimal(dollars float64) (Amount, error) {
+	factor, err := FactorOf(dollars)
+	if err != nil {
+		return 0, err
+	}
+	cents := new(big.Rat).Mul(factor.rat, big.NewRat(100, 1))
+	if !cents.IsInt() || !cents.Num().IsInt64() {
+		return 0, fmt.Errorf("%v is not a whole number of cents", dollars)
+	}
+	return Amount(cents.Num().Int64()), nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Cents returns the amount in cents.
+func (a Amount) Cents() int64 {
+	return int64(a)
+}
+
+// String formats the amount like Parse expects it, for example "6.49".
+func (a Amount) String() string {
+	return fmt.Sprintf("%d.%02d", a/100, a%100)
+}
+
+// IsMultipleOf reports whether the amount is a whole multiple of m, which must be positive.
+func (a Amount) IsMultipleOf(m Amount) bool {
+	return a%m == 0
+}
+
+// MulCeil multiplies the amount in dollars by the factor and rounds the result up to a whole number.
+func (a Amount) MulCeil(factor Factor) int64 {
+	product := new(big.Rat).Mul(big.NewRat(int64(a), 100), factor.rat)
+	quotient, remainder := new(big.Int).QuoRem(product.Num(), product.Denom(), new(big.Int))
+	if remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient.Int64()
+}
+
+// Factor is an exact, non-negative decimal number that amounts are multiplied by.
+type Factor struct {
+	rat *big.Rat
+}
+
+// ParseFactor parses a decimal number such as "0.2".
+func ParseFactor(s string) (Factor, error) {
+	rat, ok := new(big.Rat).SetString(s)
+	if !ok || strings.ContainsAny(s, "/eE") {
+		return Factor{}, fmt.Errorf("%q is not a decimal number", s)
+	}
+	if rat.Sign() < 0 {
+		return Factor{}, fmt.Errorf("%s must not be negative", s)
+	}
+	return Factor{rat: rat}, nil
+}
+
+// FactorOf converts a number, such as 0.2 read from a configuration file, into a Factor. Like FromDecimal, it takes
+// the shortest decimal that converts to the number.
+func FactorOf(f float64) (Factor, error) {
+	return ParseFactor(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// MulFloor multiplies n by the factor and rounds the result down to a whole number.
+func (f Factor) MulFloor(n int64) int64 {
+	product := new(big.Rat).Mul(big.NewRat(n, 1), f.rat)
+	// Div rounds towards negative infinity, as the denominator is positive
+	return new(big.Int).Div(product.Num(), product.Denom()).Int64()
+}
+
+// Cmp compares the factor with the integer n, returning -1, 0 or 1.
+func (f Factor) Cmp(n int64) int {
+	return f.rat.Cmp(big.NewRat(n, 1))
+}
+
+// String formats the factor as a decimal number.
+func (f Factor) String() string {
+	if f.rat == nil {
+		return "0"
+	}
+	decimals, _ := f.rat.FloatPrec()
+	return f.rat.FloatString(decimals)
+}
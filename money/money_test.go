@@ -0,0 +1,175 @@
+package money
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   Amount
+		ok     bool
+	}{
+		{"0.00", 0, true},
+		{"0.01", 1, true},
+		{"35.35", 3535, true},
+		{"00.10", 10, true},
+		{"92233720368547757.99", 9223372036854775799, true},
+		{"92233720368547758.00", 0, false},
+		{"35", 0, false},
+		{"35.3", 0, false},
+		{"35.355", 0, false},
+		{".35", 0, false},
+		{"-1.00", 0, false},
+		{"+1.00", 0, false},
+		{"1.-1", 0, false},
+		{"1.+1", 0, false},
+		{"1 .00", 0, false},
+		{"1_0.00", 0, false},
+		{"١.٠٠", 0, false},
+		{"invalid", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.amount, func(t *testing.T) {
+			amount, err := Parse(tc.amount)
+			if !tc.ok {
+				assert.ErrorIs(t, err, ErrInvalidAmount)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, amount)
+		})
+	}
+}
+
+func TestParseEveryCentValue(t *testing.T) {
+	for cents := int64(0); cents < 100_000; cents++ {
+		formatted := fmt.Sprintf("%d.%02d", cents/100, cents%100)
+		amount, err := Parse(formatted)
+		require.NoError(t, err, formatted)
+		require.Equal(t, cents, amount.Cents(), formatted)
+		require.Equal(t, formatted, amount.String())
+	}
+}
+
+func TestIsMultipleOf(t *testing.T) {
+	for _, multiple := range []Amount{1, 5, 10, 25, 50, 100, 250} {
+		for cents := Amount(0); cents < 100_000; cents++ {
+			require.Equal(t, cents%multiple == 0, cents.IsMultipleOf(multiple), "%s multiple of %s", cents, multiple)
+		}
+	}
+}
+
+func TestMulCeil(t *testing.T) {
+	tests := []struct {
+		factor string
+		// want returns the expected result for an amount in cents, computed in integer arithmetic.
+		want func(cents int64) int64
+	}{
+		{"0.2", func(cents int64) int64 { return (cents*2 + 999) / 1000 }},
+		{"0.25", func(cents int64) int64 { return (cents*25 + 9999) / 10000 }},
+		{"1", func(cents int64) int64 { return (cents + 99) / 100 }},
+		{"0.1", func(cents int64) int64 { return (cents + 999) / 1000 }},
+		{"1.5", func(cents int64) int64 { return (cents*15 + 999) / 1000 }},
+		{"0", func(int64) int64 { return 0 }},
+	}
+	for _, tc := range tests {
+		t.Run(tc.factor, func(t *testing.T) {
+			factor, err := ParseFactor(tc.factor)
+			require.NoError(t, err)
+			for cents := int64(0); cents < 100_000; cents++ {
+				require.Equal(t, tc.want(cents), Amount(cents).MulCeil(factor), "%s times %s", Amount(cents), factor)
+			}
+		})
+	}
+
+	t.Run("large amounts", func(t *testing.T) {
+		factor, err := FactorOf(0.2)
+		require.NoError(t, err)
+		amount, err := Parse("92233720368547757.99")
+		require.NoError(t, err)
+		assert.Equal(t, int64(18446744073709552), amount.MulCeil(factor))
+	})
+}
+
+func TestFromDecimal(t *testing.T) {
+	tests := []struct {
+		dollars float64
+		want    Amount
+		ok      bool
+	}{
+		{0.25, 25, true},
+		{1, 100, true},
+		{0.1, 10, true},
+		{0.07, 7, true},
+		{1.15, 115, true},
+		{0, 0, true},
+		{0.001, 0, false},
+		{-1, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(fmt.Sprint(tc.dollars), func(t *testing.T) {
+			amount, err := FromDecimal(tc.dollars)
+			if !tc.ok {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, amount)
+		})
+	}
+
+	for cents := int64(0); cents < 100_000; cents++ {
+		dollars := float64(cents) / 100
+		amount, err := FromDecimal(dollars)
+		require.NoError(t, err, dollars)
+		require.Equal(t, cents, amount.Cents(), dollars)
+	}
+}
+
+func TestParseFactor(t *testing.T) {
+	factor, err := ParseFactor("0.20")
+	require.NoError(t, err)
+	assert.Equal(t, "0.2", factor.String())
+
+	factor, err = FactorOf(0.2)
+	require.NoError(t, err)
+	assert.Equal(t, "0.2", factor.String())
+
+	for _, invalid := range []string{"", "abc", "1/5", "2e-1", "-0.2"} {
+		_, err := ParseFactor(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFactorMulFloor(t *testing.T) {
+	tests := []struct {
+		factor string
+		n      int64
+		want   int64
+	}{
+		{"2", 28, 56},
+		{"1.5", 7, 10},
+		{"1.5", -7, -11},
+		{"0.2", 4, 0},
+		{"0.2", 5, 1},
+		{"3", 0, 0},
+	}
+	for _, tc := range tests {
+		factor, err := ParseFactor(tc.factor)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, factor.MulFloor(tc.n), "%d times %s", tc.n, tc.factor)
+	}
+
+	factor, err := ParseFactor("1.5")
+	require.NoError(t, err)
+	assert.Equal(t, 1, factor.Cmp(1))
+	assert.Equal(t, -1, factor.Cmp(2))
+	factor, err = ParseFactor("2.0")
+	require.NoError(t, err)
+	assert.Equal(t, 0, factor.Cmp(2))
+}
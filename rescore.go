@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fetch-assessment/calculator"
+	"fetch-assessment/rescore"
+	"fetch-assessment/store"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// runRescore implements the rescore command, which writes a CSV report comparing the points of all stored receipts
+// under two versions of the rule sets.
+func runRescore(receiptStore store.ReceiptRepository, args []string) error {
+	flags := flag.NewFlagSet("rescore", flag.ExitOnError)
+	from := flags.String("from", "", "rule set version to compare from; the version in force now if empty")
+	to := flags.String("to", "", "rule set version to compare to; the version with the latest effective date if empty")
+	top := flags.Int("top", rescore.DefaultTop, "number of receipts with the largest changes to list")
+	out := flags.String("out", "", "file the CSV report is written to; standard output if empty")
+	flags.Parse(args)
+
+	ruleSets := calculator.ActiveRuleSets()
+	fromRegistry, toRegistry, err := rescore.Resolve(ruleSets, *from, *to, time.Now())
+	if err != nil {
+		return err
+	}
+	receipts, err := receiptStore.List()
+	if err != nil {
+		return err
+	}
+	report := rescore.Compare(ruleSets, receipts, fromRegistry, toRegistry, max(*top, 0))
+	log.Printf("Rescored %d receipts from rule set version %s to %s, %d changed", report.Receipts, report.From,
+		report.To, report.ChangedReceipts)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return report.WriteCSV(w)
+}
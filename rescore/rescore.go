@@ -0,0 +1,236 @@
+// Package rescore scores all stored receipts under two versions of the rule set, to show the effect of a rule change
+// before it takes effect.
+package rescore
+
+import (
+	"encoding/csv"
+	"errors"
+	"fetch-assessment/calculator"
+	"fetch-assessment/model"
+	"fetch-assessment/store"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// DefaultTop is the default number of receipts with the largest changes listed in a report.
+const DefaultTop = 10
+
+var ErrUnknownVersion = errors.New("unknown rule set version")
+
+// Report summarizes how the points of the stored receipts change from one rule set version to another.
+type Report struct {
+	From string
+	To   string
+	// Receipts is the number of receipts scored, and ChangedReceipts the number of them whose points change.
+	Receipts        int
+	ChangedReceipts int
+	FromPoints      int
+	ToPoints        int
+	// Rules holds the points awarded by each rule of either version, in the order of the rules of From followed by
+	// the rules only in To, then the campaigns, and then the fraud rules in the same order as the rules.
+	Rules []RuleDelta
+	// LargestChanges holds the receipts with the largest changes, largest first.
+	LargestChanges []ReceiptDelta
+	// Variants holds the points of the receipts assigned a variant of an experiment, ordered by experiment and
+	// variant. These receipts are included in the totals, scored by their variant under the version the experiment is
+	// on and by the plain rule set under any other, so their change is not that of the rule set alone.
+	Variants []VariantDelta
+}
+
+// RuleDelta is the sum of the points awarded by a rule under both versions. A rule missing in a version awards none.
+// The points are capped at the maximum of the rule, but not limited to the minimum and maximum points of the receipt.
+type RuleDelta struct {
+	Rule       string
+	FromPoints int
+	ToPoints   int
+	// ChangedReceipts is the number of receipts for which the rule awards different points.
+	ChangedReceipts int
+}
+
+// ReceiptDelta is the points of a receipt under both versions.
+type ReceiptDelta struct {
+	ID         string
+	FromPoints int
+	ToPoints   int
+}
+
+// VariantDelta is the sum of the points of the receipts assigned a variant under both versions.
+type VariantDelta struct {
+	Experiment string
+	Variant    string
+	// Receipts is the number of receipts assigned the variant, and ChangedReceipts the number of them whose points
+	// change.
+	Receipts        int
+	ChangedReceipts int
+	FromPoints      int
+	ToPoints        int
+}
+
+func (r Report) Delta() int {
+	return r.ToPoints - r.FromPoints
+}
+
+func (d RuleDelta) Delta() int {
+	return d.ToPoints - d.FromPoints
+}
+
+func (d ReceiptDelta) Delta() int {
+	return d.ToPoints - d.FromPoints
+}
+
+func (d VariantDelta) Delta() int {
+	return d.ToPoints - d.FromPoints
+}
+
+// Resolve looks up the versions to compare in the rule sets. from defaults to the version in force at now, and to to
+// the version with the latest effective date. Unknown versions are reported as ErrUnknownVersion.
+func Resolve(ruleSets *calculator.RuleSets, from, to string, now time.Time) (*calculator.Registry, *calculator.Registry, error) {
+	lookup := func(version string, fallback *calculator.Registry) (*calculator.Registry, error) {
+		if version == "" {
+			return fallback, nil
+		}
+		registry, ok := ruleSets.Version(version)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownVersion, version)
+		}
+		return registry, nil
+	}
+	fromRegistry, err := lookup(from, ruleSets.At(now))
+	if err != nil {
+		return nil, nil, err
+	}
+	toRegistry, err := lookup(to, ruleSets.Latest())
+	if err != nil {
+		return nil, nil, err
+	}
+	return fromRegistry, toRegistry, nil
+}
+
+// Compare scores every receipt under both versions of the rule sets, regardless of the version in force for it, and
+// reports the differences, listing up to top receipts with the largest changes. Receipts are scored like
+// RuleSets.Score, including the campaigns of the rule sets.
+func Compare(ruleSets *calculator.RuleSets, receipts []store.StoredReceipt, from, to *calculator.Registry, top int) Report {
+	report := Report{From: from.Version(), To: to.Version(), Receipts: len(receipts)}
+	seen := make(map[string]bool)
+	for _, rule := range append(from.Rules(), to.Rules()...) {
+		if !seen[rule.ID] {
+			seen[rule.ID] = true
+			report.Rules = append(report.Rules, RuleDelta{Rule: rule.ID})
+		}
+	}
+	for _, campaign := range ruleSets.Campaigns() {
+		if !seen[campaign.ID] {
+			seen[campaign.ID] = true
+			report.Rules = append(report.Rules, RuleDelta{Rule: campaign.ID})
+		}
+	}
+	for _, rule := range append(from.FraudRules(), to.FraudRules()...) {
+		if !seen[rule.ID] {
+			seen[rule.ID] = true
+			report.Rules = append(report.Rules, RuleDelta{Rule: rule.ID})
+		}
+	}
+	rules := make(map[string]*RuleDelta, len(report.Rules))
+	for i := range report.Rules {
+		rules[report.Rules[i].Rule] = &report.Rules[i]
+	}
+
+	changes := make([]ReceiptDelta, 0)
+	variants := make(map[model.ExperimentAssignment]*VariantDelta)
+	for _, stored := range receipts {
+		fromPoints, fromRules := score(ruleSets, from, stored)
+		toPoints, toRules := score(ruleSets, to, stored)
+		report.FromPoints += fromPoints
+		report.ToPoints += toPoints
+		if fromPoints != toPoints {
+			report.ChangedReceipts++
+			changes = append(changes, ReceiptDelta{ID: stored.ID, FromPoints: fromPoints, ToPoints: toPoints})
+		}
+		for id, delta := range rules {
+			delta.FromPoints += fromRules[id]
+			delta.ToPoints += toRules[id]
+			if fromRules[id] != toRules[id] {
+				delta.ChangedReceipts++
+			}
+		}
+		if assignment := stored.Receipt.Experiment; assignment != nil {
+			delta, ok := variants[*assignment]
+			if !ok {
+				delta = &VariantDelta{Experiment: assignment.Id, Variant: assignment.Variant}
+				variants[*assignment] = delta
+			}
+			delta.Receipts++
+			delta.FromPoints += fromPoints
+			delta.ToPoints += toPoints
+			if fromPoints != toPoints {
+				delta.ChangedReceipts++
+			}
+		}
+	}
+	report.Variants = make([]VariantDelta, 0, len(variants))
+	for _, delta := range variants {
+		report.Variants = append(report.Variants, *delta)
+	}
+	sort.Slice(report.Variants, func(i, j int) bool {
+		a, b := report.Variants[i], report.Variants[j]
+		if a.Experiment != b.Experiment {
+			return a.Experiment < b.Experiment
+		}
+		return a.Variant < b.Variant
+	})
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := abs(changes[i].Delta()), abs(changes[j].Delta())
+		if a != b {
+			return a > b
+		}
+		return changes[i].ID < changes[j].ID
+	})
+	report.LargestChanges = changes[:min(top, len(changes))]
+	return report
+}
+
+// score scores the receipt by the registry and returns the total points and the points by rule.
+func score(ruleSets *calculator.RuleSets, registry *calculator.Registry, stored store.StoredReceipt) (int, map[string]int) {
+	score := ruleSets.ScoreWith(registry, stored.Receipt, stored.ReceivedAt)
+	points := make(map[string]int, len(score.Rules))
+	for _, result := range score.Rules {
+		points[result.Rule] = result.Points
+	}
+	return score.Points, points
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// WriteCSV writes the report as CSV. The kind column tells the rows apart: a total row, a rule row for every rule, a
+// receipt row for every receipt with one of the largest changes and a variant row for every variant, identified as
+// experiment/variant.
+func (r Report) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	row := func(kind, id string, fromPoints, toPoints, delta int, changedReceipts string) {
+		writer.Write([]string{kind, id, strconv.Itoa(fromPoints), strconv.Itoa(toPoints), strconv.Itoa(delta),
+			changedReceipts})
+	}
+	writer.Write([]string{"kind", "id", "from_points", "to_points", "delta", "changed_receipts"})
+	row("total", r.From+" -> "+r.To, r.FromPoints, r.ToPoints, r.Delta(), strconv.Itoa(r.ChangedReceipts))
+	for _, rule := range r.Rules {
+		row("rule", rule.Rule, rule.FromPoints, rule.ToPoints, rule.Delta(), strconv.Itoa(rule.ChangedReceipts))
+	}
+	for _, receipt := range r.LargestChanges {
+		row("receipt", receipt.ID, receipt.FromPoints, receipt.ToPoints, receipt.Delta(), "")
+	}
+	for _, variant := range r.Variants {
+		row("variant", variant.Experiment+"/"+variant.Variant, variant.FromPoints, variant.ToPoints, variant.Delta(),
+			strconv.Itoa(variant.ChangedReceipts))
+	}
+	writer.Flush()
+	return writer.Error()
+}
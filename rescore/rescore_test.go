@@ -0,0 +1,209 @@
+package rescore
+
+import (
+	"bytes"
+	"fetch-assessment/calculator"
+	"fetch-assessment/model"
+	"fetch-assessment/store"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const definition = `
+ruleSets:
+  - version: "1"
+    rules:
+      - {id: name, type: retailer-name-length, points: 1}
+      - {id: pairs, type: item-groups, points: 5, params: {size: 2}}
+  - version: "2"
+    effectiveFrom: 2030-01-01
+    rules:
+      - {id: name, type: retailer-name-length, points: 2}
+      - {id: round, type: total-multiple, points: 50, params: {multiple: 1.00}}
+`
+
+func TestResolve(t *testing.T) {
+	ruleSets, err := calculator.ParseRuleSets([]byte(definition))
+	require.NoError(t, err)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	from, to, err := Resolve(ruleSets, "", "", now)
+	require.NoError(t, err)
+	assert.Equal(t, "1", from.Version())
+	assert.Equal(t, "2", to.Version())
+
+	from, to, err = Resolve(ruleSets, "2", "1", now)
+	require.NoError(t, err)
+	assert.Equal(t, "2", from.Version())
+	assert.Equal(t, "1", to.Version())
+
+	_, _, err = Resolve(ruleSets, "3", "", now)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+	_, _, err = Resolve(ruleSets, "", "3", now)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+}
+
+func TestCompare(t *testing.T) {
+	ruleSets, err := calculator.ParseRuleSets([]byte(definition))
+	require.NoError(t, err)
+	v1, _ := ruleSets.Version("1")
+	v2, _ := ruleSets.Version("2")
+	receipts := []store.StoredReceipt{
+		// 1: 3 + 5 = 8, 2: 6 + 50 = 56
+		{ID: "a", Receipt: model.Receipt{Retailer: "Abc", Total: "1.00", Items: make([]model.Item, 2)}},
+		// 1: 2 + 10 = 12, 2: 4 = 4
+		{ID: "b", Receipt: model.Receipt{Retailer: "Ab", Total: "1.01", Items: make([]model.Item, 4)}},
+		// 1: 0 + 0, 2: 0 + 0
+		{ID: "c", Receipt: model.Receipt{Retailer: "", Total: "1.01"}},
+	}
+
+	report := Compare(ruleSets, receipts, v1, v2, 10)
+	assert.Equal(t, "1", report.From)
+	assert.Equal(t, "2", report.To)
+	assert.Equal(t, 3, report.Receipts)
+	assert.Equal(t, 2, report.ChangedReceipts)
+	assert.Equal(t, 20, report.FromPoints)
+	assert.Equal(t, 60, report.ToPoints)
+	assert.Equal(t, 40, report.Delta())
+	assert.Equal(t, []RuleDelta{
+		{Rule: "name", FromPoints: 5, ToPoints: 10, ChangedReceipts: 2},
+		{Rule: "pairs", FromPoints: 15, ToPoints: 0, ChangedReceipts: 2},
+		{Rule: "round", FromPoints: 0, ToPoints: 50, ChangedReceipts: 1},
+	}, report.Rules)
+	assert.Equal(t, []ReceiptDelta{
+		{ID: "a", FromPoints: 8, ToPoints: 56},
+		{ID: "b", FromPoints: 12, ToPoints: 4},
+	}, report.LargestChanges)
+
+	report = Compare(ruleSets, receipts, v1, v2, 1)
+	assert.Equal(t, []ReceiptDelta{{ID: "a", FromPoints: 8, ToPoints: 56}}, report.LargestChanges)
+
+	report = Compare(ruleSets, receipts, v1, v1, 10)
+	assert.Zero(t, report.ChangedReceipts)
+	assert.Empty(t, report.LargestChanges)
+}
+
+func TestCompareLimitsPoints(t *testing.T) {
+	ruleSets, err := calculator.ParseRuleSets([]byte(`
+ruleSets:
+  - version: "1"
+    rules: [{id: name, type: retailer-name-length, points: 10}]
+  - version: "2"
+    effectiveFrom: 2030-01-01
+    maxPoints: 50
+    rules: [{id: name, type: retailer-name-length, points: 10, maxPoints: 80}]
+`))
+	require.NoError(t, err)
+	v1, _ := ruleSets.Version("1")
+	v2, _ := ruleSets.Version("2")
+	receipts := []store.StoredReceipt{{ID: "a", Receipt: model.Receipt{Retailer: "Walgreens"}}}
+
+	report := Compare(ruleSets, receipts, v1, v2, 10)
+	assert.Equal(t, 90, report.FromPoints)
+	assert.Equal(t, 50, report.ToPoints)
+	assert.Equal(t, []RuleDelta{{Rule: "name", FromPoints: 90, ToPoints: 80, ChangedReceipts: 1}}, report.Rules)
+}
+
+func TestCompareAppliesFraudRules(t *testing.T) {
+	ruleSets, err := calculator.ParseRuleSets([]byte(`
+ruleSets:
+  - version: "1"
+    rules: [{id: name, type: retailer-name-length, points: 1}]
+  - version: "2"
+    effectiveFrom: 2030-01-01
+    rules: [{id: name, type: retailer-name-length, points: 1}]
+    fraudRules: [{id: mismatch, type: total-mismatch, forfeit: true}]
+`))
+	require.NoError(t, err)
+	v1, _ := ruleSets.Version("1")
+	v2, _ := ruleSets.Version("2")
+	receipts := []store.StoredReceipt{
+		{ID: "a", Receipt: model.Receipt{Retailer: "Walgreens", Total: "1.00"}},
+		{ID: "b", Receipt: model.Receipt{Retailer: "Target", Total: "1.00",
+			Items: []model.Item{{ShortDescription: "Gum", Price: "1.00"}}}},
+	}
+
+	report := Compare(ruleSets, receipts, v1, v2, 10)
+	assert.Equal(t, 15, report.FromPoints)
+	assert.Equal(t, 6, report.ToPoints)
+	assert.Equal(t, []RuleDelta{
+		{Rule: "name", FromPoints: 15, ToPoints: 15},
+		{Rule: "mismatch", ToPoints: -9, ChangedReceipts: 1},
+	}, report.Rules)
+	assert.Equal(t, []ReceiptDelta{{ID: "a", FromPoints: 9, ToPoints: 0}}, report.LargestChanges)
+}
+
+func TestCompareScoresLikeRuleSets(t *testing.T) {
+	ruleSets, err := calculator.ParseRuleSets([]byte(`
+ruleSets:
+  - version: "1"
+    rules:
+      - {id: name, type: retailer-name-length, points: 1}
+      - {id: pairs, type: item-groups, points: 5, params: {size: 2}}
+  - version: "2"
+    effectiveFrom: 2030-01-01
+    rules: [{id: name, type: retailer-name-length, points: 2}]
+campaigns:
+  - {id: double, retailer: {equals: Target}, from: 2025-01-01, to: 2025-12-31, multiplier: 2}
+experiments:
+  - id: pairs-points
+    ruleSet: "1"
+    from: 2025-03-01
+    to: 2025-03-31
+    variants: [{name: control}, {name: more, rules: [{id: pairs, points: 10}]}]
+`))
+	require.NoError(t, err)
+	v1, _ := ruleSets.Version("1")
+	v2, _ := ruleSets.Version("2")
+	purchaseDate := openapi_types.Date{Time: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)}
+	receipts := []store.StoredReceipt{
+		// 1: (6 + 5) * 2 = 22, 2: 12 * 2 = 24
+		{ID: "a", Receipt: model.Receipt{Retailer: "Target", PurchaseDate: purchaseDate, Total: "1.00",
+			Items: make([]model.Item, 2)}},
+		// 1: (6 + 10) * 2 = 32 by the variant, 2: 12 * 2 = 24
+		{ID: "b", Receipt: model.Receipt{Retailer: "Target", PurchaseDate: purchaseDate, Total: "1.00",
+			Items:      make([]model.Item, 2),
+			Experiment: &model.ExperimentAssignment{Id: "pairs-points", Variant: "more"}}},
+	}
+
+	report := Compare(ruleSets, receipts, v1, v2, 10)
+	assert.Equal(t, 54, report.FromPoints)
+	assert.Equal(t, 48, report.ToPoints)
+	assert.Equal(t, []RuleDelta{
+		{Rule: "name", FromPoints: 12, ToPoints: 24, ChangedReceipts: 2},
+		{Rule: "pairs", FromPoints: 15, ToPoints: 0, ChangedReceipts: 2},
+		{Rule: "double", FromPoints: 27, ToPoints: 24, ChangedReceipts: 2},
+	}, report.Rules)
+	assert.Equal(t, []ReceiptDelta{
+		{ID: "b", FromPoints: 32, ToPoints: 24},
+		{ID: "a", FromPoints: 22, ToPoints: 24},
+	}, report.LargestChanges)
+	assert.Equal(t, []VariantDelta{
+		{Experiment: "pairs-points", Variant: "more", Receipts: 1, ChangedReceipts: 1, FromPoints: 32, ToPoints: 24},
+	}, report.Variants)
+	// the points match those of the points endpoint for the receipts scored by the version in force
+	for i, stored := range receipts {
+		assert.Equal(t, []int{22, 32}[i], ruleSets.Score(stored.Receipt, stored.ReceivedAt).Points)
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	report := Report{
+		From: "1", To: "2", Receipts: 3, ChangedReceipts: 2, FromPoints: 20, ToPoints: 60,
+		Rules:          []RuleDelta{{Rule: "name", FromPoints: 5, ToPoints: 10, ChangedReceipts: 2}},
+		LargestChanges: []ReceiptDelta{{ID: "b", FromPoints: 12, ToPoints: 4}},
+		Variants: []VariantDelta{{Experiment: "pairs-points", Variant: "more", Receipts: 1, ChangedReceipts: 1,
+			FromPoints: 12, ToPoints: 4}},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteCSV(&buf))
+	assert.Equal(t, `kind,id,from_points,to_points,delta,changed_receipts
+total,1 -> 2,20,60,40,2
+rule,name,5,10,5,2
+receipt,b,12,4,-8,
+variant,pairs-points/more,12,4,-8,1
+`, buf.String())
+}
@@ -0,0 +1,24 @@
+package store_test
+
+import (
+	"fetch-assessment/store"
+	"fetch-assessment/store/storetest"
+	"testing"
+)
+
+func TestReceiptStoreConformance(t *testing.T) {
+	storetest.RunRepositoryTests(t, func(t *testing.T) store.ReceiptRepository {
+		return store.NewReceiptStore()
+	})
+}
+
+func TestFileReceiptStoreConformance(t *testing.T) {
+	storetest.RunRepositoryTests(t, func(t *testing.T) store.ReceiptRepository {
+		repo, err := store.NewFileReceiptStore(t.TempDir(), 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	})
+}
@@ -0,0 +1,469 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fetch-assessment/model"
+	"fmt"
+	"github.com/google/uuid"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "receipts.wal"
+	snapshotFileName = "receipts.snapshot"
+
+	// DefaultSnapshotInterval is the number of log records after which the log is compacted into a snapshot.
+	DefaultSnapshotInterval = 1000
+
+	// walHeaderSize is the size of the record header: payload length and CRC-32 checksum, both uint32.
+	walHeaderSize = 8
+	// maxWALRecordSize guards against allocating huge buffers for a corrupted length field.
+	maxWALRecordSize = 16 << 20
+
+	walOpPut    = "put"
+	walOpDelete = "delete"
+)
+
+var errCorruptRecord = errors.New("corrupt log record")
+
+// ErrUnsupportedFormat is returned when opening a data directory whose log or snapshot holds records without a version,
+// as written before receipts were versioned. Such records are neither read nor discarded.
+var ErrUnsupportedFormat = errors.New("unsupported log format: records without a version")
+
+// ErrReadOnly is returned by writes to a store opened with NewReadOnlyFileReceiptStore.
+var ErrReadOnly = errors.New("receipt store is read-only")
+
+// FileReceiptStore is a durable ReceiptRepository. Every new version is appended to a write-ahead log and synced to
+// disk before it is applied to an in-memory ReceiptStore, which serves all reads. After a number of writes all versions
+// are written to a snapshot and the log is truncated. On startup the snapshot is loaded and the log replayed.
+type FileReceiptStore struct {
+	// mu serializes writes, so that log order matches the order in which changes are applied.
+	mu               sync.Mutex
+	dir              string
+	wal              *os.File
+	memory           *ReceiptStore
+	snapshotInterval int
+	walRecords       int
+	walSize          int64
+	// readOnly is set if the store was opened by NewReadOnlyFileReceiptStore, in which case wal is nil.
+	readOnly bool
+}
+
+// walRecord holds a version of a receipt.
+type walRecord struct {
+	Op         string         `json:"op"`
+	ID         string         `json:"id"`
+	Version    int            `json:"version"`
+	Receipt    *model.Receipt `json:"receipt,omitempty"`
+	ReceivedAt time.Time      `json:"receivedAt"`
+	UpdatedAt  time.Time      `json:"updatedAt"`
+}
+
+// snapshotRecord holds a version of a receipt.
+type snapshotRecord struct {
+	ID         string        `json:"id"`
+	Version    int           `json:"version"`
+	Receipt    model.Receipt `json:"receipt"`
+	ReceivedAt time.Time     `json:"receivedAt"`
+	UpdatedAt  time.Time     `json:"updatedAt"`
+	Deleted    bool          `json:"deleted,omitempty"`
+}
+
+// NewFileReceiptStore opens the store in dir, creating the directory if needed, and restores its state from the
+// snapshot and log found there. A partially written record at the end of the log, as left behind by a crash, is
+// discarded. snapshotInterval is the number of log records that triggers compaction.
+func NewFileReceiptStore(dir string, snapshotInterval int) (*FileReceiptStore, error) {
+	if snapshotInterval <= 0 {
+		return nil, fmt.Errorf("snapshot interval must be positive, got %d", snapshotInterval)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	f := &FileReceiptStore{
+		dir:              dir,
+		memory:           NewReceiptStore(),
+		snapshotInterval: snapshotInterval,
+	}
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := f.replayLog(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// NewReadOnlyFileReceiptStore opens the store in dir for reading only, for example to run a report while a server is
+// writing to the same directory. It restores the state from the snapshot and log found there without changing either,
+// and all writes fail with ErrReadOnly. Changes made after the store was opened are not visible.
+func NewReadOnlyFileReceiptStore(dir string) (*FileReceiptStore, error) {
+	// the log is read before the snapshot: if the writer compacts in between, the new snapshot holds all records read
+	// from the log, which are then ignored, whereas reading the snapshot first could miss records compacted into the
+	// new snapshot and truncated from the log
+	wal, err := os.ReadFile(filepath.Join(dir, walFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	f := &FileReceiptStore{dir: dir, memory: NewReceiptStore(), readOnly: true}
+	if err := f.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	records, size, err := readLog(bytes.NewReader(wal))
+	if errors.Is(err, ErrUnsupportedFormat) {
+		return nil, fmt.Errorf("reading write-ahead log at offset %d: %w", size, err)
+	}
+	if err != nil {
+		// the writer may be in the middle of appending the last record
+		fmt.Printf("ignoring write-ahead log after offset %d: %v\n", size, err)
+	}
+	for _, record := range records {
+		f.apply(record)
+	}
+	return f, nil
+}
+
+func (f *FileReceiptStore) Store(receipt model.Receipt) (uuid.UUID, error) {
+	stored, err := f.write(func() (StoredReceipt, error) { return f.memory.firstVersionLocked(receipt) })
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	return uuid.MustParse(stored.ID), nil
+}
+
+func (f *FileReceiptStore) Update(id string, receipt model.Receipt) (StoredReceipt, error) {
+	return f.write(func() (StoredReceipt, error) { return f.memory.updatedVersionLocked(id, receipt) })
+}
+
+func (f *FileReceiptStore) Delete(id string) error {
+	_, err := f.write(func() (StoredReceipt, error) { return f.memory.deletedVersionLocked(id) })
+	return err
+}
+
+// write logs the next version created by next before applying it to the in-memory store.
+func (f *FileReceiptStore) write(next func() (StoredReceipt, error)) (StoredReceipt, error) {
+	if f.readOnly {
+		return StoredReceipt{}, ErrReadOnly
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stored, err := f.memory.write(next, func(version StoredReceipt) error {
+		return f.append(newWALRecord(version))
+	})
+	if err != nil {
+		return StoredReceipt{}, err
+	}
+	f.compactIfNeeded()
+	return stored, nil
+}
+
+func (f *FileReceiptStore) Get(id string) (StoredReceipt, error) {
+	return f.memory.Get(id)
+}
+
+func (f *FileReceiptStore) GetReceipt(id string) (*model.Receipt, error) {
+	return f.memory.GetReceipt(id)
+}
+
+func (f *FileReceiptStore) List() ([]StoredReceipt, error) {
+	return f.memory.List()
+}
+
+func (f *FileReceiptStore) Search(query Query) (Page, error) {
+	return f.memory.Search(query)
+}
+
+func (f *FileReceiptStore) Versions(id string) ([]StoredReceipt, error) {
+	return f.memory.Versions(id)
+}
+
+// Snapshot writes the current state to the snapshot file and truncates the log.
+func (f *FileReceiptStore) Snapshot() error {
+	if f.readOnly {
+		return ErrReadOnly
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshot()
+}
+
+// Close compacts the log and releases the log file. A read-only store leaves the files unchanged. The store must not be
+// used afterwards.
+func (f *FileReceiptStore) Close() error {
+	if f.readOnly {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.snapshot()
+	if closeErr := f.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *FileReceiptStore) append(record walRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[walHeaderSize:], payload)
+
+	if _, err := f.wal.Write(buf); err != nil {
+		f.discardPartialRecord()
+		return err
+	}
+	if err := f.wal.Sync(); err != nil {
+		f.discardPartialRecord()
+		return err
+	}
+	f.walRecords++
+	f.walSize += int64(len(buf))
+	return nil
+}
+
+// discardPartialRecord removes a record that failed to be written completely, so that subsequent records are not
+// appended after garbage and lost on the next replay.
+func (f *FileReceiptStore) discardPartialRecord() {
+	if err := f.wal.Truncate(f.walSize); err != nil {
+		fmt.Println("failed to truncate write-ahead log", err)
+	}
+	if _, err := f.wal.Seek(f.walSize, io.SeekStart); err != nil {
+		fmt.Println("failed to seek write-ahead log", err)
+	}
+}
+
+// compactIfNeeded takes a snapshot once the log has grown past the snapshot interval. A failed snapshot does not fail
+// the write that triggered it, as the change is already durable in the log; it is retried on the next write.
+func (f *FileReceiptStore) compactIfNeeded() {
+	if f.walRecords < f.snapshotInterval {
+		return
+	}
+	if err := f.snapshot(); err != nil {
+		fmt.Println("failed to snapshot receipt store", err)
+	}
+}
+
+// snapshot writes the snapshot to a temporary file and renames it into place, so that a crash leaves either the old
+// or the new snapshot behind. The log is only truncated once the new snapshot is durable. Replaying a log on top of a
+// snapshot that already contains its changes is harmless, since log records are idempotent.
+func (f *FileReceiptStore) snapshot() error {
+	records := make([]snapshotRecord, 0)
+	for _, history := range f.memory.histories() {
+		for _, v := range history {
+			records = append(records, snapshotRecord{
+				ID:         v.ID,
+				Version:    v.Version,
+				Receipt:    v.Receipt,
+				ReceivedAt: v.ReceivedAt,
+				UpdatedAt:  v.UpdatedAt,
+				Deleted:    v.Deleted,
+			})
+		}
+	}
+
+	tmpPath := filepath.Join(f.dir, snapshotFileName+".tmp")
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	if err := json.NewEncoder(writer).Encode(records); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(f.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+
+	if err := f.wal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	f.walRecords = 0
+	f.walSize = 0
+	return f.wal.Sync()
+}
+
+func (f *FileReceiptStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(f.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var records []snapshotRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("reading snapshot: %w", err)
+	}
+	for _, r := range records {
+		if r.Version <= 0 {
+			return fmt.Errorf("reading snapshot: %w", ErrUnsupportedFormat)
+		}
+	}
+	for _, r := range records {
+		f.memory.apply(StoredReceipt{
+			ID:         r.ID,
+			Version:    r.Version,
+			Receipt:    r.Receipt,
+			ReceivedAt: r.ReceivedAt,
+			UpdatedAt:  r.UpdatedAt,
+			Deleted:    r.Deleted,
+		})
+	}
+	return nil
+}
+
+// replayLog applies all complete records of the log and truncates it after the last one.
+func (f *FileReceiptStore) replayLog() error {
+	wal, err := os.OpenFile(filepath.Join(f.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+
+	records, offset, err := readLog(wal)
+	if errors.Is(err, ErrUnsupportedFormat) {
+		wal.Close()
+		return fmt.Errorf("reading write-ahead log at offset %d: %w", offset, err)
+	}
+	if err != nil {
+		// to be converted into warn log for production system
+		fmt.Printf("discarding write-ahead log after offset %d: %v\n", offset, err)
+	}
+	for _, record := range records {
+		f.apply(record)
+	}
+	f.walRecords = len(records)
+
+	if err := wal.Truncate(offset); err != nil {
+		wal.Close()
+		return err
+	}
+	if _, err := wal.Seek(offset, io.SeekStart); err != nil {
+		wal.Close()
+		return err
+	}
+	f.wal = wal
+	f.walSize = offset
+	return nil
+}
+
+// readLog reads the complete records at the start of the log and returns them along with their size on disk. The error
+// reports why the log ended early, if it does not end exactly after the last record.
+func readLog(log io.Reader) ([]walRecord, int64, error) {
+	reader := bufio.NewReader(log)
+	var records []walRecord
+	var offset int64
+	for {
+		record, size, err := readWALRecord(reader)
+		if err == io.EOF {
+			return records, offset, nil
+		}
+		if err != nil {
+			return records, offset, err
+		}
+		records = append(records, record)
+		offset += size
+	}
+}
+
+func newWALRecord(version StoredReceipt) walRecord {
+	op := walOpPut
+	if version.Deleted {
+		op = walOpDelete
+	}
+	return walRecord{
+		Op:         op,
+		ID:         version.ID,
+		Version:    version.Version,
+		Receipt:    &version.Receipt,
+		ReceivedAt: version.ReceivedAt,
+		UpdatedAt:  version.UpdatedAt,
+	}
+}
+
+// apply restores the version held by the record. Versions already included in the snapshot are ignored.
+func (f *FileReceiptStore) apply(record walRecord) {
+	f.memory.apply(StoredReceipt{
+		ID:         record.ID,
+		Version:    record.Version,
+		Receipt:    *record.Receipt,
+		ReceivedAt: record.ReceivedAt,
+		UpdatedAt:  record.UpdatedAt,
+		Deleted:    record.Op == walOpDelete,
+	})
+}
+
+// readWALRecord reads the next record and returns it along with its size on disk. It returns io.EOF if the log ends
+// exactly at a record boundary and an error if the record is incomplete or fails its checksum.
+func readWALRecord(reader io.Reader) (walRecord, int64, error) {
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return walRecord{}, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxWALRecordSize {
+		return walRecord{}, 0, errCorruptRecord
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return walRecord{}, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return walRecord{}, 0, errCorruptRecord
+	}
+
+	var record walRecord
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return walRecord{}, 0, fmt.Errorf("%w: %v", errCorruptRecord, err)
+	}
+	if record.Version == 0 {
+		return walRecord{}, 0, ErrUnsupportedFormat
+	}
+	if record.ID == "" || record.Version < 0 || record.Receipt == nil || (record.Op != walOpPut && record.Op != walOpDelete) {
+		return walRecord{}, 0, errCorruptRecord
+	}
+	return record, int64(walHeaderSize) + int64(length), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
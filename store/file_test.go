@@ -0,0 +1,300 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fetch-assessment/model"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileReceiptStoreReplaysLogAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	require.NoError(t, err)
+
+	kept, err := repo.Store(model.Receipt{Retailer: "kept"})
+	require.NoError(t, err)
+	deleted, err := repo.Store(model.Receipt{Retailer: "deleted"})
+	require.NoError(t, err)
+	require.NoError(t, repo.Delete(deleted.String()))
+
+	// simulate a crash: the log is not compacted and the file is not closed cleanly
+	require.NoError(t, repo.wal.Close())
+	assertNoSnapshot(t, dir)
+
+	reopened, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	stored, err := reopened.Get(kept.String())
+	require.NoError(t, err)
+	assert.Equal(t, "kept", stored.Receipt.Retailer)
+	original, err := repo.Get(kept.String())
+	require.NoError(t, err)
+	assert.Equal(t, original.ReceivedAt, stored.ReceivedAt)
+	_, err = reopened.GetReceipt(deleted.String())
+	assert.True(t, errors.Is(err, ErrReceiptNotFound))
+}
+
+func TestFileReceiptStoreCompactsLogIntoSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileReceiptStore(dir, 3)
+	require.NoError(t, err)
+
+	ids := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		id, err := repo.Store(model.Receipt{Retailer: fmt.Sprintf("retailer %d", i)})
+		require.NoError(t, err)
+		ids = append(ids, id.String())
+	}
+
+	// the third write triggered a snapshot, so only the fourth record remains in the log
+	assert.Equal(t, 1, repo.walRecords)
+	assert.FileExists(t, filepath.Join(dir, snapshotFileName))
+	require.NoError(t, repo.wal.Close())
+
+	reopened, err := NewFileReceiptStore(dir, 3)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	for i, id := range ids {
+		stored, err := reopened.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("retailer %d", i), stored.Receipt.Retailer)
+		original, err := repo.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, original.ReceivedAt, stored.ReceivedAt)
+	}
+}
+
+func TestFileReceiptStoreReplaysLogOverlappingSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	require.NoError(t, err)
+	kept, err := repo.Store(model.Receipt{Retailer: "kept"})
+	require.NoError(t, err)
+	deleted, err := repo.Store(model.Receipt{Retailer: "deleted"})
+	require.NoError(t, err)
+	require.NoError(t, repo.Delete(deleted.String()))
+	wal, err := os.ReadFile(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+
+	// simulate a crash after the snapshot was written, but before the log was truncated
+	require.NoError(t, repo.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walFileName), wal, 0o644))
+
+	reopened, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	listed, err := reopened.List()
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, kept.String(), listed[0].ID)
+}
+
+func TestFileReceiptStoreRecoversFromTruncatedLog(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	require.NoError(t, err)
+	first, err := repo.Store(model.Receipt{Retailer: "first"})
+	require.NoError(t, err)
+	firstRecordSize := repo.walSize
+	second, err := repo.Store(model.Receipt{Retailer: "second"})
+	require.NoError(t, err)
+	require.NoError(t, repo.wal.Close())
+
+	walPath := filepath.Join(dir, walFileName)
+	wal, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	require.Equal(t, repo.walSize, int64(len(wal)))
+
+	// cut the second record at every possible position, including inside its header
+	for cut := firstRecordSize; cut < int64(len(wal)); cut++ {
+		t.Run(fmt.Sprintf("cut at %d", cut), func(t *testing.T) {
+			require.NoError(t, os.WriteFile(walPath, wal[:cut], 0o644))
+
+			reopened, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+			require.NoError(t, err)
+
+			receipt, err := reopened.GetReceipt(first.String())
+			require.NoError(t, err)
+			assert.Equal(t, "first", receipt.Retailer)
+			_, err = reopened.GetReceipt(second.String())
+			assert.True(t, errors.Is(err, ErrReceiptNotFound))
+
+			// the partial record is discarded, so that new writes are not lost behind it
+			assert.Equal(t, firstRecordSize, reopened.walSize)
+			third, err := reopened.Store(model.Receipt{Retailer: "third"})
+			require.NoError(t, err)
+			require.NoError(t, reopened.wal.Close())
+
+			again, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+			require.NoError(t, err)
+			defer again.wal.Close()
+			receipt, err = again.GetReceipt(third.String())
+			require.NoError(t, err)
+			assert.Equal(t, "third", receipt.Retailer)
+		})
+	}
+}
+
+func TestFileReceiptStoreDiscardsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	require.NoError(t, err)
+	first, err := repo.Store(model.Receipt{Retailer: "first"})
+	require.NoError(t, err)
+	firstRecordSize := repo.walSize
+	second, err := repo.Store(model.Receipt{Retailer: "second"})
+	require.NoError(t, err)
+	require.NoError(t, repo.wal.Close())
+
+	walPath := filepath.Join(dir, walFileName)
+	wal, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	wal[len(wal)-2] ^= 0xff
+	require.NoError(t, os.WriteFile(walPath, wal, 0o644))
+
+	reopened, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	_, err = reopened.GetReceipt(first.String())
+	assert.NoError(t, err)
+	_, err = reopened.GetReceipt(second.String())
+	assert.True(t, errors.Is(err, ErrReceiptNotFound))
+	assert.Equal(t, firstRecordSize, reopened.walSize)
+}
+
+func TestFileReceiptStoreRestoresVersionHistory(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compacted %v", compact), func(t *testing.T) {
+			dir := t.TempDir()
+			repo, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+			require.NoError(t, err)
+
+			corrected, err := repo.Store(model.Receipt{Retailer: "original"})
+			require.NoError(t, err)
+			_, err = repo.Update(corrected.String(), model.Receipt{Retailer: "corrected"})
+			require.NoError(t, err)
+			deleted, err := repo.Store(model.Receipt{Retailer: "deleted"})
+			require.NoError(t, err)
+			require.NoError(t, repo.Delete(deleted.String()))
+			if compact {
+				require.NoError(t, repo.Snapshot())
+			}
+			correctedVersions, err := repo.Versions(corrected.String())
+			require.NoError(t, err)
+			deletedVersions, err := repo.Versions(deleted.String())
+			require.NoError(t, err)
+			require.NoError(t, repo.wal.Close())
+
+			reopened, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+			require.NoError(t, err)
+			defer reopened.Close()
+
+			versions, err := reopened.Versions(corrected.String())
+			require.NoError(t, err)
+			assert.Equal(t, correctedVersions, versions)
+			versions, err = reopened.Versions(deleted.String())
+			require.NoError(t, err)
+			assert.Equal(t, deletedVersions, versions)
+			_, err = reopened.Get(deleted.String())
+			assert.True(t, errors.Is(err, ErrReceiptNotFound))
+			// the content of the first version is no longer stored
+			_, err = reopened.Store(model.Receipt{Retailer: "original"})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFileReceiptStoreRejectsUnversionedRecords(t *testing.T) {
+	dir := t.TempDir()
+	// records as written before receipts were versioned
+	var wal []byte
+	for _, payload := range []string{
+		`{"op":"put","id":"a","receipt":{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01",` +
+			`"total":"1.00","items":[]}}`,
+		`{"op":"delete","id":"a"}`,
+	} {
+		header := make([]byte, walHeaderSize)
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE([]byte(payload)))
+		wal = append(append(wal, header...), payload...)
+	}
+	walPath := filepath.Join(dir, walFileName)
+	require.NoError(t, os.WriteFile(walPath, wal, 0o644))
+
+	_, err := NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	_, err = NewReadOnlyFileReceiptStore(dir)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+	// the log is kept for migration rather than truncated
+	kept, err := os.ReadFile(walPath)
+	require.NoError(t, err)
+	assert.Equal(t, wal, kept)
+
+	require.NoError(t, os.Remove(walPath))
+	snapshot := `[{"id":"a","receipt":{"retailer":"Target","purchaseDate":"2022-01-01","purchaseTime":"13:01",` +
+		`"total":"1.00","items":[]}}]`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotFileName), []byte(snapshot), 0o644))
+	_, err = NewFileReceiptStore(dir, DefaultSnapshotInterval)
+	assert.ErrorIs(t, err, ErrUnsupportedFormat)
+}
+
+func TestReadOnlyFileReceiptStoreLeavesFilesUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := NewFileReceiptStore(dir, 2)
+	require.NoError(t, err)
+	defer repo.Close()
+	ids := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		id, err := repo.Store(model.Receipt{Retailer: fmt.Sprintf("retailer %d", i)})
+		require.NoError(t, err)
+		ids = append(ids, id.String())
+	}
+	snapshot, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	require.NoError(t, err)
+	wal, err := os.ReadFile(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+	// a record the server is still appending
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walFileName), append(wal, 0, 0, 1), 0o644))
+
+	readOnly, err := NewReadOnlyFileReceiptStore(dir)
+	require.NoError(t, err)
+	listed, err := readOnly.List()
+	require.NoError(t, err)
+	listedIDs := make([]string, 0)
+	for _, stored := range listed {
+		listedIDs = append(listedIDs, stored.ID)
+	}
+	assert.ElementsMatch(t, ids, listedIDs)
+	_, err = readOnly.Store(model.Receipt{Retailer: "written"})
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorIs(t, readOnly.Delete(ids[0]), ErrReadOnly)
+	require.NoError(t, readOnly.Close())
+
+	afterSnapshot, err := os.ReadFile(filepath.Join(dir, snapshotFileName))
+	require.NoError(t, err)
+	assert.Equal(t, snapshot, afterSnapshot)
+	afterWAL, err := os.ReadFile(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+	assert.Equal(t, append(wal, 0, 0, 1), afterWAL)
+}
+
+func TestNewFileReceiptStoreRejectsInvalidInterval(t *testing.T) {
+	_, err := NewFileReceiptStore(t.TempDir(), 0)
+	assert.Error(t, err)
+}
+
+func assertNoSnapshot(t *testing.T, dir string) {
+	_, err := os.Stat(filepath.Join(dir, snapshotFileName))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fetch-assessment/model"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DuplicateReceiptError is returned by Store if a receipt with the same fingerprint is already stored.
+type DuplicateReceiptError struct {
+	ExistingID string
+}
+
+func (e *DuplicateReceiptError) Error() string {
+	return fmt.Sprintf("duplicate of receipt %s", e.ExistingID)
+}
+
+// Fingerprint computes a canonical hash of the receipt's content, used to detect the same physical receipt being
+// submitted more than once. The retailer is compared ignoring case, whitespace and punctuation, item descriptions
+// ignoring case and surrounding whitespace, and the order of items is irrelevant.
+func Fingerprint(receipt model.Receipt) string {
+	items := make([]string, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		items = append(items, fmt.Sprintf("%q %q", strings.ToLower(strings.TrimSpace(item.ShortDescription)), item.Price))
+	}
+	sort.Strings(items)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "retailer %q\n", NormalizeRetailer(receipt.Retailer))
+	fmt.Fprintf(h, "date %s\n", receipt.PurchaseDate.Format("2006-01-02"))
+	fmt.Fprintf(h, "time %q\n", receipt.PurchaseTime)
+	fmt.Fprintf(h, "total %q\n", receipt.Total)
+	for _, item := range items {
+		fmt.Fprintf(h, "item %s\n", item)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
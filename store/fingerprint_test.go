@@ -0,0 +1,62 @@
+package store
+
+import (
+	"fetch-assessment/model"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	original := model.Receipt{
+		Retailer:     "M&M Corner Market",
+		PurchaseDate: openapi_types.Date{Time: time.Date(2022, 3, 20, 0, 0, 0, 0, time.UTC)},
+		PurchaseTime: "14:33",
+		Total:        "9.00",
+		Items: []model.Item{
+			{ShortDescription: "Gatorade", Price: "2.25"},
+			{ShortDescription: "Doritos", Price: "6.75"},
+		},
+	}
+
+	same := []struct {
+		name   string
+		modify func(r *model.Receipt)
+	}{
+		{"identical", func(r *model.Receipt) {}},
+		{"retailer punctuation and case", func(r *model.Receipt) { r.Retailer = "m m corner-market" }},
+		{"item order", func(r *model.Receipt) { r.Items[0], r.Items[1] = r.Items[1], r.Items[0] }},
+		{"item description case and whitespace", func(r *model.Receipt) { r.Items[0].ShortDescription = "  GATORADE " }},
+	}
+	for _, tc := range same {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := cloneReceipt(original)
+			tc.modify(&receipt)
+			assert.Equal(t, Fingerprint(original), Fingerprint(receipt))
+		})
+	}
+
+	different := []struct {
+		name   string
+		modify func(r *model.Receipt)
+	}{
+		{"retailer", func(r *model.Receipt) { r.Retailer = "M&M Corner Shop" }},
+		{"date", func(r *model.Receipt) {
+			r.PurchaseDate = openapi_types.Date{Time: time.Date(2022, 3, 21, 0, 0, 0, 0, time.UTC)}
+		}},
+		{"time", func(r *model.Receipt) { r.PurchaseTime = "14:34" }},
+		{"total", func(r *model.Receipt) { r.Total = "9.01" }},
+		{"item price", func(r *model.Receipt) { r.Items[0].Price = "2.26" }},
+		{"item description", func(r *model.Receipt) { r.Items[0].ShortDescription = "Gatorade Zero" }},
+		{"additional item", func(r *model.Receipt) { r.Items = append(r.Items, model.Item{ShortDescription: "Gum", Price: "0.00"}) }},
+		{"missing item", func(r *model.Receipt) { r.Items = r.Items[:1] }},
+	}
+	for _, tc := range different {
+		t.Run(tc.name, func(t *testing.T) {
+			receipt := cloneReceipt(original)
+			tc.modify(&receipt)
+			assert.NotEqual(t, Fingerprint(original), Fingerprint(receipt))
+		})
+	}
+}
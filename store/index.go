@@ -0,0 +1,261 @@
+package store
+
+import (
+	"fetch-assessment/model"
+	"fetch-assessment/money"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gramSize is the maximum length of the n-grams indexed for item description searches. Search terms of at least this
+// length are looked up by all their n-grams of this length, shorter ones directly.
+const gramSize = 3
+
+// searchIndex holds the secondary indexes of the in-memory store, so that searches only visit matching receipts.
+type searchIndex struct {
+	mu sync.RWMutex
+	// ids holds all IDs in ascending order.
+	ids        []string
+	byRetailer map[string]map[string]struct{}
+	byDate     sortedIndex
+	byTotal    sortedIndex
+	// byGram maps all n-grams up to gramSize of the lower-cased item descriptions to receipt IDs.
+	byGram map[string]map[string]struct{}
+	// descriptions holds the lower-cased item descriptions by receipt ID, to verify n-gram matches.
+	descriptions map[string][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		byRetailer:   make(map[string]map[string]struct{}),
+		byGram:       make(map[string]map[string]struct{}),
+		descriptions: make(map[string][]string),
+	}
+}
+
+func (x *searchIndex) add(id string, receipt model.Receipt) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	i := sort.SearchStrings(x.ids, id)
+	x.ids = append(x.ids, "")
+	copy(x.ids[i+1:], x.ids[i:])
+	x.ids[i] = id
+
+	addToSet(x.byRetailer, NormalizeRetailer(receipt.Retailer), id)
+	x.byDate.insert(dateKey(receipt.PurchaseDate.Time), id)
+	if total, err := money.Parse(receipt.Total); err == nil {
+		x.byTotal.insert(total.Cents(), id)
+	}
+	descriptions := lowerDescriptions(receipt)
+	for _, gram := range descriptionGrams(descriptions) {
+		addToSet(x.byGram, gram, id)
+	}
+	x.descriptions[id] = descriptions
+}
+
+func (x *searchIndex) remove(id string, receipt model.Receipt) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if i := sort.SearchStrings(x.ids, id); i < len(x.ids) && x.ids[i] == id {
+		x.ids = append(x.ids[:i], x.ids[i+1:]...)
+	}
+	removeFromSet(x.byRetailer, NormalizeRetailer(receipt.Retailer), id)
+	x.byDate.remove(dateKey(receipt.PurchaseDate.Time), id)
+	if total, err := money.Parse(receipt.Total); err == nil {
+		x.byTotal.remove(total.Cents(), id)
+	}
+	for _, gram := range descriptionGrams(x.descriptions[id]) {
+		removeFromSet(x.byGram, gram, id)
+	}
+	delete(x.descriptions, id)
+}
+
+// search returns up to limit IDs of receipts matching the query, in ascending order and greater than after.
+func (x *searchIndex) search(q Query, after string, limit int) []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	var sets []map[string]struct{}
+	if q.Retailer != "" {
+		sets = append(sets, x.byRetailer[NormalizeRetailer(q.Retailer)])
+	}
+	if q.PurchasedFrom != nil || q.PurchasedTo != nil {
+		var from, to *int64
+		if q.PurchasedFrom != nil {
+			key := dateKey(*q.PurchasedFrom)
+			from = &key
+		}
+		if q.PurchasedTo != nil {
+			key := dateKey(*q.PurchasedTo)
+			to = &key
+		}
+		sets = append(sets, x.byDate.between(from, to))
+	}
+	if q.MinTotalCents != nil || q.MaxTotalCents != nil {
+		sets = append(sets, x.byTotal.between(q.MinTotalCents, q.MaxTotalCents))
+	}
+	needle := strings.ToLower(q.ItemDescription)
+	if needle != "" {
+		for _, gram := range needleGrams(needle) {
+			sets = append(sets, x.byGram[gram])
+		}
+	}
+
+	if len(sets) == 0 {
+		start := sort.SearchStrings(x.ids, after)
+		if start < len(x.ids) && x.ids[start] == after {
+			start++
+		}
+		end := min(start+limit, len(x.ids))
+		return append([]string(nil), x.ids[start:end]...)
+	}
+
+	// intersect, starting with the smallest set
+	sort.Slice(sets, func(i, j int) bool {
+		return len(sets[i]) < len(sets[j])
+	})
+	result := make([]string, 0)
+	for id := range sets[0] {
+		if id <= after || !inAll(sets[1:], id) {
+			continue
+		}
+		if needle != "" && !containsDescription(x.descriptions[id], needle) {
+			continue
+		}
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// sortedIndex is a list of keys and IDs, ordered by key and ID, supporting range queries.
+type sortedIndex []sortedEntry
+
+type sortedEntry struct {
+	key int64
+	id  string
+}
+
+func (s *sortedIndex) position(key int64, id string) int {
+	entries := *s
+	return sort.Search(len(entries), func(i int) bool {
+		return entries[i].key > key || (entries[i].key == key && entries[i].id >= id)
+	})
+}
+
+func (s *sortedIndex) insert(key int64, id string) {
+	i := s.position(key, id)
+	*s = append(*s, sortedEntry{})
+	copy((*s)[i+1:], (*s)[i:])
+	(*s)[i] = sortedEntry{key: key, id: id}
+}
+
+func (s *sortedIndex) remove(key int64, id string) {
+	i := s.position(key, id)
+	if i < len(*s) && (*s)[i] == (sortedEntry{key: key, id: id}) {
+		*s = append((*s)[:i], (*s)[i+1:]...)
+	}
+}
+
+// between returns the IDs with keys in the given range. A nil bound is unbounded.
+func (s *sortedIndex) between(from, to *int64) map[string]struct{} {
+	entries := *s
+	start := 0
+	if from != nil {
+		start = sort.Search(len(entries), func(i int) bool { return entries[i].key >= *from })
+	}
+	result := make(map[string]struct{})
+	for _, entry := range entries[start:] {
+		if to != nil && entry.key > *to {
+			break
+		}
+		result[entry.id] = struct{}{}
+	}
+	return result
+}
+
+func dateKey(date time.Time) int64 {
+	return int64(date.Year())*10000 + int64(date.Month())*100 + int64(date.Day())
+}
+
+func lowerDescriptions(receipt model.Receipt) []string {
+	descriptions := make([]string, 0, len(receipt.Items))
+	for _, item := range receipt.Items {
+		descriptions = append(descriptions, strings.ToLower(item.ShortDescription))
+	}
+	return descriptions
+}
+
+// descriptionGrams returns the distinct n-grams of length 1 up to gramSize of the descriptions.
+func descriptionGrams(descriptions []string) []string {
+	seen := make(map[string]struct{})
+	grams := make([]string, 0)
+	for _, description := range descriptions {
+		runes := []rune(description)
+		for n := 1; n <= gramSize; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				gram := string(runes[i : i+n])
+				if _, ok := seen[gram]; !ok {
+					seen[gram] = struct{}{}
+					grams = append(grams, gram)
+				}
+			}
+		}
+	}
+	return grams
+}
+
+// needleGrams returns the n-grams to look up for a search term.
+func needleGrams(needle string) []string {
+	runes := []rune(needle)
+	if len(runes) <= gramSize {
+		return []string{needle}
+	}
+	grams := make([]string, 0, len(runes)-gramSize+1)
+	for i := 0; i+gramSize <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+gramSize]))
+	}
+	return grams
+}
+
+func containsDescription(descriptions []string, needle string) bool {
+	for _, description := range descriptions {
+		if strings.Contains(description, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func inAll(sets []map[string]struct{}, id string) bool {
+	for _, set := range sets {
+		if _, ok := set[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func addToSet(index map[string]map[string]struct{}, key, id string) {
+	set, ok := index[key]
+	if !ok {
+		set = make(map[string]struct{})
+		index[key] = set
+	}
+	set[id] = struct{}{}
+}
+
+func removeFromSet(index map[string]map[string]struct{}, key, id string) {
+	set := index[key]
+	delete(set, id)
+	if len(set) == 0 {
+		delete(index, key)
+	}
+}
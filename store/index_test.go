@@ -0,0 +1,27 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNeedleGrams(t *testing.T) {
+	assert.Equal(t, []string{"d"}, needleGrams("d"))
+	assert.Equal(t, []string{"dew"}, needleGrams("dew"))
+	assert.Equal(t, []string{"dew", "ew ", "w 1"}, needleGrams("dew 1"))
+}
+
+func TestSortedIndexBetween(t *testing.T) {
+	var index sortedIndex
+	index.insert(30, "c")
+	index.insert(10, "a")
+	index.insert(20, "b")
+	index.insert(20, "a")
+	index.remove(20, "a")
+
+	low, high := int64(15), int64(30)
+	assert.Equal(t, map[string]struct{}{"b": {}, "c": {}}, index.between(&low, nil))
+	assert.Equal(t, map[string]struct{}{"a": {}}, index.between(nil, &low))
+	assert.Equal(t, map[string]struct{}{"b": {}, "c": {}}, index.between(&low, &high))
+	assert.Len(t, index.between(nil, nil), 3)
+}
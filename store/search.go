@@ -0,0 +1,78 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fetch-assessment/utils"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 25
+	MaxPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query selects receipts for Search. Unset fields do not restrict the result; all set fields must match.
+type Query struct {
+	// Retailer matches receipts of the retailer, compared by NormalizeRetailer.
+	Retailer string
+	// PurchasedFrom and PurchasedTo limit the purchase date, both inclusive.
+	PurchasedFrom *time.Time
+	PurchasedTo   *time.Time
+	// MinTotalCents and MaxTotalCents limit the total, both inclusive.
+	MinTotalCents *int64
+	MaxTotalCents *int64
+	// ItemDescription matches receipts with at least one item whose description contains it, ignoring case.
+	ItemDescription string
+	// Cursor continues a previous search after the last receipt of its page.
+	Cursor string
+	// Limit is the maximum number of receipts returned. It defaults to DefaultPageSize and is capped at MaxPageSize.
+	Limit int
+}
+
+// Page is a part of the result of a Search, ordered by ID.
+type Page struct {
+	Receipts []StoredReceipt
+	// NextCursor continues the search with the next page. It is empty on the last page.
+	NextCursor string
+}
+
+// NormalizeRetailer reduces a retailer name to its letters and digits in lower case, so that different spellings of
+// the same name compare equal.
+func NormalizeRetailer(retailer string) string {
+	return strings.ToLower(utils.StripNonAlphanumeric(retailer))
+}
+
+// PageSize returns the effective page size for the query.
+func (q Query) PageSize() int {
+	if q.Limit <= 0 {
+		return DefaultPageSize
+	}
+	return min(q.Limit, MaxPageSize)
+}
+
+// AfterID returns the ID after which the page starts, decoded from the cursor.
+func (q Query) AfterID() (string, error) {
+	if q.Cursor == "" {
+		return "", nil
+	}
+	id, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil || len(id) == 0 {
+		return "", ErrInvalidCursor
+	}
+	return string(id), nil
+}
+
+// NewPage returns the page for receipts matching a query, ordered by ID. It expects up to one receipt more than the
+// page size, to determine whether there is a next page.
+func NewPage(receipts []StoredReceipt, pageSize int) Page {
+	if len(receipts) <= pageSize {
+		return Page{Receipts: receipts}
+	}
+	receipts = receipts[:pageSize]
+	lastID := receipts[len(receipts)-1].ID
+	return Page{Receipts: receipts, NextCursor: base64.RawURLEncoding.EncodeToString([]byte(lastID))}
+}
@@ -0,0 +1,239 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fetch-assessment/model"
+	"fetch-assessment/store"
+	"fmt"
+	"time"
+)
+
+// migration changes the schema within the given transaction.
+type migration func(tx *sql.Tx) error
+
+// migrations are applied in order, each exactly once. Applied migrations must never be changed; schema changes are
+// made by appending a new migration.
+var migrations = []migration{
+	// 1: receipts and their items
+	execMigration(`CREATE TABLE receipts (
+		id            TEXT PRIMARY KEY,
+		retailer      TEXT NOT NULL,
+		purchase_date TEXT NOT NULL,
+		purchase_time TEXT NOT NULL,
+		total         TEXT NOT NULL,
+		total_cents   INTEGER
+	);
+	CREATE TABLE items (
+		receipt_id        TEXT NOT NULL REFERENCES receipts (id) ON DELETE CASCADE,
+		position          INTEGER NOT NULL,
+		short_description TEXT NOT NULL,
+		price             TEXT NOT NULL,
+		price_cents       INTEGER,
+		PRIMARY KEY (receipt_id, position)
+	);
+	CREATE INDEX receipts_purchase_date ON receipts (purchase_date);
+	CREATE INDEX receipts_retailer ON receipts (retailer);`),
+	// 2: content fingerprints for duplicate detection
+	addFingerprints,
+	// 3: indexes for searching receipts
+	addSearchIndexes,
+	// 4: time the receipt was received
+	execMigration(`ALTER TABLE receipts ADD COLUMN received_at TEXT;`),
+	// 5: version history, starting with the current receipts as version 1
+	execMigration(`ALTER TABLE receipts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE receipts ADD COLUMN updated_at TEXT;
+	UPDATE receipts SET updated_at = received_at;
+	CREATE TABLE receipt_versions (
+		receipt_id    TEXT NOT NULL,
+		version       INTEGER NOT NULL,
+		retailer      TEXT NOT NULL,
+		purchase_date TEXT NOT NULL,
+		purchase_time TEXT NOT NULL,
+		total         TEXT NOT NULL,
+		received_at   TEXT,
+		updated_at    TEXT,
+		deleted       INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (receipt_id, version)
+	);
+	CREATE TABLE item_versions (
+		receipt_id        TEXT NOT NULL,
+		version           INTEGER NOT NULL,
+		position          INTEGER NOT NULL,
+		short_description TEXT NOT NULL,
+		price             TEXT NOT NULL,
+		PRIMARY KEY (receipt_id, version, position),
+		FOREIGN KEY (receipt_id, version) REFERENCES receipt_versions (receipt_id, version) ON DELETE CASCADE
+	);
+	INSERT INTO receipt_versions (receipt_id, version, retailer, purchase_date, purchase_time, total, received_at, updated_at)
+		SELECT id, 1, retailer, purchase_date, purchase_time, total, received_at, received_at FROM receipts;
+	INSERT INTO item_versions (receipt_id, version, position, short_description, price)
+		SELECT receipt_id, 1, position, short_description, price FROM items;`),
+	// 6: product category of every item, left empty for the items stored before
+	execMigration(`ALTER TABLE items ADD COLUMN category TEXT;
+	ALTER TABLE item_versions ADD COLUMN category TEXT;`),
+	// 7: time zone of the purchase date and time
+	execMigration(`ALTER TABLE receipts ADD COLUMN time_zone TEXT;
+	ALTER TABLE receipt_versions ADD COLUMN time_zone TEXT;`),
+	// 8: experiment variant assigned when the receipt was submitted
+	execMigration(`ALTER TABLE receipts ADD COLUMN experiment TEXT;
+	ALTER TABLE receipts ADD COLUMN variant TEXT;
+	ALTER TABLE receipt_versions ADD COLUMN experiment TEXT;
+	ALTER TABLE receipt_versions ADD COLUMN variant TEXT;`),
+}
+
+func execMigration(statements string) migration {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(statements)
+		return err
+	}
+}
+
+func addFingerprints(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE receipts ADD COLUMN fingerprint TEXT;
+		CREATE INDEX receipts_fingerprint ON receipts (fingerprint);`)
+	if err != nil {
+		return err
+	}
+
+	// the receipts are read with the schema of this migration, as listReceipts follows the latest schema
+	receipts := make(map[string]*model.Receipt)
+	rows, err := tx.Query(`SELECT id, retailer, purchase_date, purchase_time, total FROM receipts`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id, purchaseDate string
+		var receipt model.Receipt
+		if err := rows.Scan(&id, &receipt.Retailer, &purchaseDate, &receipt.PurchaseTime, &receipt.Total); err != nil {
+			rows.Close()
+			return err
+		}
+		if receipt.PurchaseDate, err = parseDate(purchaseDate); err != nil {
+			rows.Close()
+			return err
+		}
+		receipts[id] = &receipt
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = tx.Query(`SELECT receipt_id, short_description, price FROM items ORDER BY receipt_id, position`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		var item model.Item
+		if err := rows.Scan(&id, &item.ShortDescription, &item.Price); err != nil {
+			rows.Close()
+			return err
+		}
+		if receipt, ok := receipts[id]; ok {
+			receipt.Items = append(receipt.Items, item)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, receipt := range receipts {
+		_, err := tx.Exec(`UPDATE receipts SET fingerprint = ? WHERE id = ?`, store.Fingerprint(*receipt), id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrate brings the schema up to date, recording applied migrations in the schema_migrations table.
+func migrate(db *sql.DB) error {
+	return migrateTo(db, len(migrations))
+}
+
+// migrateTo applies all pending migrations up to and including the target version.
+func migrateTo(db *sql.DB, target int) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d", current, len(migrations))
+	}
+
+	for version := current + 1; version <= target; version++ {
+		if err := applyMigration(db, version); err != nil {
+			return fmt.Errorf("applying migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := migrations[version-1](tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`,
+		version, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addSearchIndexes(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE receipts ADD COLUMN retailer_key TEXT;
+		CREATE INDEX receipts_retailer_key ON receipts (retailer_key);
+		CREATE INDEX receipts_total_cents ON receipts (total_cents);
+		CREATE VIRTUAL TABLE item_descriptions USING fts5 (receipt_id UNINDEXED, short_description, tokenize = 'trigram');
+		INSERT INTO item_descriptions (receipt_id, short_description) SELECT receipt_id, short_description FROM items;
+		CREATE TRIGGER items_insert_description AFTER INSERT ON items BEGIN
+			INSERT INTO item_descriptions (receipt_id, short_description) VALUES (new.receipt_id, new.short_description);
+		END;
+		CREATE TRIGGER items_delete_description AFTER DELETE ON items BEGIN
+			DELETE FROM item_descriptions WHERE receipt_id = old.receipt_id;
+		END;`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT id, retailer FROM receipts`)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]string)
+	for rows.Next() {
+		var id, retailer string
+		if err := rows.Scan(&id, &retailer); err != nil {
+			rows.Close()
+			return err
+		}
+		keys[id] = store.NormalizeRetailer(retailer)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, key := range keys {
+		if _, err := tx.Exec(`UPDATE receipts SET retailer_key = ? WHERE id = ?`, key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,459 @@
+// Package sqlstore provides a store.ReceiptRepository backed by an embedded SQLite database. Receipts and their items
+// are kept in normalized tables, so that the database can also be used for reporting queries.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fetch-assessment/model"
+	"fetch-assessment/money"
+	"fetch-assessment/store"
+	"github.com/google/uuid"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const dateFormat = "2006-01-02"
+
+// SQLReceiptStore is a ReceiptRepository persisting receipts in a SQLite database.
+type SQLReceiptStore struct {
+	db *sql.DB
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// NewSQLReceiptStore opens the SQLite database at path, creating it if needed, and applies pending migrations.
+func NewSQLReceiptStore(path string) (*SQLReceiptStore, error) {
+	db, err := openDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLReceiptStore{db: db}, nil
+}
+
+func (s *SQLReceiptStore) Store(receipt model.Receipt) (uuid.UUID, error) {
+	receiptID, err := uuid.NewUUID()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	defer tx.Rollback()
+
+	if err := checkDuplicate(tx, receipt, ""); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	receivedAt := time.Now().UTC()
+	stored := store.StoredReceipt{
+		ID:         receiptID.String(),
+		Version:    1,
+		Receipt:    receipt,
+		ReceivedAt: receivedAt,
+		UpdatedAt:  receivedAt,
+	}
+	experiment, variant := experimentValues(receipt.Experiment)
+	_, err = tx.Exec(`INSERT INTO receipts
+		(id, retailer, retailer_key, purchase_date, purchase_time, time_zone, total, total_cents, fingerprint,
+		 received_at, version, updated_at, experiment, variant)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		stored.ID, receipt.Retailer, store.NormalizeRetailer(receipt.Retailer),
+		receipt.PurchaseDate.Format(dateFormat), receipt.PurchaseTime, receipt.TimeZone, receipt.Total,
+		nullCents(receipt.Total), store.Fingerprint(receipt), formatTime(stored.ReceivedAt), stored.Version,
+		formatTime(stored.UpdatedAt), experiment, variant)
+	if err != nil {
+		return uuid.UUID{}, err
+	}
+	if err := insertItems(tx, stored.ID, receipt.Items); err != nil {
+		return uuid.UUID{}, err
+	}
+	if err := insertVersion(tx, stored); err != nil {
+		return uuid.UUID{}, err
+	}
+	return receiptID, tx.Commit()
+}
+
+func (s *SQLReceiptStore) Update(id string, receipt model.Receipt) (store.StoredReceipt, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return store.StoredReceipt{}, err
+	}
+	defer tx.Rollback()
+
+	stored, err := getReceipt(tx, id)
+	if err != nil {
+		return store.StoredReceipt{}, err
+	}
+	if err := checkDuplicate(tx, receipt, id); err != nil {
+		return store.StoredReceipt{}, err
+	}
+
+	stored.Version++
+	stored.Receipt = receipt
+	stored.UpdatedAt = time.Now().UTC()
+	experiment, variant := experimentValues(receipt.Experiment)
+	_, err = tx.Exec(`UPDATE receipts SET retailer = ?, retailer_key = ?, purchase_date = ?, purchase_time = ?,
+		time_zone = ?, total = ?, total_cents = ?, fingerprint = ?, version = ?, updated_at = ?, experiment = ?,
+		variant = ? WHERE id = ?`,
+		receipt.Retailer, store.NormalizeRetailer(receipt.Retailer), receipt.PurchaseDate.Format(dateFormat),
+		receipt.PurchaseTime, receipt.TimeZone, receipt.Total, nullCents(receipt.Total), store.Fingerprint(receipt),
+		stored.Version, formatTime(stored.UpdatedAt), experiment, variant, id)
+	if err != nil {
+		return store.StoredReceipt{}, err
+	}
+	if _, err := tx.Exec(`DELETE FROM items WHERE receipt_id = ?`, id); err != nil {
+		return store.StoredReceipt{}, err
+	}
+	if err := insertItems(tx, id, receipt.Items); err != nil {
+		return store.StoredReceipt{}, err
+	}
+	if err := insertVersion(tx, stored); err != nil {
+		return store.StoredReceipt{}, err
+	}
+	return stored, tx.Commit()
+}
+
+// checkDuplicate returns a *store.DuplicateReceiptError if a receipt other than the one with the given ID has the
+// same fingerprint.
+func checkDuplicate(tx *sql.Tx, receipt model.Receipt, id string) error {
+	var existingID string
+	err := tx.QueryRow(`SELECT id FROM receipts WHERE fingerprint = ? AND id != ? LIMIT 1`,
+		store.Fingerprint(receipt), id).Scan(&existingID)
+	if err == nil {
+		return &store.DuplicateReceiptError{ExistingID: existingID}
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+func insertItems(tx *sql.Tx, id string, items []model.Item) error {
+	for i, item := range items {
+		_, err := tx.Exec(`INSERT INTO items (receipt_id, position, short_description, price, price_cents, category)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			id, i, item.ShortDescription, item.Price, nullCents(item.Price), item.Category)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertVersion adds the version to the history tables.
+func insertVersion(tx *sql.Tx, stored store.StoredReceipt) error {
+	receipt := stored.Receipt
+	experiment, variant := experimentValues(receipt.Experiment)
+	_, err := tx.Exec(`INSERT INTO receipt_versions
+		(receipt_id, version, retailer, purchase_date, purchase_time, time_zone, total, received_at, updated_at,
+		 deleted, experiment, variant)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		stored.ID, stored.Version, receipt.Retailer, receipt.PurchaseDate.Format(dateFormat), receipt.PurchaseTime,
+		receipt.TimeZone, receipt.Total, formatTime(stored.ReceivedAt), formatTime(stored.UpdatedAt), stored.Deleted,
+		experiment, variant)
+	if err != nil {
+		return err
+	}
+	for i, item := range receipt.Items {
+		_, err := tx.Exec(`INSERT INTO item_versions (receipt_id, version, position, short_description, price, category)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			stored.ID, stored.Version, i, item.ShortDescription, item.Price, item.Category)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLReceiptStore) Get(id string) (store.StoredReceipt, error) {
+	return getReceipt(s.db, id)
+}
+
+func getReceipt(q queryer, id string) (store.StoredReceipt, error) {
+	receipts, err := listReceipts(q, "WHERE id = ?", []any{id})
+	if err != nil {
+		return store.StoredReceipt{}, err
+	}
+	if len(receipts) == 0 {
+		return store.StoredReceipt{}, store.ErrReceiptNotFound
+	}
+	return receipts[0], nil
+}
+
+func (s *SQLReceiptStore) GetReceipt(id string) (*model.Receipt, error) {
+	stored, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &stored.Receipt, nil
+}
+
+func (s *SQLReceiptStore) List() ([]store.StoredReceipt, error) {
+	return listReceipts(s.db, "", nil)
+}
+
+func (s *SQLReceiptStore) Search(query store.Query) (store.Page, error) {
+	after, err := query.AfterID()
+	if err != nil {
+		return store.Page{}, err
+	}
+	pageSize := query.PageSize()
+
+	conditions := []string{"id > ?"}
+	args := []any{after}
+	if query.Retailer != "" {
+		conditions = append(conditions, "retailer_key = ?")
+		args = append(args, store.NormalizeRetailer(query.Retailer))
+	}
+	if query.PurchasedFrom != nil {
+		conditions = append(conditions, "purchase_date >= ?")
+		args = append(args, query.PurchasedFrom.Format(dateFormat))
+	}
+	if query.PurchasedTo != nil {
+		conditions = append(conditions, "purchase_date <= ?")
+		args = append(args, query.PurchasedTo.Format(dateFormat))
+	}
+	if query.MinTotalCents != nil {
+		conditions = append(conditions, "total_cents >= ?")
+		args = append(args, *query.MinTotalCents)
+	}
+	if query.MaxTotalCents != nil {
+		conditions = append(conditions, "total_cents <= ?")
+		args = append(args, *query.MaxTotalCents)
+	}
+	if query.ItemDescription != "" {
+		conditions = append(conditions, `id IN (SELECT receipt_id FROM item_descriptions
+			WHERE short_description LIKE ? ESCAPE '\')`)
+		args = append(args, "%"+escapeLike(query.ItemDescription)+"%")
+	}
+
+	filter := "WHERE " + strings.Join(conditions, " AND ") + " ORDER BY id LIMIT ?"
+	args = append(args, pageSize+1)
+	receipts, err := listReceipts(s.db, filter, args)
+	if err != nil {
+		return store.Page{}, err
+	}
+	return store.NewPage(receipts, pageSize), nil
+}
+
+// listReceipts loads the receipts selected by filter, a clause following "SELECT ... FROM receipts", ordered by ID.
+func listReceipts(q queryer, filter string, args []any) ([]store.StoredReceipt, error) {
+	if filter == "" {
+		filter = "ORDER BY id"
+	}
+	rows, err := q.Query(`SELECT id, version, retailer, purchase_date, purchase_time, time_zone, total, received_at,
+		updated_at, experiment, variant FROM receipts `+filter, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]store.StoredReceipt, 0)
+	positions := make(map[string]int)
+	for rows.Next() {
+		var stored store.StoredReceipt
+		var purchaseDate string
+		var receivedAt, updatedAt, experiment, variant sql.NullString
+		err := rows.Scan(&stored.ID, &stored.Version, &stored.Receipt.Retailer, &purchaseDate,
+			&stored.Receipt.PurchaseTime, &stored.Receipt.TimeZone, &stored.Receipt.Total, &receivedAt, &updatedAt,
+			&experiment, &variant)
+		if err != nil {
+			return nil, err
+		}
+		stored.Receipt.Experiment = experimentAssignment(experiment, variant)
+		if stored.Receipt.PurchaseDate, err = parseDate(purchaseDate); err != nil {
+			return nil, err
+		}
+		if stored.ReceivedAt, err = parseTime(receivedAt); err != nil {
+			return nil, err
+		}
+		if stored.UpdatedAt, err = parseTime(updatedAt); err != nil {
+			return nil, err
+		}
+		positions[stored.ID] = len(result)
+		result = append(result, stored)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// release the only connection before querying the items
+	rows.Close()
+
+	itemRows, err := q.Query(`SELECT receipt_id, short_description, price, category FROM items
+		WHERE receipt_id IN (SELECT id FROM receipts `+filter+`) ORDER BY receipt_id, position`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var receiptID string
+		var item model.Item
+		if err := itemRows.Scan(&receiptID, &item.ShortDescription, &item.Price, &item.Category); err != nil {
+			return nil, err
+		}
+		if i, ok := positions[receiptID]; ok {
+			result[i].Receipt.Items = append(result[i].Receipt.Items, item)
+		}
+	}
+	return result, itemRows.Err()
+}
+
+// Delete records the deletion in the version history and removes the receipt and its items from the current tables.
+func (s *SQLReceiptStore) Delete(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stored, err := getReceipt(tx, id)
+	if err != nil {
+		return err
+	}
+	stored.Version++
+	stored.UpdatedAt = time.Now().UTC()
+	stored.Deleted = true
+	if err := insertVersion(tx, stored); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM receipts WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLReceiptStore) Versions(id string) ([]store.StoredReceipt, error) {
+	rows, err := s.db.Query(`SELECT version, retailer, purchase_date, purchase_time, time_zone, total, received_at,
+		updated_at, deleted, experiment, variant FROM receipt_versions WHERE receipt_id = ? ORDER BY version`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make([]store.StoredReceipt, 0)
+	for rows.Next() {
+		stored := store.StoredReceipt{ID: id}
+		var purchaseDate string
+		var receivedAt, updatedAt, experiment, variant sql.NullString
+		err := rows.Scan(&stored.Version, &stored.Receipt.Retailer, &purchaseDate, &stored.Receipt.PurchaseTime,
+			&stored.Receipt.TimeZone, &stored.Receipt.Total, &receivedAt, &updatedAt, &stored.Deleted, &experiment,
+			&variant)
+		if err != nil {
+			return nil, err
+		}
+		stored.Receipt.Experiment = experimentAssignment(experiment, variant)
+		if stored.Receipt.PurchaseDate, err = parseDate(purchaseDate); err != nil {
+			return nil, err
+		}
+		if stored.ReceivedAt, err = parseTime(receivedAt); err != nil {
+			return nil, err
+		}
+		if stored.UpdatedAt, err = parseTime(updatedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, stored)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// release the only connection before querying the items
+	rows.Close()
+	if len(versions) == 0 {
+		return nil, store.ErrReceiptNotFound
+	}
+
+	itemRows, err := s.db.Query(`SELECT version, short_description, price, category FROM item_versions
+		WHERE receipt_id = ? ORDER BY version, position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var version int
+		var item model.Item
+		if err := itemRows.Scan(&version, &item.ShortDescription, &item.Price, &item.Category); err != nil {
+			return nil, err
+		}
+		// versions are numbered consecutively from 1
+		if version >= 1 && version <= len(versions) {
+			versions[version-1].Receipt.Items = append(versions[version-1].Receipt.Items, item)
+		}
+	}
+	return versions, itemRows.Err()
+}
+
+func openDatabase(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer only; a single connection avoids failing with SQLITE_BUSY under concurrent writes.
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// Close closes the underlying database.
+func (s *SQLReceiptStore) Close() error {
+	return s.db.Close()
+}
+
+func parseDate(value string) (openapi_types.Date, error) {
+	date, err := time.Parse(dateFormat, value)
+	if err != nil {
+		return openapi_types.Date{}, err
+	}
+	return openapi_types.Date{Time: date}, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseTime parses a time written by formatTime. Receipts stored before the time was recorded have none.
+func parseTime(value sql.NullString) (time.Time, error) {
+	if !value.Valid {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value.String)
+}
+
+// experimentValues returns the column values of an experiment assignment, which are NULL without one.
+func experimentValues(experiment *model.ExperimentAssignment) (sql.NullString, sql.NullString) {
+	if experiment == nil {
+		return sql.NullString{}, sql.NullString{}
+	}
+	return sql.NullString{String: experiment.Id, Valid: true}, sql.NullString{String: experiment.Variant, Valid: true}
+}
+
+// experimentAssignment converts the columns written by experimentValues back into an assignment.
+func experimentAssignment(experiment, variant sql.NullString) *model.ExperimentAssignment {
+	if !experiment.Valid {
+		return nil
+	}
+	return &model.ExperimentAssignment{Id: experiment.String, Variant: variant.String}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, using backslash as the escape character.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// nullCents converts an amount into cents for exact arithmetic in reporting queries. Amounts that cannot be parsed
+// are stored as NULL.
+func nullCents(amount string) sql.NullInt64 {
+	cents, err := money.Parse(amount)
+	return sql.NullInt64{Int64: cents.Cents(), Valid: err == nil}
+}
@@ -0,0 +1,139 @@
+package sqlstore
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"fetch-assessment/store"
+	"fetch-assessment/store/storetest"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLReceiptStoreConformance(t *testing.T) {
+	storetest.RunRepositoryTests(t, func(t *testing.T) store.ReceiptRepository {
+		return newTestStore(t, filepath.Join(t.TempDir(), "receipts.db"))
+	})
+}
+
+func TestSQLReceiptStorePersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	repo, err := NewSQLReceiptStore(path)
+	require.NoError(t, err)
+	id, err := repo.Store(model.Receipt{Retailer: "Target", Total: "1.25", Items: []model.Item{{ShortDescription: "Gum", Price: "1.25"}}})
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	reopened := newTestStore(t, path)
+	receipt, err := reopened.GetReceipt(id.String())
+	require.NoError(t, err)
+	assert.Equal(t, "Target", receipt.Retailer)
+	assert.Equal(t, []model.Item{{ShortDescription: "Gum", Price: "1.25"}}, receipt.Items)
+}
+
+func TestMigrationsAreAppliedOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	repo, err := NewSQLReceiptStore(path)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	reopened := newTestStore(t, path)
+	var version, count int
+	require.NoError(t, reopened.db.QueryRow(`SELECT MAX(version), COUNT(*) FROM schema_migrations`).Scan(&version, &count))
+	assert.Equal(t, len(migrations), version)
+	assert.Equal(t, len(migrations), count)
+}
+
+func TestNewerSchemaIsRejected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	repo, err := NewSQLReceiptStore(path)
+	require.NoError(t, err)
+	_, err = repo.db.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES (?, 'now')`, len(migrations)+1)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	_, err = NewSQLReceiptStore(path)
+	assert.Error(t, err)
+}
+
+func TestItemsAreDeletedWithReceipt(t *testing.T) {
+	repo := newTestStore(t, filepath.Join(t.TempDir(), "receipts.db"))
+	id, err := repo.Store(model.Receipt{Retailer: "Target", Items: []model.Item{{ShortDescription: "Gum", Price: "1.25"}}})
+	require.NoError(t, err)
+
+	require.NoError(t, repo.Delete(id.String()))
+
+	var count int
+	require.NoError(t, repo.db.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&count))
+	assert.Equal(t, 0, count)
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, `100\% juice\_x\\`, escapeLike(`100% juice_x\`))
+}
+
+func newTestStore(t *testing.T, path string) *SQLReceiptStore {
+	repo, err := NewSQLReceiptStore(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func TestFingerprintMigrationBackfillsExistingReceipts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	db, err := openDatabase(path)
+	require.NoError(t, err)
+	require.NoError(t, migrateTo(db, 1))
+	_, err = db.Exec(`INSERT INTO receipts (id, retailer, purchase_date, purchase_time, total)
+		VALUES ('existing', 'Target', '2022-01-01', '13:01', '1.25');
+		INSERT INTO items (receipt_id, position, short_description, price) VALUES ('existing', 0, 'Gum', '1.25');`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	repo := newTestStore(t, path)
+	_, err = repo.Store(model.Receipt{
+		Retailer:     "Target",
+		PurchaseDate: mustParseDate("2022-01-01"),
+		PurchaseTime: "13:01",
+		Total:        "1.25",
+		Items:        []model.Item{{ShortDescription: "Gum", Price: "1.25"}},
+	})
+	var duplicateErr *store.DuplicateReceiptError
+	require.True(t, errors.As(err, &duplicateErr))
+	assert.Equal(t, "existing", duplicateErr.ExistingID)
+}
+
+func TestVersionMigrationRecordsExistingReceiptsAsFirstVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "receipts.db")
+	db, err := openDatabase(path)
+	require.NoError(t, err)
+	require.NoError(t, migrateTo(db, 4))
+	_, err = db.Exec(`INSERT INTO receipts (id, retailer, purchase_date, purchase_time, total, received_at)
+		VALUES ('existing', 'Target', '2022-01-01', '13:01', '1.25', '2022-01-02T10:00:00Z');
+		INSERT INTO items (receipt_id, position, short_description, price) VALUES ('existing', 0, 'Gum', '1.25');`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	repo := newTestStore(t, path)
+	stored, err := repo.Get("existing")
+	require.NoError(t, err)
+	assert.Equal(t, 1, stored.Version)
+	assert.Equal(t, stored.ReceivedAt, stored.UpdatedAt)
+	versions, err := repo.Versions("existing")
+	require.NoError(t, err)
+	assert.Equal(t, []store.StoredReceipt{stored}, versions)
+
+	updated, err := repo.Update("existing", model.Receipt{Retailer: "Walgreens", PurchaseDate: mustParseDate("2022-01-01")})
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated.Version)
+}
+
+func mustParseDate(dateStr string) openapi_types.Date {
+	date, err := parseDate(dateStr)
+	if err != nil {
+		panic(err)
+	}
+	return date
+}
@@ -4,34 +4,344 @@ import (
 	"errors"
 	"fetch-assessment/model"
 	"github.com/google/uuid"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
 )
 
 var ErrReceiptNotFound = errors.New("receipt not found")
 
-// ReceiptStore uses a simple in-memory map for data storage
+// ReceiptRepository is the storage abstraction used by the handlers. Every backend must pass the conformance
+// suite in the storetest package.
+//
+// Receipts are never modified in place. Every update and deletion adds a version to the history of the receipt, which
+// remains available through Versions. All other methods see only the latest version of receipts that are not deleted.
+type ReceiptRepository interface {
+	// Store saves the receipt as version 1 and returns the newly assigned ID. If a receipt with the same Fingerprint
+	// is already stored, it returns a *DuplicateReceiptError.
+	Store(receipt model.Receipt) (uuid.UUID, error)
+	// Get returns the latest version of the receipt with the given ID along with its metadata, or ErrReceiptNotFound.
+	Get(id string) (StoredReceipt, error)
+	// GetReceipt returns the latest version of the receipt with the given ID, or ErrReceiptNotFound.
+	GetReceipt(id string) (*model.Receipt, error)
+	// List returns all stored receipts, ordered by ID.
+	List() ([]StoredReceipt, error)
+	// Search returns a page of the receipts matching the query, or ErrInvalidCursor.
+	Search(query Query) (Page, error)
+	// Update saves the receipt as the next version of the receipt with the given ID and returns that version. It
+	// returns ErrReceiptNotFound if there is no such receipt, and a *DuplicateReceiptError if another receipt has the
+	// same Fingerprint.
+	Update(id string, receipt model.Receipt) (StoredReceipt, error)
+	// Delete adds a deleted version to the receipt with the given ID, or returns ErrReceiptNotFound.
+	Delete(id string) error
+	// Versions returns all versions of the receipt with the given ID, including the deleted one, ordered by version.
+	// It returns ErrReceiptNotFound if no receipt was ever stored under the ID.
+	Versions(id string) ([]StoredReceipt, error)
+}
+
+// StoredReceipt is a version of a receipt together with the ID it was stored under and its metadata.
+type StoredReceipt struct {
+	ID      string
+	Version int
+	Receipt model.Receipt
+	// ReceivedAt is the time the receipt was first submitted; it is the same for all versions.
+	ReceivedAt time.Time
+	// UpdatedAt is the time this version was saved.
+	UpdatedAt time.Time
+	// Deleted marks the version recording the deletion. It carries the receipt of the version before it.
+	Deleted bool
+}
+
+// shardCount is the number of independently locked partitions of the store. It must be a power of two.
+const shardCount = 32
+
+// ReceiptStore is the in-memory ReceiptRepository, safe for concurrent use. Receipts are partitioned into shards by ID,
+// each guarded by its own lock, so that reads do not block behind writes to other receipts.
 type ReceiptStore struct {
-	receipts map[string]model.Receipt
+	shards [shardCount]*shard
+	// writeMu serializes writes, so that the duplicate check and the insert are atomic. It is acquired before any
+	// shard lock.
+	writeMu sync.Mutex
+	// fingerprints maps the Fingerprint of every receipt that is not deleted to its ID.
+	fingerprints map[string]string
+	index        *searchIndex
+}
+
+type shard struct {
+	mu sync.RWMutex
+	// histories holds the versions of every receipt, ordered by version.
+	histories map[string][]StoredReceipt
 }
 
 func NewReceiptStore() *ReceiptStore {
-	return &ReceiptStore{
-		receipts: make(map[string]model.Receipt),
+	s := &ReceiptStore{
+		fingerprints: make(map[string]string),
+		index:        newSearchIndex(),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{histories: make(map[string][]StoredReceipt)}
 	}
+	return s
 }
 
 func (r *ReceiptStore) Store(receipt model.Receipt) (uuid.UUID, error) {
-	receiptID, err := uuid.NewUUID()
+	stored, err := r.write(func() (StoredReceipt, error) { return r.firstVersionLocked(receipt) }, nil)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
-	r.receipts[receiptID.String()] = receipt
-	return receiptID, nil
+	return uuid.MustParse(stored.ID), nil
+}
+
+func (r *ReceiptStore) Update(id string, receipt model.Receipt) (StoredReceipt, error) {
+	return r.write(func() (StoredReceipt, error) { return r.updatedVersionLocked(id, receipt) }, nil)
 }
 
-func (r *ReceiptStore) GetReceipt(id string) *model.Receipt {
-	receipt, ok := r.receipts[id]
-	if !ok {
-		return nil
+func (r *ReceiptStore) Delete(id string) error {
+	_, err := r.write(func() (StoredReceipt, error) { return r.deletedVersionLocked(id) }, nil)
+	return err
+}
+
+// write creates the next version with next and applies it, holding writeMu throughout. If persist is set, it is called
+// before the version is applied, and the version is discarded if it fails.
+func (r *ReceiptStore) write(next func() (StoredReceipt, error), persist func(StoredReceipt) error) (StoredReceipt, error) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	version, err := next()
+	if err != nil {
+		return StoredReceipt{}, err
+	}
+	if persist != nil {
+		if err := persist(version); err != nil {
+			return StoredReceipt{}, err
+		}
+	}
+	r.applyLocked(version)
+	return cloneStoredReceipt(version), nil
+}
+
+// firstVersionLocked returns version 1 of a new receipt, or a *DuplicateReceiptError.
+func (r *ReceiptStore) firstVersionLocked(receipt model.Receipt) (StoredReceipt, error) {
+	if existingID, ok := r.fingerprints[Fingerprint(receipt)]; ok {
+		return StoredReceipt{}, &DuplicateReceiptError{ExistingID: existingID}
+	}
+	receiptID, err := uuid.NewUUID()
+	if err != nil {
+		return StoredReceipt{}, err
+	}
+	receivedAt := now()
+	return StoredReceipt{
+		ID:         receiptID.String(),
+		Version:    1,
+		Receipt:    receipt,
+		ReceivedAt: receivedAt,
+		UpdatedAt:  receivedAt,
+	}, nil
+}
+
+// updatedVersionLocked returns the version following the latest version of the receipt, with its content replaced.
+func (r *ReceiptStore) updatedVersionLocked(id string, receipt model.Receipt) (StoredReceipt, error) {
+	latest, err := r.Get(id)
+	if err != nil {
+		return StoredReceipt{}, err
+	}
+	if existingID, ok := r.fingerprints[Fingerprint(receipt)]; ok && existingID != id {
+		return StoredReceipt{}, &DuplicateReceiptError{ExistingID: existingID}
+	}
+	latest.Version++
+	latest.Receipt = receipt
+	latest.UpdatedAt = now()
+	return latest, nil
+}
+
+// deletedVersionLocked returns the version following the latest version of the receipt, marking it as deleted.
+func (r *ReceiptStore) deletedVersionLocked(id string) (StoredReceipt, error) {
+	latest, err := r.Get(id)
+	if err != nil {
+		return StoredReceipt{}, err
+	}
+	latest.Version++
+	latest.UpdatedAt = now()
+	latest.Deleted = true
+	return latest, nil
+}
+
+// apply appends the version to the history of its receipt. A version that is already part of the history is ignored,
+// so that restoring the same version twice is harmless.
+func (r *ReceiptStore) apply(version StoredReceipt) {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.applyLocked(version)
+}
+
+func (r *ReceiptStore) applyLocked(version StoredReceipt) {
+	id := version.ID
+	s := r.shardFor(id)
+	s.mu.Lock()
+	history := s.histories[id]
+	if len(history) >= version.Version {
+		s.mu.Unlock()
+		return
+	}
+	s.histories[id] = append(history, cloneStoredReceipt(version))
+	s.mu.Unlock()
+
+	if len(history) > 0 && !history[len(history)-1].Deleted {
+		previous := history[len(history)-1].Receipt
+		r.removeFingerprint(id, previous)
+		r.index.remove(id, previous)
+	}
+	if !version.Deleted {
+		r.fingerprints[Fingerprint(version.Receipt)] = id
+		r.index.add(id, version.Receipt)
+	}
+}
+
+func (r *ReceiptStore) removeFingerprint(id string, receipt model.Receipt) {
+	fingerprint := Fingerprint(receipt)
+	if r.fingerprints[fingerprint] == id {
+		delete(r.fingerprints, fingerprint)
+	}
+}
+
+func (r *ReceiptStore) Get(id string) (StoredReceipt, error) {
+	s := r.shardFor(id)
+	s.mu.RLock()
+	history := s.histories[id]
+	s.mu.RUnlock()
+	if len(history) == 0 || history[len(history)-1].Deleted {
+		return StoredReceipt{}, ErrReceiptNotFound
+	}
+	return cloneStoredReceipt(history[len(history)-1]), nil
+}
+
+func (r *ReceiptStore) GetReceipt(id string) (*model.Receipt, error) {
+	stored, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &stored.Receipt, nil
+}
+
+func (r *ReceiptStore) Versions(id string) ([]StoredReceipt, error) {
+	s := r.shardFor(id)
+	s.mu.RLock()
+	history := s.histories[id]
+	s.mu.RUnlock()
+	if len(history) == 0 {
+		return nil, ErrReceiptNotFound
+	}
+	return cloneHistory(history), nil
+}
+
+func (r *ReceiptStore) List() ([]StoredReceipt, error) {
+	result := make([]StoredReceipt, 0)
+	for _, s := range r.shards {
+		s.mu.RLock()
+		for _, history := range s.histories {
+			if latest := history[len(history)-1]; !latest.Deleted {
+				result = append(result, cloneStoredReceipt(latest))
+			}
+		}
+		s.mu.RUnlock()
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// histories returns the versions of all receipts, including deleted ones, ordered by ID.
+func (r *ReceiptStore) histories() [][]StoredReceipt {
+	result := make([][]StoredReceipt, 0)
+	for _, s := range r.shards {
+		s.mu.RLock()
+		for _, history := range s.histories {
+			result = append(result, cloneHistory(history))
+		}
+		s.mu.RUnlock()
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i][0].ID < result[j][0].ID
+	})
+	return result
+}
+
+func (r *ReceiptStore) Search(query Query) (Page, error) {
+	after, err := query.AfterID()
+	if err != nil {
+		return Page{}, err
+	}
+	pageSize := query.PageSize()
+	ids := r.index.search(query, after, pageSize+1)
+
+	receipts := make([]StoredReceipt, 0, len(ids))
+	for _, id := range ids {
+		// the receipt may have been deleted since the index was searched
+		if stored, err := r.Get(id); err == nil {
+			receipts = append(receipts, stored)
+		}
+	}
+	return NewPage(receipts, pageSize), nil
+}
+
+// len returns the number of receipts that are not deleted across all shards.
+func (r *ReceiptStore) len() int {
+	count := 0
+	for _, s := range r.shards {
+		s.mu.RLock()
+		for _, history := range s.histories {
+			if !history[len(history)-1].Deleted {
+				count++
+			}
+		}
+		s.mu.RUnlock()
+	}
+	return count
+}
+
+func (r *ReceiptStore) shardFor(id string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return r.shards[h.Sum32()&(shardCount-1)]
+}
+
+// now returns the current time, without monotonic clock reading, as recorded for received receipts.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+func cloneStoredReceipt(stored StoredReceipt) StoredReceipt {
+	stored.Receipt = cloneReceipt(stored.Receipt)
+	return stored
+}
+
+func cloneHistory(history []StoredReceipt) []StoredReceipt {
+	versions := make([]StoredReceipt, 0, len(history))
+	for _, version := range history {
+		versions = append(versions, cloneStoredReceipt(version))
+	}
+	return versions
+}
+
+// cloneReceipt copies the items of a receipt, so that callers cannot modify stored data through the shared slice or
+// the item categories, and the time zone.
+func cloneReceipt(receipt model.Receipt) model.Receipt {
+	if receipt.TimeZone != nil {
+		timeZone := *receipt.TimeZone
+		receipt.TimeZone = &timeZone
+	}
+	if receipt.Experiment != nil {
+		experiment := *receipt.Experiment
+		receipt.Experiment = &experiment
+	}
+	receipt.Items = append([]model.Item(nil), receipt.Items...)
+	for i, item := range receipt.Items {
+		if item.Category != nil {
+			category := *item.Category
+			receipt.Items[i].Category = &category
+		}
 	}
-	return &receipt
+	return receipt
 }
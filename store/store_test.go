@@ -2,7 +2,11 @@ package store
 
 import (
 	"fetch-assessment/model"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -10,19 +14,122 @@ func TestStore(t *testing.T) {
 
 	store := NewReceiptStore()
 
-	assert.Equal(t, 0, len(store.receipts))
+	assert.Equal(t, 0, store.len())
 
 	uuid, err := store.Store(model.Receipt{
 		Retailer: "test",
 	})
 	assert.NoError(t, err)
 
-	assert.Equal(t, 1, len(store.receipts))
+	assert.Equal(t, 1, store.len())
 
-	item := store.GetReceipt(uuid.String())
+	item, err := store.GetReceipt(uuid.String())
+	assert.NoError(t, err)
 
-	assert.Equal(t, 1, len(store.receipts))
+	assert.Equal(t, 1, store.len())
 
 	assert.Equal(t, "test", item.Retailer)
 
 }
+
+// The following tests are meant to be run with the race detector enabled: go test -race ./store/...
+
+func TestConcurrentStore(t *testing.T) {
+	const writers = 50
+	const receiptsPerWriter = 200
+
+	store := NewReceiptStore()
+	ids := make(chan string, writers*receiptsPerWriter)
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < receiptsPerWriter; i++ {
+				id, err := store.Store(model.Receipt{Retailer: fmt.Sprintf("retailer %d of worker %d", i, w)})
+				assert.NoError(t, err)
+				ids <- id.String()
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "duplicate id %s", id)
+		seen[id] = true
+		_, err := store.GetReceipt(id)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, writers*receiptsPerWriter, store.len())
+}
+
+func TestConcurrentStoreAndGet(t *testing.T) {
+	const workers = 20
+	const iterations = 500
+
+	store := NewReceiptStore()
+	seed, err := store.Store(model.Receipt{Retailer: "seed"})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				id, err := store.Store(model.Receipt{Retailer: fmt.Sprintf("retailer %d of worker %d", i, w)})
+				assert.NoError(t, err)
+				receipt, err := store.GetReceipt(id.String())
+				if assert.NoError(t, err) {
+					assert.Equal(t, fmt.Sprintf("retailer %d of worker %d", i, w), receipt.Retailer)
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				receipt, err := store.GetReceipt(seed.String())
+				if assert.NoError(t, err) {
+					assert.Equal(t, "seed", receipt.Retailer)
+				}
+				store.GetReceipt("unknown")
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, workers*iterations+1, store.len())
+}
+
+func TestShardDistribution(t *testing.T) {
+	store := NewReceiptStore()
+	for i := 0; i < shardCount*100; i++ {
+		_, err := store.Store(model.Receipt{Retailer: fmt.Sprintf("retailer %d", i)})
+		require.NoError(t, err)
+	}
+	for i, s := range store.shards {
+		assert.NotEmpty(t, s.histories, "shard %d is empty", i)
+	}
+}
+
+func BenchmarkConcurrentStoreAndGet(b *testing.B) {
+	store := NewReceiptStore()
+	id, err := store.Store(model.Receipt{Retailer: "bench"})
+	require.NoError(b, err)
+
+	var count atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%4 == 0 {
+				store.Store(model.Receipt{Retailer: fmt.Sprintf("bench %d", count.Add(1))})
+			} else {
+				store.GetReceipt(id.String())
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,599 @@
+// Package storetest provides the conformance suite every store.ReceiptRepository implementation must pass.
+package storetest
+
+import (
+	"errors"
+	"fetch-assessment/model"
+	"fetch-assessment/store"
+	"fmt"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RunRepositoryTests runs the conformance suite against the repositories returned by newRepository.
+// Every subtest calls newRepository once and expects an empty repository.
+func RunRepositoryTests(t *testing.T, newRepository func(t *testing.T) store.ReceiptRepository) {
+	t.Run("store and get", func(t *testing.T) {
+		repo := newRepository(t)
+		receipt := sampleReceipt("Target")
+
+		id, err := repo.Store(receipt)
+		require.NoError(t, err)
+
+		got, err := repo.GetReceipt(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, receipt, *got)
+	})
+
+	t.Run("get unknown id", func(t *testing.T) {
+		repo := newRepository(t)
+
+		got, err := repo.GetReceipt("unknown")
+		assert.Nil(t, got)
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+		_, err = repo.Get("unknown")
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+	})
+
+	t.Run("get with metadata", func(t *testing.T) {
+		repo := newRepository(t)
+		receipt := sampleReceipt("Target")
+
+		before := time.Now()
+		id, err := repo.Store(receipt)
+		require.NoError(t, err)
+		after := time.Now()
+
+		stored, err := repo.Get(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, id.String(), stored.ID)
+		assert.Equal(t, receipt, stored.Receipt)
+		assert.Equal(t, 1, stored.Version)
+		assert.False(t, stored.Deleted)
+		assert.Equal(t, stored.ReceivedAt, stored.UpdatedAt)
+		assert.Equal(t, time.UTC, stored.ReceivedAt.Location())
+		assert.False(t, stored.ReceivedAt.Before(before), "received at %v before %v", stored.ReceivedAt, before)
+		assert.False(t, stored.ReceivedAt.After(after), "received at %v after %v", stored.ReceivedAt, after)
+
+		listed, err := repo.List()
+		require.NoError(t, err)
+		assert.Equal(t, []store.StoredReceipt{stored}, listed)
+		page, err := repo.Search(store.Query{})
+		require.NoError(t, err)
+		assert.Equal(t, []store.StoredReceipt{stored}, page.Receipts)
+	})
+
+	t.Run("item categories are kept", func(t *testing.T) {
+		repo := newRepository(t)
+		receipt := sampleReceipt("Target")
+		beverages := "beverages"
+		receipt.Items[0].Category = &beverages
+
+		id, err := repo.Store(receipt)
+		require.NoError(t, err)
+		stored, err := repo.Get(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, receipt, stored.Receipt)
+
+		*stored.Receipt.Items[0].Category = "modified"
+		versions, err := repo.Versions(id.String())
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, receipt.Items, versions[0].Receipt.Items)
+		assert.Equal(t, "beverages", beverages)
+	})
+
+	t.Run("time zone is kept", func(t *testing.T) {
+		repo := newRepository(t)
+		receipt := sampleReceipt("Target")
+		timeZone := "America/Chicago"
+		receipt.TimeZone = &timeZone
+
+		id, err := repo.Store(receipt)
+		require.NoError(t, err)
+		stored, err := repo.Get(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, receipt, stored.Receipt)
+		versions, err := repo.Versions(id.String())
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, receipt, versions[0].Receipt)
+	})
+
+	t.Run("experiment assignment is kept", func(t *testing.T) {
+		repo := newRepository(t)
+		receipt := sampleReceipt("Target")
+		receipt.Experiment = &model.ExperimentAssignment{Id: "item-pairs-points", Variant: "double"}
+
+		id, err := repo.Store(receipt)
+		require.NoError(t, err)
+		stored, err := repo.Get(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, receipt, stored.Receipt)
+		updated, err := repo.Update(id.String(), receipt)
+		require.NoError(t, err)
+		assert.Equal(t, receipt, updated.Receipt)
+		versions, err := repo.Versions(id.String())
+		require.NoError(t, err)
+		require.Len(t, versions, 2)
+		assert.Equal(t, receipt, versions[1].Receipt)
+	})
+
+	t.Run("store assigns unique ids", func(t *testing.T) {
+		repo := newRepository(t)
+
+		first, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		second, err := repo.Store(sampleReceipt("Walgreens"))
+		require.NoError(t, err)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("returned receipt is a copy", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+
+		got, err := repo.GetReceipt(id.String())
+		require.NoError(t, err)
+		got.Items[0].ShortDescription = "modified"
+		got.Retailer = "modified"
+
+		again, err := repo.GetReceipt(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, sampleReceipt("Target"), *again)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		repo := newRepository(t)
+
+		empty, err := repo.List()
+		require.NoError(t, err)
+		assert.Empty(t, empty)
+
+		ids := make([]string, 0)
+		for _, retailer := range []string{"Target", "Walgreens", "M&M Corner Market"} {
+			id, err := repo.Store(sampleReceipt(retailer))
+			require.NoError(t, err)
+			ids = append(ids, id.String())
+		}
+		sort.Strings(ids)
+
+		listed, err := repo.List()
+		require.NoError(t, err)
+		require.Len(t, listed, 3)
+		for i, stored := range listed {
+			assert.Equal(t, ids[i], stored.ID)
+			got, err := repo.GetReceipt(stored.ID)
+			require.NoError(t, err)
+			assert.Equal(t, *got, stored.Receipt)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		repo := newRepository(t)
+		kept, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		deleted, err := repo.Store(sampleReceipt("Walgreens"))
+		require.NoError(t, err)
+
+		require.NoError(t, repo.Delete(deleted.String()))
+
+		_, err = repo.GetReceipt(deleted.String())
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+		_, err = repo.GetReceipt(kept.String())
+		assert.NoError(t, err)
+
+		listed, err := repo.List()
+		require.NoError(t, err)
+		require.Len(t, listed, 1)
+		assert.Equal(t, kept.String(), listed[0].ID)
+	})
+
+	t.Run("delete unknown id", func(t *testing.T) {
+		repo := newRepository(t)
+
+		err := repo.Delete("unknown")
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+	})
+
+	t.Run("delete deleted receipt", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		require.NoError(t, repo.Delete(id.String()))
+
+		err = repo.Delete(id.String())
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+	})
+
+	t.Run("update", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		original, err := repo.Get(id.String())
+		require.NoError(t, err)
+		corrected := receipt("Target", "2022-01-02", "12.00", "Mountain Dew 12PK")
+
+		updated, err := repo.Update(id.String(), corrected)
+		require.NoError(t, err)
+		assert.Equal(t, id.String(), updated.ID)
+		assert.Equal(t, 2, updated.Version)
+		assert.Equal(t, corrected, updated.Receipt)
+		assert.Equal(t, original.ReceivedAt, updated.ReceivedAt)
+		assert.False(t, updated.UpdatedAt.Before(original.UpdatedAt))
+
+		got, err := repo.Get(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, updated, got)
+		listed, err := repo.List()
+		require.NoError(t, err)
+		assert.Equal(t, []store.StoredReceipt{updated}, listed)
+	})
+
+	t.Run("update unknown id", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.Update("unknown", sampleReceipt("Target"))
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+	})
+
+	t.Run("update deleted receipt", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		require.NoError(t, repo.Delete(id.String()))
+
+		_, err = repo.Update(id.String(), sampleReceipt("Walgreens"))
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+	})
+
+	t.Run("update to duplicate is rejected", func(t *testing.T) {
+		repo := newRepository(t)
+		existing, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		id, err := repo.Store(sampleReceipt("Walgreens"))
+		require.NoError(t, err)
+
+		_, err = repo.Update(id.String(), sampleReceipt("Target"))
+
+		var duplicateErr *store.DuplicateReceiptError
+		require.True(t, errors.As(err, &duplicateErr))
+		assert.Equal(t, existing.String(), duplicateErr.ExistingID)
+		got, err := repo.Get(id.String())
+		require.NoError(t, err)
+		assert.Equal(t, 1, got.Version)
+		assert.Equal(t, sampleReceipt("Walgreens"), got.Receipt)
+	})
+
+	t.Run("update with unchanged content", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+
+		updated, err := repo.Update(id.String(), sampleReceipt("Target"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, updated.Version)
+	})
+
+	t.Run("updated receipt releases its previous content", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		_, err = repo.Update(id.String(), sampleReceipt("Walgreens"))
+		require.NoError(t, err)
+
+		_, err = repo.Store(sampleReceipt("Target"))
+		assert.NoError(t, err)
+		_, err = repo.Store(sampleReceipt("Walgreens"))
+		var duplicateErr *store.DuplicateReceiptError
+		assert.True(t, errors.As(err, &duplicateErr))
+	})
+
+	t.Run("versions", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		first, err := repo.Get(id.String())
+		require.NoError(t, err)
+		second, err := repo.Update(id.String(), sampleReceipt("Walgreens"))
+		require.NoError(t, err)
+		require.NoError(t, repo.Delete(id.String()))
+
+		versions, err := repo.Versions(id.String())
+		require.NoError(t, err)
+		require.Len(t, versions, 3)
+		assert.Equal(t, first, versions[0])
+		assert.Equal(t, second, versions[1])
+		deleted := versions[2]
+		assert.Equal(t, 3, deleted.Version)
+		assert.True(t, deleted.Deleted)
+		assert.Equal(t, second.Receipt, deleted.Receipt)
+		assert.Equal(t, first.ReceivedAt, deleted.ReceivedAt)
+		assert.False(t, deleted.UpdatedAt.Before(second.UpdatedAt))
+	})
+
+	t.Run("versions of unknown id", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.Versions("unknown")
+		assert.True(t, errors.Is(err, store.ErrReceiptNotFound))
+	})
+
+	t.Run("duplicate receipt is rejected", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+
+		duplicate := sampleReceipt("TARGET ")
+		duplicate.Items[0], duplicate.Items[1] = duplicate.Items[1], duplicate.Items[0]
+		_, err = repo.Store(duplicate)
+
+		var duplicateErr *store.DuplicateReceiptError
+		require.True(t, errors.As(err, &duplicateErr))
+		assert.Equal(t, id.String(), duplicateErr.ExistingID)
+		listed, err := repo.List()
+		require.NoError(t, err)
+		assert.Len(t, listed, 1)
+	})
+
+	t.Run("deleted receipt can be stored again", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		require.NoError(t, repo.Delete(id.String()))
+
+		_, err = repo.Store(sampleReceipt("Target"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("concurrent duplicates are stored once", func(t *testing.T) {
+		const workers = 10
+
+		repo := newRepository(t)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		stored, duplicates := 0, 0
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repo.Store(sampleReceipt("Target"))
+				var duplicateErr *store.DuplicateReceiptError
+				mu.Lock()
+				defer mu.Unlock()
+				if errors.As(err, &duplicateErr) {
+					duplicates++
+				} else if assert.NoError(t, err) {
+					stored++
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, stored)
+		assert.Equal(t, workers-1, duplicates)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		repo := newRepository(t)
+		ids := make(map[string]string)
+		for _, r := range []model.Receipt{
+			receipt("Target", "2022-01-01", "35.35", "Mountain Dew 12PK", "Emils Cheese Pizza"),
+			receipt("TARGET", "2022-01-15", "9.00", "Gatorade"),
+			receipt("Walgreens", "2022-02-01", "2.65", "Pepsi - 12-oz", "Dasani"),
+			receipt("M&M Corner Market", "2022-03-20", "100.00", "100% Juice", "Mountain Dew 6PK"),
+		} {
+			id, err := repo.Store(r)
+			require.NoError(t, err)
+			ids[r.Retailer] = id.String()
+		}
+
+		tests := []struct {
+			name  string
+			query store.Query
+			want  []string
+		}{
+			{"no filter", store.Query{}, []string{"Target", "TARGET", "Walgreens", "M&M Corner Market"}},
+			{"retailer", store.Query{Retailer: "target"}, []string{"Target", "TARGET"}},
+			{"retailer normalized", store.Query{Retailer: "m & m corner market"}, []string{"M&M Corner Market"}},
+			{"retailer is not a prefix match", store.Query{Retailer: "Tar"}, nil},
+			{"purchased from", store.Query{PurchasedFrom: date("2022-01-15")}, []string{"TARGET", "Walgreens", "M&M Corner Market"}},
+			{"purchased to", store.Query{PurchasedTo: date("2022-01-15")}, []string{"Target", "TARGET"}},
+			{"purchase date range", store.Query{PurchasedFrom: date("2022-01-02"), PurchasedTo: date("2022-02-01")}, []string{"TARGET", "Walgreens"}},
+			{"min total", store.Query{MinTotalCents: cents(900)}, []string{"Target", "TARGET", "M&M Corner Market"}},
+			{"max total", store.Query{MaxTotalCents: cents(900)}, []string{"TARGET", "Walgreens"}},
+			{"total range", store.Query{MinTotalCents: cents(266), MaxTotalCents: cents(3535)}, []string{"Target", "TARGET"}},
+			{"item description", store.Query{ItemDescription: "mountain dew"}, []string{"Target", "M&M Corner Market"}},
+			{"short item description", store.Query{ItemDescription: "6p"}, []string{"M&M Corner Market"}},
+			{"item description with wildcard", store.Query{ItemDescription: "0% j"}, []string{"M&M Corner Market"}},
+			{"item description is not a pattern", store.Query{ItemDescription: "m_untain"}, nil},
+			{"combined filters", store.Query{Retailer: "Target", ItemDescription: "dew"}, []string{"Target"}},
+			{"no match", store.Query{Retailer: "Target", PurchasedFrom: date("2023-01-01")}, nil},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				want := make([]string, 0)
+				for _, retailer := range tc.want {
+					want = append(want, ids[retailer])
+				}
+				sort.Strings(want)
+
+				page, err := repo.Search(tc.query)
+				require.NoError(t, err)
+				assert.Empty(t, page.NextCursor)
+				got := make([]string, 0)
+				for _, stored := range page.Receipts {
+					got = append(got, stored.ID)
+					assert.Equal(t, ids[stored.Receipt.Retailer], stored.ID)
+					assert.NotEmpty(t, stored.Receipt.Items)
+				}
+				assert.Equal(t, want, got)
+			})
+		}
+	})
+
+	t.Run("search pagination", func(t *testing.T) {
+		repo := newRepository(t)
+		want := make([]string, 0)
+		for i := 0; i < 7; i++ {
+			id, err := repo.Store(sampleReceipt(fmt.Sprintf("Retailer %d", i)))
+			require.NoError(t, err)
+			want = append(want, id.String())
+		}
+		sort.Strings(want)
+
+		got := make([]string, 0)
+		query := store.Query{Limit: 3}
+		for pages := 1; ; pages++ {
+			page, err := repo.Search(query)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(page.Receipts), 3)
+			for _, stored := range page.Receipts {
+				got = append(got, stored.ID)
+			}
+			if page.NextCursor == "" {
+				assert.Equal(t, 3, pages)
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("search page size", func(t *testing.T) {
+		repo := newRepository(t)
+		for i := 0; i < store.MaxPageSize+1; i++ {
+			_, err := repo.Store(sampleReceipt(fmt.Sprintf("Retailer %d", i)))
+			require.NoError(t, err)
+		}
+
+		page, err := repo.Search(store.Query{})
+		require.NoError(t, err)
+		assert.Len(t, page.Receipts, store.DefaultPageSize)
+
+		page, err = repo.Search(store.Query{Limit: store.MaxPageSize + 1})
+		require.NoError(t, err)
+		assert.Len(t, page.Receipts, store.MaxPageSize)
+		assert.NotEmpty(t, page.NextCursor)
+	})
+
+	t.Run("search finds latest version", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(receipt("Target", "2022-01-01", "10.00", "Gatorade"))
+		require.NoError(t, err)
+		_, err = repo.Update(id.String(), receipt("Walgreens", "2022-02-01", "20.00", "Doritos"))
+		require.NoError(t, err)
+
+		for _, query := range []store.Query{{Retailer: "Target"}, {PurchasedTo: date("2022-01-15")},
+			{MaxTotalCents: cents(1500)}, {ItemDescription: "gatorade"}} {
+			page, err := repo.Search(query)
+			require.NoError(t, err)
+			assert.Empty(t, page.Receipts, "query %+v", query)
+		}
+		for _, query := range []store.Query{{Retailer: "Walgreens"}, {PurchasedFrom: date("2022-01-15")},
+			{MinTotalCents: cents(1500)}, {ItemDescription: "doritos"}} {
+			page, err := repo.Search(query)
+			require.NoError(t, err)
+			require.Len(t, page.Receipts, 1, "query %+v", query)
+			assert.Equal(t, 2, page.Receipts[0].Version)
+		}
+	})
+
+	t.Run("search excludes deleted receipts", func(t *testing.T) {
+		repo := newRepository(t)
+		id, err := repo.Store(sampleReceipt("Target"))
+		require.NoError(t, err)
+		require.NoError(t, repo.Delete(id.String()))
+
+		page, err := repo.Search(store.Query{Retailer: "Target", ItemDescription: "dew"})
+		require.NoError(t, err)
+		assert.Empty(t, page.Receipts)
+	})
+
+	t.Run("search with invalid cursor", func(t *testing.T) {
+		repo := newRepository(t)
+
+		_, err := repo.Search(store.Query{Cursor: "not a cursor!"})
+		assert.True(t, errors.Is(err, store.ErrInvalidCursor))
+	})
+
+	t.Run("concurrent access", func(t *testing.T) {
+		const workers = 10
+		const iterations = 50
+
+		repo := newRepository(t)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < iterations; i++ {
+					retailer := fmt.Sprintf("Retailer %d of worker %d", i, w)
+					id, err := repo.Store(sampleReceipt(retailer))
+					if !assert.NoError(t, err) {
+						return
+					}
+					got, err := repo.GetReceipt(id.String())
+					if assert.NoError(t, err) {
+						assert.Equal(t, retailer, got.Retailer)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		listed, err := repo.List()
+		require.NoError(t, err)
+		assert.Len(t, listed, workers*iterations)
+	})
+}
+
+func sampleReceipt(retailer string) model.Receipt {
+	return model.Receipt{
+		Retailer:     retailer,
+		PurchaseDate: mustParseDate("2022-01-01"),
+		PurchaseTime: "13:01",
+		Total:        "35.35",
+		Items: []model.Item{
+			{ShortDescription: "Mountain Dew 12PK", Price: "6.49"},
+			{ShortDescription: "Emils Cheese Pizza", Price: "12.25"},
+		},
+	}
+}
+
+func receipt(retailer, purchaseDate, total string, descriptions ...string) model.Receipt {
+	items := make([]model.Item, 0)
+	for _, description := range descriptions {
+		items = append(items, model.Item{ShortDescription: description, Price: "1.00"})
+	}
+	return model.Receipt{
+		Retailer:     retailer,
+		PurchaseDate: mustParseDate(purchaseDate),
+		PurchaseTime: "13:01",
+		Total:        total,
+		Items:        items,
+	}
+}
+
+func date(dateStr string) *time.Time {
+	parsed := mustParseDate(dateStr).Time
+	return &parsed
+}
+
+func cents(value int64) *int64 {
+	return &value
+}
+
+func mustParseDate(dateStr string) openapi_types.Date {
+	date, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		panic(err)
+	}
+	return openapi_types.Date{Time: date}
+}
@@ -1,10 +1,16 @@
 package utils
 
 import (
+	"errors"
 	"fetch-assessment/model"
-	"reflect"
-	"runtime"
+	"fetch-assessment/money"
+	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
+	// the time zone database is embedded, so that IANA time zone names resolve on hosts without one
+	_ "time/tzdata"
 	"unicode"
 )
 
@@ -18,11 +24,70 @@ func StripNonAlphanumeric(s string) string {
 	return string(result)
 }
 
-func GetFunctionName(rule func(model.Receipt) int) any {
-	funcName := runtime.FuncForPC(reflect.ValueOf(rule).Pointer()).Name()
-	return funcName
+func ParseTotal(receipt model.Receipt) (money.Amount, error) {
+	return money.Parse(receipt.Total)
 }
 
-func ParseTotal(receipt model.Receipt) (float64, error) {
-	return strconv.ParseFloat(receipt.Total, 64)
+// utcOffset matches UTC offsets such as +02:00 and -05:30.
+var utcOffset = regexp.MustCompile(`^([+-])(\d{2}):(\d{2})$`)
+
+// ParseTimeZone returns the location of an IANA time zone name, such as America/Chicago, or a UTC offset in the format
+// +HH:MM or -HH:MM. UTC and Z are accepted as names of UTC.
+func ParseTimeZone(zone string) (*time.Location, error) {
+	if zone == "UTC" || zone == "Z" {
+		return time.UTC, nil
+	}
+	if match := utcOffset.FindStringSubmatch(zone); match != nil {
+		hours, _ := strconv.Atoi(match[2])
+		minutes, _ := strconv.Atoi(match[3])
+		if hours > 14 || minutes > 59 {
+			return nil, fmt.Errorf("time zone offset %s is out of range", zone)
+		}
+		offset := (hours*60 + minutes) * 60
+		if match[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(zone, offset), nil
+	}
+	// LoadLocation reads "" as UTC and "Local" as the zone of the server, neither of which is meant here
+	if zone == "" || zone == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", zone)
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", zone)
+	}
+	return location, nil
+}
+
+// ParsePurchaseTime returns the hour and minute of a purchase time in the format HH:MM. Like the validation of
+// receipts, it accepts hours and minutes that are not zero-padded, such as 9:5.
+func ParsePurchaseTime(value string) (int, int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, 0, errors.New("purchase time must be in the format HH:MM")
+	}
+	hour, err1 := strconv.Atoi(parts[0])
+	minute, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, errors.New("purchase time must be in the format HH:MM")
+	}
+	return hour, minute, nil
+}
+
+// PurchaseInstant combines the purchase date and time of the receipt into an instant in the time zone of the receipt.
+// Receipts without a time zone are taken to be in fallback.
+func PurchaseInstant(receipt model.Receipt, fallback *time.Location) (time.Time, error) {
+	hour, minute, err := ParsePurchaseTime(receipt.PurchaseTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	location := fallback
+	if receipt.TimeZone != nil {
+		if location, err = ParseTimeZone(*receipt.TimeZone); err != nil {
+			return time.Time{}, err
+		}
+	}
+	year, month, day := receipt.PurchaseDate.Date()
+	return time.Date(year, month, day, hour, minute, 0, 0, location), nil
 }
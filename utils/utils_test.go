@@ -2,8 +2,12 @@ package utils
 
 import (
 	"fetch-assessment/model"
+	"fetch-assessment/money"
+	openapi_types "github.com/oapi-codegen/runtime/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestStripNonAlphanumeric(t *testing.T) {
@@ -81,7 +85,98 @@ func TestStripNonAlphanumeric(t *testing.T) {
 
 func TestParseTotal(t *testing.T) {
 	total, _ := ParseTotal(model.Receipt{Total: "100.00"})
-	assert.Equal(t, float64(100), total)
+	assert.Equal(t, money.Amount(10000), total)
 	total, _ = ParseTotal(model.Receipt{Total: "100.11"})
-	assert.Equal(t, 100.11, total)
+	assert.Equal(t, money.Amount(10011), total)
+	_, err := ParseTotal(model.Receipt{Total: "100.1"})
+	assert.ErrorIs(t, err, money.ErrInvalidAmount)
+}
+
+func TestParseTimeZone(t *testing.T) {
+	tests := []struct {
+		zone       string
+		wantOffset int
+		wantErr    string
+	}{
+		{zone: "UTC", wantOffset: 0},
+		{zone: "Z", wantOffset: 0},
+		{zone: "+02:00", wantOffset: 2 * 3600},
+		{zone: "-05:30", wantOffset: -(5*3600 + 30*60)},
+		{zone: "+14:00", wantOffset: 14 * 3600},
+		{zone: "Asia/Kolkata", wantOffset: 5*3600 + 30*60},
+		{zone: "+15:00", wantErr: "time zone offset +15:00 is out of range"},
+		{zone: "+02:60", wantErr: "time zone offset +02:60 is out of range"},
+		{zone: "+2:00", wantErr: `unknown time zone "+2:00"`},
+		{zone: "", wantErr: `unknown time zone ""`},
+		{zone: "Local", wantErr: `unknown time zone "Local"`},
+		{zone: "Europe/Nowhere", wantErr: `unknown time zone "Europe/Nowhere"`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.zone, func(t *testing.T) {
+			location, err := ParseTimeZone(tc.zone)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			_, offset := time.Date(2022, 1, 1, 0, 0, 0, 0, location).Zone()
+			assert.Equal(t, tc.wantOffset, offset)
+		})
+	}
+}
+
+func TestParsePurchaseTime(t *testing.T) {
+	tests := []struct {
+		value      string
+		wantHour   int
+		wantMinute int
+		wantErr    bool
+	}{
+		{value: "14:05", wantHour: 14, wantMinute: 5},
+		{value: "1:5", wantHour: 1, wantMinute: 5},
+		{value: "00:00"},
+		{value: "24:00", wantErr: true},
+		{value: "12:60", wantErr: true},
+		{value: "12", wantErr: true},
+		{value: "12:x", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.value, func(t *testing.T) {
+			hour, minute, err := ParsePurchaseTime(tc.value)
+			if tc.wantErr {
+				assert.EqualError(t, err, "purchase time must be in the format HH:MM")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantHour, hour)
+			assert.Equal(t, tc.wantMinute, minute)
+		})
+	}
+}
+
+func TestPurchaseInstant(t *testing.T) {
+	receipt := model.Receipt{
+		PurchaseDate: openapi_types.Date{Time: time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)},
+		PurchaseTime: "23:30",
+	}
+	instant, err := PurchaseInstant(receipt, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, "2022-07-01T23:30:00Z", instant.Format(time.RFC3339))
+
+	receipt.PurchaseTime = "9:5"
+	instant, err = PurchaseInstant(receipt, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, "2022-07-01T09:05:00Z", instant.Format(time.RFC3339))
+	receipt.PurchaseTime = "23:30"
+
+	zone := "America/Chicago"
+	receipt.TimeZone = &zone
+	instant, err = PurchaseInstant(receipt, time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, "2022-07-01T23:30:00-05:00", instant.Format(time.RFC3339))
+	assert.Equal(t, "2022-07-02T04:30:00Z", instant.UTC().Format(time.RFC3339))
+
+	zone = "Atlantis"
+	_, err = PurchaseInstant(receipt, time.UTC)
+	assert.EqualError(t, err, `unknown time zone "Atlantis"`)
 }
@@ -3,13 +3,16 @@ package validation
 import (
 	"errors"
 	"fetch-assessment/model"
-	"regexp"
+	"fetch-assessment/money"
+	"fetch-assessment/utils"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ValidateReceipt Validate the receipt. All properties are being validated, except for purchaseDate,
-// since the json parser would have failed if the date format was invalid.
+// since the json parser would have failed if the date format was invalid. The purchase date and time must combine
+// into an instant in the time zone of the receipt, if it has one.
 func ValidateReceipt(receipt model.Receipt) (bool, error) {
 
 	if len(receipt.Retailer) == 0 {
@@ -20,6 +23,10 @@ func ValidateReceipt(receipt model.Receipt) (bool, error) {
 		return false, errors.New("purchase time must be in the format HH:MM")
 	}
 
+	if _, err := utils.PurchaseInstant(receipt, time.UTC); err != nil {
+		return false, err
+	}
+
 	if !validateItems(receipt.Items) {
 		return false, errors.New("invalid items")
 	}
@@ -48,7 +55,8 @@ func validateItems(items []model.Item) bool {
 }
 
 func validatePrice(price string) bool {
-	return regexp.MustCompile(`^\d+\.\d{2}$`).MatchString(price)
+	_, err := money.Parse(price)
+	return err == nil
 }
 
 func isValidPurchaseTime(purchaseTime string) bool {
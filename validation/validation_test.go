@@ -92,6 +92,20 @@ func TestValidateReceipt(t *testing.T) {
 			want:    false,
 			wantErr: "invalid total",
 		},
+		{
+			name: "total out of range",
+			receipt: model.Receipt{
+				Retailer: "Retailer",
+				Items: []model.Item{{
+					Price:            "1.00",
+					ShortDescription: "first",
+				}},
+				PurchaseTime: "15:30",
+				Total:        "100000000000000000000.00",
+			},
+			want:    false,
+			wantErr: "invalid total",
+		},
 		{
 			name: "invalid time",
 			receipt: model.Receipt{
@@ -143,6 +157,49 @@ func TestValidateReceipt(t *testing.T) {
 			want:    false,
 			wantErr: "purchase time must be in the format HH:MM",
 		},
+		{
+			name: "valid time - not zero-padded",
+			receipt: model.Receipt{
+				Retailer: "Retailer",
+				Items: []model.Item{{
+					Price:            "1.00",
+					ShortDescription: "first",
+				}},
+				PurchaseTime: "1:5",
+				TimeZone:     ptr("-05:00"),
+				Total:        "1.00",
+			},
+			want: true,
+		},
+		{
+			name: "valid time zone",
+			receipt: model.Receipt{
+				Retailer: "Retailer",
+				Items: []model.Item{{
+					Price:            "1.00",
+					ShortDescription: "first",
+				}},
+				PurchaseTime: "15:30",
+				TimeZone:     ptr("-05:00"),
+				Total:        "1.00",
+			},
+			want: true,
+		},
+		{
+			name: "unknown time zone",
+			receipt: model.Receipt{
+				Retailer: "Retailer",
+				Items: []model.Item{{
+					Price:            "1.00",
+					ShortDescription: "first",
+				}},
+				PurchaseTime: "15:30",
+				TimeZone:     ptr("Mars/Olympus_Mons"),
+				Total:        "1.00",
+			},
+			want:    false,
+			wantErr: `unknown time zone "Mars/Olympus_Mons"`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -161,3 +218,7 @@ func TestValidateReceipt(t *testing.T) {
 		})
 	}
 }
+
+func ptr(s string) *string {
+	return &s
+}